@@ -8,13 +8,16 @@ import (
 	"syscall"
 
 	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/mail"
 	"mail-dispatcher/internal/models"
 	"mail-dispatcher/internal/routes"
+	"mail-dispatcher/internal/secret"
 	"mail-dispatcher/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func main() {
@@ -23,30 +26,93 @@ func main() {
 
 	fmt.Println(cfg)
 
+	// load credential encryption keys
+	keyring, err := secret.LoadKeys(cfg.Security.MasterKey, cfg.Security.KeyFile)
+	if err != nil {
+		log.Fatalf("load master key failed: %v", err)
+	}
+	if keyring == nil {
+		log.Println("警告: 未配置主密钥 (SECRET_MASTER_KEY 或 SECRET_KEY_FILE)，账户凭据将以明文存储")
+	}
+	secret.SetKeyring(keyring)
+
 	// init database
 	db, err := initDatabase(cfg)
 	if err != nil {
 		log.Fatalf("init database failed: %v", err)
 	}
 
+	// 升级前的账户在处理邮件时会隐式标记已读，新增处理后操作列时需要为其保留该行为
+	upgradeMailboxActions := db.Migrator().HasTable(&models.MailAccount{}) && !db.Migrator().HasColumn(&models.MailAccount{}, "DispatchedActions")
+
 	// auto migrate database tables
-	if err := db.AutoMigrate(&models.ForwardTarget{}, &models.MailAccount{}, &models.MailLog{}); err != nil {
+	if err := db.AutoMigrate(&models.ForwardTarget{}, &models.MailAccount{}, &models.MailLog{}, &models.TargetGroup{}, &models.RoutingRule{}, &models.OutboundMessage{}, &models.DeliveryAttempt{}, &models.OAuthState{}, &models.APIKey{}, &models.AuditEvent{}, &models.DKIMKey{}, &models.FolderState{}); err != nil {
 		log.Fatalf("database migration failed: %v", err)
 	}
 
+	// 加密旧的明文凭据，并将轮换前的凭据改为使用当前主密钥
+	if count, err := secret.Rotate(db, "mail_accounts", "password", "smtp_password", "oauth_access_token", "oauth_refresh_token"); err != nil {
+		log.Fatalf("rotate credentials failed: %v", err)
+	} else if count > 0 {
+		log.Printf("已使用主密钥 %s 重新加密 %d 个账户的凭据", keyring.CurrentKeyID(), count)
+	}
+	if count, err := secret.Rotate(db, "dkim_keys", "private_key"); err != nil {
+		log.Fatalf("rotate dkim keys failed: %v", err)
+	} else if count > 0 {
+		log.Printf("已使用主密钥 %s 重新加密 %d 个DKIM私钥", keyring.CurrentKeyID(), count)
+	}
+
+	// 为升级前创建的账户补全SMTP配置
+	if err := backfillSMTPSettings(db); err != nil {
+		log.Fatalf("backfill smtp settings failed: %v", err)
+	}
+
+	// 将升级前保存在账户上的收件箱水位线迁移到文件夹水位线
+	if err := backfillFolderStates(db); err != nil {
+		log.Fatalf("backfill folder states failed: %v", err)
+	}
+
+	// 升级前创建的账户转发成功后继续标记原邮件为已读
+	if upgradeMailboxActions {
+		if err := backfillDispatchedActions(db); err != nil {
+			log.Fatalf("backfill dispatched actions failed: %v", err)
+		}
+	}
+
 	// init services
 	logService := services.NewLogService(db)
+	auditService := services.NewAuditService(db)
+
+	// 初始化API认证服务，首次启动时生成管理员密钥
+	authService := services.NewAuthService(db, cfg)
+	if !authService.Enabled() {
+		log.Println("警告: 已关闭API认证 (AUTH_ENABLED=false)，任何人都可以管理账户和凭据")
+	} else if key, err := authService.EnsureBootstrapKey(); err != nil {
+		log.Fatalf("create bootstrap api key failed: %v", err)
+	} else if key != "" {
+		log.Printf("已生成初始管理员API密钥，请妥善保存并创建新的密钥后吊销: %s", key)
+	}
+
+	// 初始化OAuth2授权服务
+	oauthService := services.NewOAuthService(db, cfg)
 
 	// 初始化发送服务
-	senderService := services.NewSenderService(db, cfg)
+	senderService := services.NewSenderService(db, oauthService, cfg)
+
+	// 初始化投递服务
+	deliveryService := services.NewDeliveryService(db, senderService, cfg)
 
 	// 初始化邮件路由服务
-	mailRoutingService := services.NewMailRoutingService(db, senderService, logService)
+	mailRoutingService := services.NewMailRoutingService(db, deliveryService, logService, cfg)
+
+	// 初始化手动重试服务
+	retryService := services.NewRetryService(db, senderService, mailRoutingService, deliveryService)
 
 	// 初始化调度器服务
-	schedulerService := services.NewSchedulerService(db, mailRoutingService, cfg)
+	schedulerService := services.NewSchedulerService(db, mailRoutingService, oauthService, cfg)
 
-	// 启动调度器
+	// 启动投递服务和调度器
+	deliveryService.Start()
 	schedulerService.Start()
 
 	// 设置Gin模式
@@ -60,7 +126,7 @@ func main() {
 	router.Use(gin.Recovery())
 
 	// 设置路由
-	routes.SetupRoutes(router, db, logService)
+	routes.SetupRoutes(router, db, logService, retryService, oauthService, authService, auditService, cfg)
 
 	// 启动HTTP服务器
 	go func() {
@@ -78,8 +144,10 @@ func main() {
 
 	log.Println("正在关闭服务器...")
 
-	// 停止调度器
+	// 停止调度器和批量重试，再等待队列中正在进行的投递完成
 	schedulerService.Stop()
+	retryService.Stop()
+	deliveryService.Stop()
 
 	log.Println("服务器已关闭")
 }
@@ -87,7 +155,8 @@ func main() {
 // initDatabase init database connection
 func initDatabase(cfg *config.Config) (*gorm.DB, error) {
 	dsn := cfg.GetDSN()
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// 将唯一索引冲突转换为 gorm.ErrDuplicatedKey，用于识别重复处理的邮件
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
@@ -104,3 +173,68 @@ func initDatabase(cfg *config.Config) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// backfillSMTPSettings fill in smtp settings for accounts created before they were configurable
+func backfillSMTPSettings(db *gorm.DB) error {
+	var accounts []models.MailAccount
+	if err := db.Where("smtp_host = ? OR smtp_host IS NULL", "").Find(&accounts).Error; err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		mail.ApplySMTPDefaults(&account)
+		if err := db.Model(&account).UpdateColumns(models.MailAccount{
+			SMTPHost:     account.SMTPHost,
+			SMTPPort:     account.SMTPPort,
+			SMTPSecurity: account.SMTPSecurity,
+			SMTPAuth:     account.SMTPAuth,
+		}).Error; err != nil {
+			return err
+		}
+		log.Printf("账户 %s 已使用推测的SMTP配置: %s:%d (%s)", account.Address, account.SMTPHost, account.SMTPPort, account.SMTPSecurity)
+	}
+
+	return nil
+}
+
+// backfillDispatchedActions keep marking mail as seen after dispatch for accounts created before mailbox actions were configurable
+func backfillDispatchedActions(db *gorm.DB) error {
+	result := db.Model(&models.MailAccount{}).Where("dispatched_actions IS NULL").UpdateColumns(models.MailAccount{
+		DispatchedActions: []models.MailboxAction{{Type: models.MailboxActionSeen}},
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("已为 %d 个升级前创建的账户设置转发成功后标记已读", result.RowsAffected)
+	}
+
+	return nil
+}
+
+// backfillFolderStates move the inbox uid watermark of accounts created before multi-folder monitoring into folder states
+func backfillFolderStates(db *gorm.DB) error {
+	var accounts []models.MailAccount
+	if err := db.Where("last_uid > ?", 0).Find(&accounts).Error; err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		state := models.FolderState{
+			AccountID:   account.ID,
+			Folder:      mail.DefaultFolder,
+			UIDValidity: account.UIDValidity,
+			LastUID:     account.LastUID,
+		}
+		// 已有水位线的文件夹保持不变，重复启动不会覆盖新的进度
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&state)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("账户 %s 的收件箱水位线已迁移: UID %d", account.Address, account.LastUID)
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config 应用配置
@@ -10,6 +11,9 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	Mail     MailConfig
+	Security SecurityConfig
+	OAuth    OAuthConfig
+	Auth     AuthConfig
 }
 
 // ServerConfig 服务器配置
@@ -30,9 +34,68 @@ type DatabaseConfig struct {
 
 // MailConfig 邮件处理配置
 type MailConfig struct {
-	PollingInterval int
-	MaxRetryCount   int
-	RetryInterval   int
+	PollingInterval  int
+	MaxRetryCount    int
+	RetryInterval    int
+	DeliveryWorkers  int
+	PollWorkers      int
+	AuthFailureLimit int
+	MaxHops          int
+}
+
+// SecurityConfig 凭据加密配置
+type SecurityConfig struct {
+	MasterKey string
+	KeyFile   string
+	SRSSecret string
+	SRSDomain string // SRS改写后信封发件人使用的域名，需由本系统的运营方控制并处理退信
+}
+
+// SRSEnabled 判断是否配置了SRS，改写信封发件人需要密钥和域名
+func (c SecurityConfig) SRSEnabled() bool {
+	return c.SRSSecret != "" && c.SRSDomain != ""
+}
+
+// AuthConfig API认证配置
+type AuthConfig struct {
+	Enabled   bool
+	JWTSecret string
+}
+
+// String 打印配置时隐藏JWT密钥
+func (c AuthConfig) String() string {
+	return "{Enabled:" + strconv.FormatBool(c.Enabled) + " JWTSecret:" + redact(c.JWTSecret) + "}"
+}
+
+// OAuthConfig OAuth2 应用配置，未配置客户端ID的提供方不可用
+type OAuthConfig struct {
+	RedirectURL           string
+	GoogleClientID        string
+	GoogleClientSecret    string
+	MicrosoftClientID     string
+	MicrosoftClientSecret string
+	MicrosoftTenant       string
+}
+
+// String 打印配置时隐藏客户端密钥
+func (c OAuthConfig) String() string {
+	return "{RedirectURL:" + c.RedirectURL +
+		" GoogleClientID:" + c.GoogleClientID + " GoogleClientSecret:" + redact(c.GoogleClientSecret) +
+		" MicrosoftClientID:" + c.MicrosoftClientID + " MicrosoftClientSecret:" + redact(c.MicrosoftClientSecret) +
+		" MicrosoftTenant:" + c.MicrosoftTenant + "}"
+}
+
+// String 打印配置时隐藏主密钥和SRS密钥
+func (c SecurityConfig) String() string {
+	return "{MasterKey:" + redact(c.MasterKey) + " KeyFile:" + c.KeyFile + " SRSSecret:" + redact(c.SRSSecret) + " SRSDomain:" + c.SRSDomain + "}"
+}
+
+// redact 隐藏敏感配置，未配置时保持为空
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "******"
 }
 
 // LoadConfig 加载配置
@@ -51,9 +114,31 @@ func LoadConfig() *Config {
 			Charset:  getEnv("DB_CHARSET", "utf8mb4"),
 		},
 		Mail: MailConfig{
-			PollingInterval: getEnvInt("MAIL_POLLING_INTERVAL", 300),
-			MaxRetryCount:   getEnvInt("MAIL_MAX_RETRY_COUNT", 3),
-			RetryInterval:   getEnvInt("MAIL_RETRY_INTERVAL", 60),
+			PollingInterval:  getEnvInt("MAIL_POLLING_INTERVAL", 300),
+			MaxRetryCount:    getEnvInt("MAIL_MAX_RETRY_COUNT", 3),
+			RetryInterval:    getEnvInt("MAIL_RETRY_INTERVAL", 60),
+			DeliveryWorkers:  getEnvInt("MAIL_DELIVERY_WORKERS", 4),
+			PollWorkers:      getEnvInt("MAIL_POLL_WORKERS", 4),
+			AuthFailureLimit: getEnvInt("MAIL_AUTH_FAILURE_LIMIT", 3),
+			MaxHops:          getEnvInt("MAIL_MAX_HOPS", 5),
+		},
+		Security: SecurityConfig{
+			MasterKey: getEnv("SECRET_MASTER_KEY", ""),
+			KeyFile:   getEnv("SECRET_KEY_FILE", ""),
+			SRSSecret: getEnv("SRS_SECRET", ""),
+			SRSDomain: strings.ToLower(strings.TrimSuffix(getEnv("SRS_DOMAIN", ""), ".")),
+		},
+		OAuth: OAuthConfig{
+			RedirectURL:           getEnv("OAUTH_REDIRECT_URL", "http://localhost:8080/api/v1/oauth/callback"),
+			GoogleClientID:        getEnv("OAUTH_GOOGLE_CLIENT_ID", ""),
+			GoogleClientSecret:    getEnv("OAUTH_GOOGLE_CLIENT_SECRET", ""),
+			MicrosoftClientID:     getEnv("OAUTH_MICROSOFT_CLIENT_ID", ""),
+			MicrosoftClientSecret: getEnv("OAUTH_MICROSOFT_CLIENT_SECRET", ""),
+			MicrosoftTenant:       getEnv("OAUTH_MICROSOFT_TENANT", "common"),
+		},
+		Auth: AuthConfig{
+			Enabled:   getEnvBool("AUTH_ENABLED", true),
+			JWTSecret: getEnv("AUTH_JWT_SECRET", ""),
 		},
 	}
 }
@@ -76,6 +161,16 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvBool 获取布尔环境变量
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 // GetDSN 获取数据库连接字符串
 func (c *Config) GetDSN() string {
 	return c.Database.User + ":" + c.Database.Password + "@tcp(" +
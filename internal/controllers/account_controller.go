@@ -1,52 +1,206 @@
 package controllers
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/mail"
 	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"gorm.io/gorm"
 )
 
 // AccountController 邮箱账户控制器
 type AccountController struct {
-	db *gorm.DB
+	db           *gorm.DB
+	oauthService *services.OAuthService
+	auditService *services.AuditService
+	discoverer   *mail.Discoverer
+	config       *config.Config
 }
 
 // NewAccountController 创建邮箱账户控制器
-func NewAccountController(db *gorm.DB) *AccountController {
-	return &AccountController{db: db}
-}
-
-// getIMAPServer 根据邮箱地址自动获取对应的IMAP服务器
-func getIMAPServer(email string) string {
-	email = strings.ToLower(email)
-
-	switch {
-	case strings.Contains(email, "@gmail.com"):
-		return "imap.gmail.com:993"
-	case strings.Contains(email, "@qq.com"):
-		return "imap.qq.com:993"
-	case strings.Contains(email, "@163.com"):
-		return "imap.163.com:993"
-	case strings.Contains(email, "@126.com"):
-		return "imap.126.com:993"
-	case strings.Contains(email, "@outlook.com") || strings.Contains(email, "@hotmail.com"):
-		return "outlook.office365.com:993"
-	case strings.Contains(email, "@yahoo.com"):
-		return "imap.mail.yahoo.com:993"
-	case strings.Contains(email, "@sina.com"):
-		return "imap.sina.com:993"
-	case strings.Contains(email, "@sohu.com"):
-		return "imap.sohu.com:993"
+func NewAccountController(db *gorm.DB, oauthService *services.OAuthService, auditService *services.AuditService, cfg *config.Config) *AccountController {
+	return &AccountController{
+		db:           db,
+		oauthService: oauthService,
+		auditService: auditService,
+		discoverer:   mail.NewDiscoverer(),
+		config:       cfg,
+	}
+}
+
+// accountResetFields 更新账户时可以显式设置为零值的字段，指针为 nil 表示请求中没有该字段
+type accountResetFields struct {
+	MaxSize        *int64
+	PollInterval   *int
+	TLSCACert      *string
+	TLSFingerprint *string
+}
+
+// errSRSNotConfigured 未配置SRS时不能启用 srs 信封发件人模式
+const errSRSNotConfigured = "未配置 SRS_SECRET 和 SRS_DOMAIN，不能使用 srs 信封发件人模式"
+
+// discoverTimeout 自动发现服务器配置的超时时间
+const discoverTimeout = 20 * time.Second
+
+// discoverServer 根据邮箱地址发现服务器配置
+func (c *AccountController) discoverServer(ctx context.Context, email string) (mail.ServerSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+	return c.discoverer.Discover(ctx, email)
+}
+
+// isValidFetchMode 校验收信模式，空值表示使用默认的轮询模式
+func isValidFetchMode(mode string) bool {
+	switch mode {
+	case "", models.FetchModePoll, models.FetchModePush:
+		return true
+	}
+	return false
+}
+
+// isValidFromMode 校验转发时的发件人模式，空值表示保留原发件人
+func isValidFromMode(mode string) bool {
+	switch mode {
+	case "", models.FromModeOriginal, models.FromModeRewrite:
+		return true
+	}
+	return false
+}
+
+// isValidEnvelopeMode 校验信封发件人模式，空值表示使用账户地址
+func isValidEnvelopeMode(mode string) bool {
+	switch mode {
+	case "", models.EnvelopeModeAccount, models.EnvelopeModeSRS:
+		return true
+	}
+	return false
+}
+
+// isValidAuthType 校验认证方式，空值表示使用密码认证
+func isValidAuthType(authType string) bool {
+	switch authType {
+	case "", models.AuthTypePassword, models.AuthTypeOAuth2:
+		return true
+	}
+	return false
+}
+
+// validateSMTP 校验SMTP配置，返回的错误信息为空表示校验通过
+func validateSMTP(account *models.MailAccount) string {
+	if account.SMTPHost == "" {
+		return "SMTP服务器不能为空"
+	}
+	if account.SMTPPort <= 0 || account.SMTPPort > 65535 {
+		return "SMTP端口无效"
+	}
+
+	switch account.SMTPSecurity {
+	case models.SMTPSecurityTLS, models.SMTPSecurityStartTLS, models.SMTPSecurityNone:
 	default:
-		// 对于其他邮箱，尝试使用通用的IMAP服务器
-		// 用户可以在创建后手动修改
-		return "imap.gmail.com:993"
+		return "SMTP安全模式只能为 tls、starttls 或 none"
 	}
+
+	switch account.SMTPAuth {
+	case models.SMTPAuthPlain, models.SMTPAuthLogin:
+		if account.SMTPSecurity == models.SMTPSecurityNone {
+			return "未加密的SMTP连接不能使用 plain 或 login 认证"
+		}
+	case models.SMTPAuthCRAMMD5, models.SMTPAuthNone:
+	default:
+		return "SMTP认证方式只能为 plain、login、cram-md5 或 none"
+	}
+
+	return ""
+}
+
+// applyAccountDefaults 为新账户补全服务器、TLS和SMTP配置并校验，返回的错误信息为空表示校验通过
+// 未填写IMAP服务器时根据邮箱地址自动发现，发现的SMTP配置只用于补全未填写的字段
+func (c *AccountController) applyAccountDefaults(ctx context.Context, account *models.MailAccount) string {
+	if account.Server == "" {
+		settings, err := c.discoverServer(ctx, account.Address)
+		if err != nil {
+			return err.Error()
+		}
+		settings.Apply(account)
+	}
+	if account.TLSMode == "" {
+		account.TLSMode = models.TLSModeVerify
+	}
+	if msg := validateTLS(account); msg != "" {
+		return msg
+	}
+	mail.ApplySMTPDefaults(account)
+	return validateSMTP(account)
+}
+
+// validatePollSchedule 校验轮询间隔和活跃时段
+func validatePollSchedule(account *models.MailAccount) string {
+	if account.PollInterval < 0 {
+		return "轮询间隔不能为负数"
+	}
+	if _, err := services.ParsePollSchedule(account.PollSchedule); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// validateMailboxActions 校验转发成功和路由失败后的处理后操作
+func validateMailboxActions(account *models.MailAccount) string {
+	if err := mail.ValidateMailboxActions(account.DispatchedActions); err != nil {
+		return "转发成功后的操作无效: " + err.Error()
+	}
+	if err := mail.ValidateMailboxActions(account.FailedActions); err != nil {
+		return "路由失败后的操作无效: " + err.Error()
+	}
+	return ""
+}
+
+// normalizeFolders 去除文件夹名称两端的空白并校验，不允许空名称和重复的文件夹
+func normalizeFolders(folders []string) ([]string, string) {
+	normalized := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		folder = strings.TrimSpace(folder)
+		if folder == "" {
+			return nil, "文件夹名称不能为空"
+		}
+		for _, existing := range normalized {
+			if mail.SameFolder(existing, folder) {
+				return nil, "文件夹重复: " + folder
+			}
+		}
+		normalized = append(normalized, folder)
+	}
+	return normalized, ""
+}
+
+// validateTLS 校验IMAP安全模式和证书校验策略，返回的错误信息为空表示校验通过
+func validateTLS(account *models.MailAccount) string {
+	switch account.IMAPSecurity {
+	case "", models.IMAPSecurityTLS, models.IMAPSecurityStartTLS:
+	default:
+		return "IMAP安全模式只能为 tls 或 starttls"
+	}
+
+	switch account.TLSMode {
+	case models.TLSModeVerify, models.TLSModePin, models.TLSModeInsecure:
+	default:
+		return "证书校验模式只能为 verify、pin 或 insecure"
+	}
+
+	policy := mail.TLSPolicy{Mode: account.TLSMode, CACert: account.TLSCACert, Fingerprints: account.TLSFingerprint}
+	if _, err := policy.Config(""); err != nil {
+		return "TLS配置错误: " + err.Error()
+	}
+	return ""
 }
 
 // GetAccounts 获取所有邮箱账户
@@ -88,9 +242,67 @@ func (c *AccountController) CreateAccount(ctx *gin.Context) {
 		return
 	}
 
-	// 验证必填字段
-	if account.Address == "" || account.Username == "" || account.Password == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "邮箱地址、用户名和密码不能为空"})
+	if !isValidAuthType(account.AuthType) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "认证方式只能为 password 或 oauth2"})
+		return
+	}
+
+	// 验证必填字段，OAuth2 账户创建后通过授权链接关联，不需要密码
+	if account.Address == "" || account.Username == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "邮箱地址和用户名不能为空"})
+		return
+	}
+	if account.AuthType != models.AuthTypeOAuth2 && account.Password == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "密码不能为空"})
+		return
+	}
+
+	// 令牌只能通过授权回调写入
+	account.OAuthAccessToken = ""
+	account.OAuthRefreshToken = ""
+	account.OAuthExpiry = nil
+
+	// 轮询和健康状态由调度器维护
+	account.PollFailures = 0
+	account.NextPollAt = nil
+	account.HealthState = ""
+	account.LastSuccessAt = nil
+	account.LastError = ""
+	account.LastErrorAt = nil
+	account.AuthFailures = 0
+
+	if !isValidFetchMode(account.FetchMode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "收信模式只能为 poll 或 push"})
+		return
+	}
+	if !isValidFromMode(account.FromMode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "发件人模式只能为 original 或 rewrite"})
+		return
+	}
+	if !isValidEnvelopeMode(account.EnvelopeMode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "信封发件人模式只能为 account 或 srs"})
+		return
+	}
+	if account.EnvelopeMode == models.EnvelopeModeSRS && !c.config.Security.SRSEnabled() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": errSRSNotConfigured})
+		return
+	}
+	if msg := validateMailboxActions(&account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	folders, msg := normalizeFolders(account.Folders)
+	if msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	account.Folders = folders
+	if account.MaxSize < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "大小上限不能为负数"})
+		return
+	}
+	if msg := validatePollSchedule(&account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
 
@@ -101,15 +313,29 @@ func (c *AccountController) CreateAccount(ctx *gin.Context) {
 		return
 	}
 
-	// 设置默认值
-	if account.Server == "" {
-		account.Server = getIMAPServer(account.Address)
+	if msg := c.applyAccountDefaults(ctx.Request.Context(), &account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// 指定 test=true 时只保存能通过连接测试的账户
+	if ctx.Query("test") == "true" {
+		result, err := services.CheckAccount(account, c.oauthService)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !result.OK {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "连接测试未通过", "data": result})
+			return
+		}
 	}
 
 	if err := c.db.Create(&account).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建邮箱账户失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "account", account.ID, nil, account)
 
 	ctx.JSON(http.StatusCreated, gin.H{"data": account})
 }
@@ -127,36 +353,186 @@ func (c *AccountController) UpdateAccount(ctx *gin.Context) {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "邮箱账户不存在"})
 		return
 	}
+	before := account
 
 	var updateData models.MailAccount
-	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+	var reset accountResetFields
+	if err := ctx.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	if err := ctx.ShouldBindBodyWith(&reset, binding.JSON); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
 		return
 	}
 
 	// 更新字段
 	if updateData.Address != "" {
+		// 邮箱地址改变且未指定IMAP服务器时，按新地址重新发现IMAP和SMTP配置，同时提交的字段随后覆盖发现的结果
+		if !strings.EqualFold(updateData.Address, account.Address) && updateData.Server == "" {
+			settings, err := c.discoverServer(ctx.Request.Context(), updateData.Address)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			account.Server, account.IMAPSecurity = "", ""
+			account.SMTPHost, account.SMTPPort, account.SMTPSecurity = "", 0, ""
+			settings.Apply(&account)
+		}
 		account.Address = updateData.Address
-		// 如果邮箱地址改变，自动更新服务器
-		account.Server = getIMAPServer(updateData.Address)
 	}
 	if updateData.Username != "" {
 		account.Username = updateData.Username
 	}
-	if updateData.Password != "" {
+	// 响应中的凭据是占位符，原样提交时保持不变
+	if updateData.Password != "" && updateData.Password != models.RedactedValue {
 		account.Password = updateData.Password
 	}
 	if updateData.Server != "" {
 		account.Server = updateData.Server
 	}
+	if updateData.IMAPSecurity != "" {
+		account.IMAPSecurity = updateData.IMAPSecurity
+	}
+	if updateData.TLSMode != "" {
+		account.TLSMode = updateData.TLSMode
+	}
+	// 提交空字符串表示清除自定义CA或固定的指纹，随后按证书校验模式重新校验
+	if reset.TLSCACert != nil {
+		account.TLSCACert = *reset.TLSCACert
+	}
+	if reset.TLSFingerprint != nil {
+		account.TLSFingerprint = *reset.TLSFingerprint
+	}
+	if updateData.AuthType != "" {
+		if !isValidAuthType(updateData.AuthType) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "认证方式只能为 password 或 oauth2"})
+			return
+		}
+		if updateData.AuthType == models.AuthTypeOAuth2 && account.OAuthRefreshToken == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "账户尚未关联OAuth2授权，请先通过授权链接关联"})
+			return
+		}
+		if updateData.AuthType == models.AuthTypePassword && account.Password == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "账户未设置密码，无法改用密码认证"})
+			return
+		}
+		account.AuthType = updateData.AuthType
+	}
 	if updateData.Settings != "" {
 		account.Settings = updateData.Settings
 	}
+	if updateData.FetchMode != "" {
+		if !isValidFetchMode(updateData.FetchMode) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "收信模式只能为 poll 或 push"})
+			return
+		}
+		account.FetchMode = updateData.FetchMode
+	}
+	if updateData.FromMode != "" {
+		if !isValidFromMode(updateData.FromMode) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "发件人模式只能为 original 或 rewrite"})
+			return
+		}
+		account.FromMode = updateData.FromMode
+	}
+	if updateData.EnvelopeMode != "" {
+		if !isValidEnvelopeMode(updateData.EnvelopeMode) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "信封发件人模式只能为 account 或 srs"})
+			return
+		}
+		if updateData.EnvelopeMode == models.EnvelopeModeSRS && !c.config.Security.SRSEnabled() {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": errSRSNotConfigured})
+			return
+		}
+		account.EnvelopeMode = updateData.EnvelopeMode
+	}
+	// 提交空列表表示清除处理后操作
+	if updateData.DispatchedActions != nil || updateData.FailedActions != nil {
+		if msg := validateMailboxActions(&updateData); msg != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if updateData.DispatchedActions != nil {
+			account.DispatchedActions = updateData.DispatchedActions
+		}
+		if updateData.FailedActions != nil {
+			account.FailedActions = updateData.FailedActions
+		}
+	}
+	// 提交空列表表示恢复为只监控收件箱
+	if updateData.Folders != nil {
+		folders, msg := normalizeFolders(updateData.Folders)
+		if msg != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		account.Folders = folders
+	}
+	// 提交 0 表示取消大小上限
+	if reset.MaxSize != nil {
+		if *reset.MaxSize < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "大小上限不能为负数"})
+			return
+		}
+		account.MaxSize = *reset.MaxSize
+	}
+	// 提交 0 表示改回使用全局轮询间隔
+	if reset.PollInterval != nil || updateData.PollSchedule != "" {
+		if msg := validatePollSchedule(&updateData); msg != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if reset.PollInterval != nil {
+			account.PollInterval = *reset.PollInterval
+		}
+		if updateData.PollSchedule != "" {
+			account.PollSchedule = updateData.PollSchedule
+		}
+		// 按新的计划重新安排下次轮询
+		account.NextPollAt = nil
+	}
+	if updateData.SMTPHost != "" {
+		account.SMTPHost = updateData.SMTPHost
+	}
+	if updateData.SMTPPort != 0 {
+		account.SMTPPort = updateData.SMTPPort
+	}
+	if updateData.SMTPSecurity != "" {
+		account.SMTPSecurity = updateData.SMTPSecurity
+	}
+	if updateData.SMTPAuth != "" {
+		account.SMTPAuth = updateData.SMTPAuth
+	}
+	if updateData.SMTPUsername != "" {
+		account.SMTPUsername = updateData.SMTPUsername
+	}
+	if updateData.SMTPPassword != "" && updateData.SMTPPassword != models.RedactedValue {
+		account.SMTPPassword = updateData.SMTPPassword
+	}
+	if account.TLSMode == "" {
+		account.TLSMode = models.TLSModeVerify
+	}
+	if msg := validateTLS(&account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	mail.ApplySMTPDefaults(&account)
+	if msg := validateSMTP(&account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// 登录凭据变化后重新计算连续认证失败次数
+	if account.Username != before.Username || account.Password != before.Password || account.AuthType != before.AuthType {
+		account.AuthFailures = 0
+	}
 
 	if err := c.db.Save(&account).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新邮箱账户失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditUpdate, "account", account.ID, before, account)
 
 	ctx.JSON(http.StatusOK, gin.H{"data": account})
 }
@@ -179,6 +555,7 @@ func (c *AccountController) DeleteAccount(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除邮箱账户失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "account", account.ID, account, nil)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "邮箱账户删除成功"})
 }
@@ -197,15 +574,157 @@ func (c *AccountController) ToggleAccountStatus(ctx *gin.Context) {
 		return
 	}
 
+	before := account
 	account.IsActive = !account.IsActive
+	// 重新启用时清除退避和自动停用状态，尽快轮询
+	if account.IsActive {
+		account.PollFailures = 0
+		account.NextPollAt = nil
+		account.AuthFailures = 0
+		account.HealthState = models.HealthHealthy
+	}
 
 	if err := c.db.Save(&account).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新账户状态失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditToggle, "account", account.ID, before, account)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"data":    account,
 		"message": "账户状态更新成功",
 	})
 }
+
+// GetAccountHealth 获取账户的连接健康状态
+func (c *AccountController) GetAccountHealth(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var account models.MailAccount
+	if err := c.db.First(&account, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "邮箱账户不存在"})
+		return
+	}
+
+	state := account.HealthState
+	if !account.IsActive && state != models.HealthPaused {
+		state = models.HealthDisabled
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"AccountID":           account.ID,
+			"Address":             account.Address,
+			"State":               state,
+			"IsActive":            account.IsActive,
+			"LastSuccessAt":       account.LastSuccessAt,
+			"LastError":           account.LastError,
+			"LastErrorAt":         account.LastErrorAt,
+			"ConsecutiveFailures": account.PollFailures,
+			"AuthFailures":        account.AuthFailures,
+			"NextPollAt":          account.NextPollAt,
+		},
+	})
+}
+
+// DiscoverSettings 根据邮箱地址发现IMAP和SMTP服务器配置
+func (c *AccountController) DiscoverSettings(ctx *gin.Context) {
+	email := ctx.Query("email")
+	if email == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "邮箱地址不能为空"})
+		return
+	}
+
+	settings, err := c.discoverServer(ctx.Request.Context(), email)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": settings})
+}
+
+// CheckConnection 测试尚未保存的账户配置，依次登录IMAP、列出文件夹并完成SMTP认证
+func (c *AccountController) CheckConnection(ctx *gin.Context) {
+	var account models.MailAccount
+	if err := ctx.ShouldBindJSON(&account); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	if account.Address == "" || account.Username == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "邮箱地址和用户名不能为空"})
+		return
+	}
+	if msg := c.applyAccountDefaults(ctx.Request.Context(), &account); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	result, err := services.CheckAccount(account, c.oauthService)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// CheckAccountConnection 使用已保存的配置测试账户连接
+func (c *AccountController) CheckAccountConnection(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var account models.MailAccount
+	if err := c.db.First(&account, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "邮箱账户不存在"})
+		return
+	}
+
+	result, err := services.CheckAccount(account, c.oauthService)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// ListAccountFolders 列出账户服务器上的文件夹，包括其他用户和共享命名空间，并标记正在监控的文件夹
+func (c *AccountController) ListAccountFolders(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var account models.MailAccount
+	if err := c.db.First(&account, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "邮箱账户不存在"})
+		return
+	}
+
+	folders, err := services.ListAccountFolders(account, c.oauthService)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "获取文件夹列表失败: " + err.Error()})
+		return
+	}
+
+	monitored := account.Folders
+	if len(monitored) == 0 {
+		monitored = []string{mail.DefaultFolder}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":      folders,
+		"total":     len(folders),
+		"monitored": monitored,
+	})
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"mail-dispatcher/internal/middleware"
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAuditLimit 单次查询审计日志的最大条数
+const maxAuditLimit = 100
+
+// AuditController 审计日志控制器
+type AuditController struct {
+	auditService *services.AuditService
+}
+
+// NewAuditController 创建审计日志控制器
+func NewAuditController(auditService *services.AuditService) *AuditController {
+	return &AuditController{auditService: auditService}
+}
+
+// GetAuditEvents 查询审计日志，可按实体、操作、操作者和日期范围过滤
+func (c *AuditController) GetAuditEvents(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的limit参数"})
+		return
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的offset参数"})
+		return
+	}
+
+	filter := services.AuditFilter{
+		Entity: ctx.Query("entity"),
+		Action: ctx.Query("action"),
+		Actor:  ctx.Query("actor"),
+	}
+
+	if entityIDStr := ctx.Query("entity_id"); entityIDStr != "" {
+		entityID, err := strconv.ParseUint(entityIDStr, 10, 32)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的entity_id参数"})
+			return
+		}
+		filter.EntityID = uint(entityID)
+	}
+
+	if startDateStr := ctx.Query("start_date"); startDateStr != "" {
+		startDate, err := time.ParseInLocation("2006-01-02", startDateStr, time.Local)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的start_date格式，应为YYYY-MM-DD"})
+			return
+		}
+		filter.StartDate = &startDate
+	}
+
+	if endDateStr := ctx.Query("end_date"); endDateStr != "" {
+		endDate, err := time.ParseInLocation("2006-01-02", endDateStr, time.Local)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的end_date格式，应为YYYY-MM-DD"})
+			return
+		}
+		// 包含结束日期当天
+		endDate = endDate.AddDate(0, 0, 1)
+		filter.EndDate = &endDate
+	}
+
+	events, total, err := c.auditService.Query(filter, limit, offset)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取审计日志失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  events,
+		"total": total,
+	})
+}
+
+// recordAudit 记录配置变更，before 为 nil 表示创建，after 为 nil 表示删除
+func recordAudit(ctx *gin.Context, auditService *services.AuditService, action, entity string, entityID uint, before, after interface{}) {
+	actor := ""
+	if principal, ok := middleware.CurrentPrincipal(ctx); ok {
+		actor = principal.Name
+	}
+	recordAuditAs(ctx, auditService, actor, action, entity, entityID, before, after)
+}
+
+// recordAuditAs 以指定的操作者记录配置变更，用于没有API调用方的请求
+func recordAuditAs(ctx *gin.Context, auditService *services.AuditService, actor, action, entity string, entityID uint, before, after interface{}) {
+	auditService.Record(models.AuditEvent{
+		Actor:    actor,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Method:   ctx.Request.Method,
+		Path:     ctx.Request.URL.Path,
+		IP:       ctx.ClientIP(),
+		Changes:  services.DiffEntities(before, after),
+	})
+}
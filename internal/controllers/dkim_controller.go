@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DKIMController DKIM密钥控制器
+type DKIMController struct {
+	db           *gorm.DB
+	auditService *services.AuditService
+}
+
+// NewDKIMController 创建DKIM密钥控制器
+func NewDKIMController(db *gorm.DB, auditService *services.AuditService) *DKIMController {
+	return &DKIMController{
+		db:           db,
+		auditService: auditService,
+	}
+}
+
+// dkimSelectorPattern 选择器只能包含字母、数字、连字符和点
+var dkimSelectorPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+// createDKIMKeyRequest 创建密钥请求，PrivateKey 为空时按 Algorithm 生成新密钥
+type createDKIMKeyRequest struct {
+	Domain     string
+	Selector   string
+	Algorithm  string
+	PrivateKey string
+}
+
+// dkimKeyResponse 返回给客户端的密钥信息，包含需要发布的DNS记录，不包含私钥
+type dkimKeyResponse struct {
+	ID        uint
+	Domain    string
+	Selector  string
+	Algorithm string
+	IsActive  bool
+	DNSName   string
+	DNSRecord string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// newDKIMKeyResponse 根据私钥生成DNS记录
+func newDKIMKeyResponse(key models.DKIMKey) dkimKeyResponse {
+	resp := dkimKeyResponse{
+		ID:        key.ID,
+		Domain:    key.Domain,
+		Selector:  key.Selector,
+		Algorithm: key.Algorithm,
+		IsActive:  key.IsActive,
+		DNSName:   key.Selector + "._domainkey." + key.Domain,
+		CreatedAt: key.CreatedAt,
+		UpdatedAt: key.UpdatedAt,
+	}
+	if signer, _, err := mail.ParseDKIMKey(key.PrivateKey); err == nil {
+		resp.DNSRecord, _ = mail.DKIMRecord(signer)
+	}
+	return resp
+}
+
+// GetDKIMKeys 获取所有DKIM密钥及对应的DNS记录
+func (c *DKIMController) GetDKIMKeys(ctx *gin.Context) {
+	var keys []models.DKIMKey
+	if err := c.db.Order("domain").Find(&keys).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取DKIM密钥失败: " + err.Error()})
+		return
+	}
+
+	data := make([]dkimKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		data = append(data, newDKIMKeyResponse(key))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  data,
+		"total": len(data),
+	})
+}
+
+// CreateDKIMKey 导入或生成域名的DKIM密钥，每个域名只能有一个密钥
+func (c *DKIMController) CreateDKIMKey(ctx *gin.Context) {
+	var req createDKIMKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	key := models.DKIMKey{
+		Domain:    strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), ".")),
+		Selector:  strings.TrimSpace(req.Selector),
+		Algorithm: req.Algorithm,
+		IsActive:  true,
+	}
+	if key.Domain == "" || strings.Contains(key.Domain, "@") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "域名无效"})
+		return
+	}
+	if !dkimSelectorPattern.MatchString(key.Selector) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "选择器只能包含字母、数字、连字符和点"})
+		return
+	}
+
+	if req.PrivateKey == "" {
+		if key.Algorithm == "" {
+			key.Algorithm = models.DKIMAlgorithmRSA
+		}
+		privateKey, err := mail.GenerateDKIMKey(key.Algorithm)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		key.PrivateKey = privateKey
+	} else {
+		_, algorithm, err := mail.ParseDKIMKey(req.PrivateKey)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if key.Algorithm != "" && key.Algorithm != algorithm {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "私钥与指定的算法不一致"})
+			return
+		}
+		key.Algorithm = algorithm
+		key.PrivateKey = req.PrivateKey
+	}
+
+	var existing models.DKIMKey
+	if err := c.db.Where("domain = ?", key.Domain).First(&existing).Error; err == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "该域名已有DKIM密钥"})
+		return
+	}
+
+	if err := c.db.Create(&key).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建DKIM密钥失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "dkim", key.ID, nil, key)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"data":    newDKIMKeyResponse(key),
+		"message": "请在DNS中添加 DNSName 对应的TXT记录",
+	})
+}
+
+// ToggleDKIMKey 启用或停用DKIM签名
+func (c *DKIMController) ToggleDKIMKey(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var key models.DKIMKey
+	if err := c.db.First(&key, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "DKIM密钥不存在"})
+		return
+	}
+
+	before := key
+	key.IsActive = !key.IsActive
+	if err := c.db.Save(&key).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新DKIM密钥状态失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditToggle, "dkim", key.ID, before, key)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":    newDKIMKeyResponse(key),
+		"message": "DKIM密钥状态更新成功",
+	})
+}
+
+// DeleteDKIMKey 删除DKIM密钥，之后该域名的邮件不再签名
+func (c *DKIMController) DeleteDKIMKey(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var key models.DKIMKey
+	if err := c.db.First(&key, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "DKIM密钥不存在"})
+		return
+	}
+
+	if err := c.db.Delete(&key).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除DKIM密钥失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "dkim", key.ID, key, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "DKIM密钥删除成功"})
+}
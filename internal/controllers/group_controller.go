@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+)
+
+// GroupController 转发分组控制器
+type GroupController struct {
+	db           *gorm.DB
+	auditService *services.AuditService
+}
+
+// NewGroupController 创建转发分组控制器
+func NewGroupController(db *gorm.DB, auditService *services.AuditService) *GroupController {
+	return &GroupController{db: db, auditService: auditService}
+}
+
+// GetGroups 获取所有转发分组
+func (c *GroupController) GetGroups(ctx *gin.Context) {
+	var groups []models.TargetGroup
+	if err := c.db.Preload("Targets").Find(&groups).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取转发分组失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  groups,
+		"total": len(groups),
+	})
+}
+
+// GetGroup 获取单个转发分组
+func (c *GroupController) GetGroup(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var group models.TargetGroup
+	if err := c.db.Preload("Targets").First(&group, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "转发分组不存在"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": group})
+}
+
+// CreateGroup 创建转发分组
+func (c *GroupController) CreateGroup(ctx *gin.Context) {
+	var group models.TargetGroup
+	if err := ctx.ShouldBindJSON(&group); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	// 验证必填字段
+	if group.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "分组名称不能为空"})
+		return
+	}
+
+	// 分组与转发目标共用主题中的名称，不能重名
+	if nameTaken(c.db, group.Name, 0) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "名称已被转发目标或分组使用"})
+		return
+	}
+
+	if msg := validateAddresses(group.Addresses); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	targets, msg := loadTargets(c.db, group.TargetIDs)
+	if msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	group.Targets = targets
+
+	if err := c.db.Create(&group).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建转发分组失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "group", group.ID, nil, group)
+
+	ctx.JSON(http.StatusCreated, gin.H{"data": group})
+}
+
+// UpdateGroup 更新转发分组，Addresses 和 TargetIDs 提供时整体替换
+func (c *GroupController) UpdateGroup(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var group models.TargetGroup
+	if err := c.db.Preload("Targets").First(&group, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "转发分组不存在"})
+		return
+	}
+	before := group
+
+	var updateData models.TargetGroup
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	// 如果更新名称，检查是否与其他记录冲突
+	if updateData.Name != "" && updateData.Name != group.Name && nameTaken(c.db, updateData.Name, group.ID) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "名称已被转发目标或分组使用"})
+		return
+	}
+
+	if msg := validateAddresses(updateData.Addresses); msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// 更新字段
+	if updateData.Name != "" {
+		group.Name = updateData.Name
+	}
+	if updateData.Description != "" {
+		group.Description = updateData.Description
+	}
+	if updateData.Addresses != nil {
+		group.Addresses = updateData.Addresses
+	}
+
+	targets := group.Targets
+	if updateData.TargetIDs != nil {
+		var msg string
+		targets, msg = loadTargets(c.db, updateData.TargetIDs)
+		if msg != "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Targets").Save(&group).Error; err != nil {
+			return err
+		}
+		return tx.Model(&group).Association("Targets").Replace(targets)
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新转发分组失败: " + err.Error()})
+		return
+	}
+	group.Targets = targets
+	recordAudit(ctx, c.auditService, models.AuditUpdate, "group", group.ID, before, group)
+
+	ctx.JSON(http.StatusOK, gin.H{"data": group})
+}
+
+// DeleteGroup 删除转发分组
+func (c *GroupController) DeleteGroup(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var group models.TargetGroup
+	if err := c.db.Preload("Targets").First(&group, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "转发分组不存在"})
+		return
+	}
+
+	if err := c.db.Delete(&group).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除转发分组失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "group", group.ID, group, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "转发分组删除成功"})
+}
+
+// nameTaken 检查名称是否已被转发目标或其他分组使用
+func nameTaken(db *gorm.DB, name string, excludeGroupID uint) bool {
+	var target models.ForwardTarget
+	if err := db.Where("name = ?", name).First(&target).Error; err == nil {
+		return true
+	}
+
+	var group models.TargetGroup
+	if err := db.Where("name = ? AND id != ?", name, excludeGroupID).First(&group).Error; err == nil {
+		return true
+	}
+
+	return false
+}
+
+// validateAddresses 校验邮箱地址格式，返回的错误信息为空表示校验通过
+func validateAddresses(addresses []string) string {
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return "无效的邮箱地址: " + address
+		}
+	}
+	return ""
+}
+
+// loadTargets 按ID加载转发目标，返回的错误信息为空表示全部存在
+func loadTargets(db *gorm.DB, ids []uint) ([]models.ForwardTarget, string) {
+	var targets []models.ForwardTarget
+	if len(ids) == 0 {
+		return targets, ""
+	}
+	if err := db.Find(&targets, ids).Error; err != nil {
+		return nil, "查询转发目标失败: " + err.Error()
+	}
+	if len(targets) != len(uniqueIDs(ids)) {
+		return nil, "部分转发目标不存在"
+	}
+	return targets, ""
+}
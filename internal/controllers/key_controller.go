@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// KeyController API密钥控制器
+type KeyController struct {
+	db           *gorm.DB
+	authService  *services.AuthService
+	auditService *services.AuditService
+}
+
+// NewKeyController 创建API密钥控制器
+func NewKeyController(db *gorm.DB, authService *services.AuthService, auditService *services.AuditService) *KeyController {
+	return &KeyController{
+		db:           db,
+		authService:  authService,
+		auditService: auditService,
+	}
+}
+
+// createKeyRequest 创建密钥请求，ExpiresAt 为空表示不过期
+type createKeyRequest struct {
+	Name      string
+	Role      string
+	ExpiresAt *time.Time
+}
+
+// GetKeys 获取所有API密钥，不返回密钥本身
+func (c *KeyController) GetKeys(ctx *gin.Context) {
+	var keys []models.APIKey
+	if err := c.db.Order("id").Find(&keys).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取API密钥失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  keys,
+		"total": len(keys),
+	})
+}
+
+// CreateKey 创建API密钥，明文密钥只在响应中返回一次
+func (c *KeyController) CreateKey(ctx *gin.Context) {
+	var req createKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	if req.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "密钥名称不能为空"})
+		return
+	}
+	if !services.IsValidRole(req.Role) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "角色只能为 admin、operator 或 readonly"})
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间不能早于当前时间"})
+		return
+	}
+
+	key, plaintext, err := c.authService.CreateKey(req.Name, req.Role, req.ExpiresAt)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建API密钥失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "key", key.ID, nil, key)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"data":    key,
+		"key":     plaintext,
+		"message": "请妥善保存密钥，之后无法再次查看",
+	})
+}
+
+// DeleteKey 吊销API密钥
+func (c *KeyController) DeleteKey(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var key models.APIKey
+	if err := c.db.First(&key, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "API密钥不存在"})
+		return
+	}
+
+	// 至少保留一个管理员密钥，避免无法再管理密钥
+	if key.Role == models.RoleAdmin && key.IsActive {
+		var admins int64
+		c.db.Model(&models.APIKey{}).Where("role = ? AND is_active = ? AND id <> ?", models.RoleAdmin, true, key.ID).Count(&admins)
+		if admins == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "不能删除最后一个管理员密钥"})
+			return
+		}
+	}
+
+	if err := c.db.Delete(&key).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除API密钥失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "key", key.ID, key, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "API密钥已吊销"})
+}
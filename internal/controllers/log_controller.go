@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,12 +14,27 @@ import (
 
 // LogController 邮件日志控制器
 type LogController struct {
-	logService *services.LogService
+	logService   *services.LogService
+	retryService *services.RetryService
 }
 
 // NewLogController 创建邮件日志控制器
-func NewLogController(logService *services.LogService) *LogController {
-	return &LogController{logService: logService}
+func NewLogController(logService *services.LogService, retryService *services.RetryService) *LogController {
+	return &LogController{
+		logService:   logService,
+		retryService: retryService,
+	}
+}
+
+// bulkRetryRequest 批量重试请求，筛选条件与转发目标都可选
+type bulkRetryRequest struct {
+	Status    string
+	AccountID uint
+	StartDate string
+	EndDate   string
+	Limit     int
+	TargetID  uint
+	ForwardTo string
 }
 
 // GetLogs 获取邮件日志
@@ -195,9 +212,106 @@ func (c *LogController) GetLogsStats(ctx *gin.Context) {
 		return
 	}
 
+	queuedCount, err := c.logService.GetLogsCountByStatus("queued")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取待投递日志数量失败: " + err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"total":      total,
 		"failed":     failedCount,
 		"successful": successCount,
+		"queued":     queuedCount,
+	})
+}
+
+// RetryLog 重新分发单条日志对应的邮件，请求体可选，用于指定转发目标
+func (c *LogController) RetryLog(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var target services.RetryTarget
+	if err := ctx.ShouldBindJSON(&target); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	retries, err := c.retryService.RetryLog(uint(id), target)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "重试失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":    retries,
+		"message": "邮件已重新加入投递队列",
+	})
+}
+
+// RetryLogs 按条件批量重试，默认重试失败的日志
+func (c *LogController) RetryLogs(ctx *gin.Context) {
+	var req bulkRetryRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	filter := services.RetryFilter{
+		Status:    req.Status,
+		AccountID: req.AccountID,
+		Limit:     req.Limit,
+	}
+
+	if req.StartDate != "" {
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的StartDate格式，应为YYYY-MM-DD"})
+			return
+		}
+		filter.StartDate = &startDate
+	}
+
+	if req.EndDate != "" {
+		endDate, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的EndDate格式，应为YYYY-MM-DD"})
+			return
+		}
+		// 包含结束日期当天
+		endDate = endDate.AddDate(0, 0, 1)
+		filter.EndDate = &endDate
+	}
+
+	job, err := c.retryService.RetryLogs(filter, services.RetryTarget{
+		TargetID:  req.TargetID,
+		ForwardTo: req.ForwardTo,
 	})
+	if errors.Is(err, services.ErrRetryJobRunning) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "批量重试失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"data":    job,
+		"message": "批量重试已在后台开始",
+	})
+}
+
+// GetRetryJob 查询批量重试任务的进度和每条日志的结果
+func (c *LogController) GetRetryJob(ctx *gin.Context) {
+	job, ok := c.retryService.GetRetryJob(ctx.Param("job"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "批量重试任务不存在或已过期"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": job})
 }
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"mail-dispatcher/internal/models"
+)
+
+// QueueController 投递队列控制器
+type QueueController struct {
+	db *gorm.DB
+}
+
+// NewQueueController 创建投递队列控制器
+func NewQueueController(db *gorm.DB) *QueueController {
+	return &QueueController{db: db}
+}
+
+// GetQueue 获取投递队列，可按状态过滤
+func (c *QueueController) GetQueue(ctx *gin.Context) {
+	status := ctx.Query("status")
+	limitStr := ctx.DefaultQuery("limit", "20")
+	offsetStr := ctx.DefaultQuery("offset", "0")
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的limit参数"})
+		return
+	}
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的offset参数"})
+		return
+	}
+
+	// 列表中不返回邮件原文
+	query := c.db.Omit("Email").Order("id DESC").Limit(limit).Offset(offset)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var messages []models.OutboundMessage
+	if err := query.Find(&messages).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取投递队列失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  messages,
+		"total": len(messages),
+	})
+}
+
+// GetQueueItem 获取单个投递任务及其全部尝试记录
+func (c *QueueController) GetQueueItem(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var message models.OutboundMessage
+	if err := c.db.Omit("Email").First(&message, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "投递任务不存在"})
+		return
+	}
+
+	var attempts []models.DeliveryAttempt
+	if err := c.db.Where("outbound_id = ?", message.ID).Order("attempt ASC").Find(&attempts).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取投递记录失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":     message,
+		"attempts": attempts,
+	})
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+)
+
+// RuleController 路由规则控制器
+type RuleController struct {
+	db           *gorm.DB
+	auditService *services.AuditService
+}
+
+// NewRuleController 创建路由规则控制器
+func NewRuleController(db *gorm.DB, auditService *services.AuditService) *RuleController {
+	return &RuleController{db: db, auditService: auditService}
+}
+
+// GetRules 获取所有路由规则，按匹配顺序排列
+func (c *RuleController) GetRules(ctx *gin.Context) {
+	var rules []models.RoutingRule
+	if err := c.db.Preload("Targets").Preload("Groups").Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取路由规则失败: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":  rules,
+		"total": len(rules),
+	})
+}
+
+// GetRule 获取单个路由规则
+func (c *RuleController) GetRule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var rule models.RoutingRule
+	if err := c.db.Preload("Targets").Preload("Groups").First(&rule, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "路由规则不存在"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": rule})
+}
+
+// CreateRule 创建路由规则
+func (c *RuleController) CreateRule(ctx *gin.Context) {
+	var rule models.RoutingRule
+	if err := ctx.ShouldBindJSON(&rule); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	if rule.Type == "" {
+		rule.Type = models.RuleTypeMatch
+	}
+
+	targets, groups, msg := c.validateRule(&rule)
+	if msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	rule.Targets = targets
+	rule.Groups = groups
+
+	if err := c.db.Create(&rule).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建路由规则失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "rule", rule.ID, nil, rule)
+
+	ctx.JSON(http.StatusCreated, gin.H{"data": rule})
+}
+
+// UpdateRule 更新路由规则
+// 规则条件需要能够被清空，因此 PUT 为整体替换，未提供的条件视为不限
+func (c *RuleController) UpdateRule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	// 预加载关联，审计日志中记录变更前的目标和分组
+	var rule models.RoutingRule
+	if err := c.db.Preload("Targets").Preload("Groups").First(&rule, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "路由规则不存在"})
+		return
+	}
+	before := rule
+
+	var updateData models.RoutingRule
+	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	if updateData.Type == "" {
+		updateData.Type = models.RuleTypeMatch
+	}
+
+	targets, groups, msg := c.validateRule(&updateData)
+	if msg != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// 更新字段
+	rule.Name = updateData.Name
+	rule.Type = updateData.Type
+	rule.Priority = updateData.Priority
+	rule.Continue = updateData.Continue
+	rule.Sender = updateData.Sender
+	rule.Recipient = updateData.Recipient
+	rule.SubjectRegex = updateData.SubjectRegex
+	rule.HeaderName = updateData.HeaderName
+	rule.HeaderValue = updateData.HeaderValue
+	rule.BodyKeyword = updateData.BodyKeyword
+	rule.HasAttachment = updateData.HasAttachment
+	rule.Folder = updateData.Folder
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		// 显式选择全部字段，使清空的条件也能写入
+		if err := tx.Model(&rule).Select("*").Omit("ID", "IsActive", "CreatedAt", "DeletedAt", "Targets", "Groups").Updates(&rule).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&rule).Association("Targets").Replace(targets); err != nil {
+			return err
+		}
+		return tx.Model(&rule).Association("Groups").Replace(groups)
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新路由规则失败: " + err.Error()})
+		return
+	}
+	rule.Targets = targets
+	rule.Groups = groups
+	recordAudit(ctx, c.auditService, models.AuditUpdate, "rule", rule.ID, before, rule)
+
+	ctx.JSON(http.StatusOK, gin.H{"data": rule})
+}
+
+// DeleteRule 删除路由规则
+func (c *RuleController) DeleteRule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var rule models.RoutingRule
+	if err := c.db.Preload("Targets").Preload("Groups").First(&rule, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "路由规则不存在"})
+		return
+	}
+
+	if err := c.db.Delete(&rule).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除路由规则失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "rule", rule.ID, rule, nil)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "路由规则删除成功"})
+}
+
+// ToggleRuleStatus 切换规则启用状态
+func (c *RuleController) ToggleRuleStatus(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	var rule models.RoutingRule
+	if err := c.db.First(&rule, id).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "路由规则不存在"})
+		return
+	}
+
+	before := rule
+	rule.IsActive = !rule.IsActive
+
+	if err := c.db.Save(&rule).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新规则状态失败: " + err.Error()})
+		return
+	}
+	recordAudit(ctx, c.auditService, models.AuditToggle, "rule", rule.ID, before, rule)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":    rule,
+		"message": "规则状态更新成功",
+	})
+}
+
+// validateRule 校验规则字段并加载关联的转发目标和分组，返回的错误信息为空表示校验通过
+func (c *RuleController) validateRule(rule *models.RoutingRule) ([]models.ForwardTarget, []models.TargetGroup, string) {
+	if rule.Name == "" {
+		return nil, nil, "规则名称不能为空"
+	}
+
+	switch rule.Type {
+	case models.RuleTypeMatch, models.RuleTypeSubjectFormat:
+	default:
+		return nil, nil, "规则类型只能为 match 或 subject_format"
+	}
+
+	if rule.SubjectRegex != "" {
+		if _, err := regexp.Compile(rule.SubjectRegex); err != nil {
+			return nil, nil, "主题正则表达式无效: " + err.Error()
+		}
+	}
+
+	if rule.HeaderValue != "" && rule.HeaderName == "" {
+		return nil, nil, "设置邮件头值时必须指定邮件头名称"
+	}
+
+	targets, msg := loadTargets(c.db, rule.TargetIDs)
+	if msg != "" {
+		return nil, nil, msg
+	}
+
+	var groups []models.TargetGroup
+	if len(rule.GroupIDs) > 0 {
+		if err := c.db.Find(&groups, rule.GroupIDs).Error; err != nil {
+			return nil, nil, "查询转发分组失败: " + err.Error()
+		}
+		if len(groups) != len(uniqueIDs(rule.GroupIDs)) {
+			return nil, nil, "部分转发分组不存在"
+		}
+	}
+
+	// 主题格式规则的目标来自主题本身，其他规则必须指定目标或分组
+	if rule.Type == models.RuleTypeMatch && len(targets) == 0 && len(groups) == 0 {
+		return nil, nil, "匹配规则至少需要一个转发目标或分组"
+	}
+
+	return targets, groups, ""
+}
+
+// uniqueIDs 去除重复的ID
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var result []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -5,18 +5,21 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"gorm.io/gorm"
 	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
 )
 
 // TargetController 转发目标控制器
 type TargetController struct {
-	db *gorm.DB
+	db           *gorm.DB
+	auditService *services.AuditService
 }
 
 // NewTargetController 创建转发目标控制器
-func NewTargetController(db *gorm.DB) *TargetController {
-	return &TargetController{db: db}
+func NewTargetController(db *gorm.DB, auditService *services.AuditService) *TargetController {
+	return &TargetController{db: db, auditService: auditService}
 }
 
 // GetTargets 获取所有转发目标
@@ -50,6 +53,15 @@ func (c *TargetController) GetTarget(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"data": target})
 }
 
+// isValidForwardMode 校验转发方式，空值表示使用 redirect
+func isValidForwardMode(mode string) bool {
+	switch mode {
+	case "", models.ForwardModeRedirect, models.ForwardModeWrap, models.ForwardModeInline:
+		return true
+	}
+	return false
+}
+
 // CreateTarget 创建转发目标
 func (c *TargetController) CreateTarget(ctx *gin.Context) {
 	var target models.ForwardTarget
@@ -63,6 +75,17 @@ func (c *TargetController) CreateTarget(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "名称和邮箱地址不能为空"})
 		return
 	}
+	if target.MaxSize < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "大小上限不能为负数"})
+		return
+	}
+	if !isValidForwardMode(target.ForwardMode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "转发方式只能为 redirect、wrap 或 inline"})
+		return
+	}
+	if target.ForwardMode == "" {
+		target.ForwardMode = models.ForwardModeRedirect
+	}
 
 	// 检查名称是否已存在
 	var existingTarget models.ForwardTarget
@@ -70,11 +93,17 @@ func (c *TargetController) CreateTarget(ctx *gin.Context) {
 		ctx.JSON(http.StatusConflict, gin.H{"error": "转发目标名称已存在"})
 		return
 	}
+	var existingGroup models.TargetGroup
+	if err := c.db.Where("name = ?", target.Name).First(&existingGroup).Error; err == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "名称已被转发分组使用"})
+		return
+	}
 
 	if err := c.db.Create(&target).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "创建转发目标失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditCreate, "target", target.ID, nil, target)
 
 	ctx.JSON(http.StatusCreated, gin.H{"data": target})
 }
@@ -92,9 +121,16 @@ func (c *TargetController) UpdateTarget(ctx *gin.Context) {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "转发目标不存在"})
 		return
 	}
+	before := target
 
 	var updateData models.ForwardTarget
-	if err := ctx.ShouldBindJSON(&updateData); err != nil {
+	// MaxSize 使用指针区分未提交和提交 0
+	var reset struct{ MaxSize *int64 }
+	if err := ctx.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	if err := ctx.ShouldBindBodyWith(&reset, binding.JSON); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
 		return
 	}
@@ -106,6 +142,11 @@ func (c *TargetController) UpdateTarget(ctx *gin.Context) {
 			ctx.JSON(http.StatusConflict, gin.H{"error": "转发目标名称已存在"})
 			return
 		}
+		var existingGroup models.TargetGroup
+		if err := c.db.Where("name = ?", updateData.Name).First(&existingGroup).Error; err == nil {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "名称已被转发分组使用"})
+			return
+		}
 	}
 
 	// 更新字段
@@ -118,11 +159,27 @@ func (c *TargetController) UpdateTarget(ctx *gin.Context) {
 	if updateData.Description != "" {
 		target.Description = updateData.Description
 	}
+	// 提交 0 表示取消大小上限
+	if reset.MaxSize != nil {
+		if *reset.MaxSize < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "大小上限不能为负数"})
+			return
+		}
+		target.MaxSize = *reset.MaxSize
+	}
+	if !isValidForwardMode(updateData.ForwardMode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "转发方式只能为 redirect、wrap 或 inline"})
+		return
+	}
+	if updateData.ForwardMode != "" {
+		target.ForwardMode = updateData.ForwardMode
+	}
 
 	if err := c.db.Save(&target).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新转发目标失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditUpdate, "target", target.ID, before, target)
 
 	ctx.JSON(http.StatusOK, gin.H{"data": target})
 }
@@ -145,6 +202,7 @@ func (c *TargetController) DeleteTarget(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "删除转发目标失败: " + err.Error()})
 		return
 	}
+	recordAudit(ctx, c.auditService, models.AuditDelete, "target", target.ID, target, nil)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "转发目标删除成功"})
 }
@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+)
+
+// keywordSpecials IMAP 关键字中不允许出现的字符（RFC 3501 atom-specials 和 resp-specials）
+const keywordSpecials = "(){ %*\"\\]"
+
+// ValidateMailboxActions 校验处理后操作，移动或删除后原邮件已不在当前文件夹，只能作为最后一个操作
+func ValidateMailboxActions(actions []models.MailboxAction) error {
+	for i, action := range actions {
+		switch action.Type {
+		case models.MailboxActionSeen:
+		case models.MailboxActionKeyword:
+			if !isValidKeyword(action.Target) {
+				return fmt.Errorf("无效的关键字: %q", action.Target)
+			}
+		case models.MailboxActionCopy, models.MailboxActionMove:
+			if strings.TrimSpace(action.Target) == "" {
+				return fmt.Errorf("%s 操作需要指定目标文件夹", action.Type)
+			}
+		case models.MailboxActionDelete:
+		default:
+			return fmt.Errorf("处理后操作只能为 seen、keyword、copy、move 或 delete: %q", action.Type)
+		}
+
+		if (action.Type == models.MailboxActionMove || action.Type == models.MailboxActionDelete) && i != len(actions)-1 {
+			return fmt.Errorf("%s 操作只能是最后一个操作", action.Type)
+		}
+	}
+	return nil
+}
+
+// isValidKeyword 判断是否为合法的IMAP关键字，系统标记（以 \ 开头）不能作为关键字
+func isValidKeyword(keyword string) bool {
+	if keyword == "" {
+		return false
+	}
+	for _, r := range keyword {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(keywordSpecials, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyActions 对文件夹中的邮件依次执行处理后操作，文件夹未以读写方式选中时（如重新连接后）先选中
+func (c *MailClient) ApplyActions(folder string, uid uint32, actions []models.MailboxAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	if err := c.ensureConnection(); err != nil {
+		return fmt.Errorf("确保连接失败: %w", err)
+	}
+	if folder == "" {
+		folder = DefaultFolder
+	}
+	if mbox := c.client.Mailbox(); mbox == nil || mbox.Name != folder || mbox.ReadOnly {
+		if _, err := c.client.Select(folder, false); err != nil {
+			return fmt.Errorf("选择文件夹 %s 失败: %v", folder, err)
+		}
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	addFlags := imap.FormatFlagsOp(imap.AddFlags, true)
+
+	for _, action := range actions {
+		var err error
+		switch action.Type {
+		case models.MailboxActionSeen:
+			err = c.client.UidStore(seqset, addFlags, []interface{}{imap.SeenFlag}, nil)
+		case models.MailboxActionKeyword:
+			err = c.client.UidStore(seqset, addFlags, []interface{}{action.Target}, nil)
+		case models.MailboxActionCopy:
+			err = c.withMailbox(action.Target, func() error { return c.client.UidCopy(seqset, action.Target) })
+		case models.MailboxActionMove:
+			err = c.moveMessage(seqset, action.Target)
+		case models.MailboxActionDelete:
+			err = c.deleteMessage(seqset)
+		default:
+			err = fmt.Errorf("未知的操作")
+		}
+		if err != nil {
+			return fmt.Errorf("执行 %s 操作失败 (UID %d): %w", action.Type, uid, err)
+		}
+	}
+	return nil
+}
+
+// withMailbox 执行复制或移动，目标文件夹不存在时创建后重试一次
+func (c *MailClient) withMailbox(mailbox string, fn func() error) error {
+	err := fn()
+	if err == nil {
+		return nil
+	}
+	// 服务器通常以 TRYCREATE 提示文件夹不存在，创建失败说明是其他原因，返回原错误
+	if createErr := c.client.Create(mailbox); createErr != nil {
+		return err
+	}
+	return fn()
+}
+
+// moveMessage 移动邮件，服务器不支持 MOVE 时复制后删除原邮件
+// go-imap 的 UidMove 在不支持 MOVE 时会执行 EXPUNGE，因此不直接使用
+func (c *MailClient) moveMessage(seqset *imap.SeqSet, mailbox string) error {
+	supported, err := c.client.Support("MOVE")
+	if err != nil {
+		return err
+	}
+	if supported {
+		return c.withMailbox(mailbox, func() error { return c.client.UidMove(seqset, mailbox) })
+	}
+
+	if err := c.withMailbox(mailbox, func() error { return c.client.UidCopy(seqset, mailbox) }); err != nil {
+		return err
+	}
+	return c.deleteMessage(seqset)
+}
+
+// errExpungeSkipped 服务器不支持 UIDPLUS 时只标记删除，不执行清除
+var errExpungeSkipped = errors.New("服务器不支持 UIDPLUS，已标记删除但未清除，避免清除文件夹中其他已标记删除的邮件")
+
+// deleteMessage 标记删除并清除邮件，服务器支持 UIDPLUS 时只清除这一封，
+// 否则 EXPUNGE 会同时清除文件夹中其他已标记删除的邮件，只标记删除并返回 errExpungeSkipped
+func (c *MailClient) deleteMessage(seqset *imap.SeqSet) error {
+	if err := c.client.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+
+	supported, err := c.client.Support("UIDPLUS")
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return errExpungeSkipped
+	}
+
+	status, err := c.client.Execute(&commands.Uid{Cmd: &uidExpunge{seqset: seqset}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// uidExpunge UID EXPUNGE 命令（RFC 4315），由 commands.Uid 包装后发送
+type uidExpunge struct {
+	seqset *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.seqset}}
+}
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+)
+
+func TestValidateMailboxActions(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []models.MailboxAction
+		wantErr bool
+	}{
+		{"未配置", nil, false},
+		{"标记已读并移动", []models.MailboxAction{{Type: "seen"}, {Type: "move", Target: "Dispatched"}}, false},
+		{"添加关键字并复制", []models.MailboxAction{{Type: "keyword", Target: "$Dispatched"}, {Type: "copy", Target: "Archive/2026"}}, false},
+		{"删除", []models.MailboxAction{{Type: "seen"}, {Type: "delete"}}, false},
+		{"未知操作", []models.MailboxAction{{Type: "archive"}}, true},
+		{"移动没有目标文件夹", []models.MailboxAction{{Type: "move"}}, true},
+		{"关键字包含空格", []models.MailboxAction{{Type: "keyword", Target: "a b"}}, true},
+		{"关键字为系统标记", []models.MailboxAction{{Type: "keyword", Target: `\Deleted`}}, true},
+		{"关键字包含括号", []models.MailboxAction{{Type: "keyword", Target: "x)"}}, true},
+		{"移动后还有操作", []models.MailboxAction{{Type: "move", Target: "Dispatched"}, {Type: "seen"}}, true},
+		{"删除后还有操作", []models.MailboxAction{{Type: "delete"}, {Type: "copy", Target: "Archive"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMailboxActions(tt.actions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMailboxActions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUIDExpungeCommand(t *testing.T) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(42)
+
+	cmd := (&commands.Uid{Cmd: &uidExpunge{seqset: seqset}}).Command()
+	cmd.Tag = "a1"
+
+	var buf bytes.Buffer
+	if err := cmd.WriteTo(imap.NewWriter(&buf)); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if got := buf.String(); got != "a1 UID EXPUNGE 42\r\n" {
+		t.Errorf("命令 = %q", got)
+	}
+}
+
+func TestApplyActions_DeleteWithoutUIDPlus(t *testing.T) {
+	// 测试服务器不支持 UIDPLUS，收件箱中 UID 6 为已有邮件，7 为新邮件
+	cfg := startTestIMAPServer(t, 1)
+	c := NewMailClient(nil)
+	if err := c.Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer c.Stop()
+
+	if _, err := c.client.Select(DefaultFolder, false); err != nil {
+		t.Fatal(err)
+	}
+	// 其他客户端标记删除但尚未清除的邮件
+	other := new(imap.SeqSet)
+	other.AddNum(6)
+	if err := c.client.UidStore(other, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.ApplyActions(DefaultFolder, 7, []models.MailboxAction{{Type: models.MailboxActionDelete}})
+	if !errors.Is(err, errExpungeSkipped) {
+		t.Fatalf("ApplyActions() error = %v, 期望 errExpungeSkipped", err)
+	}
+
+	all := imap.NewSearchCriteria()
+	uids, err := c.client.UidSearch(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 2 {
+		t.Errorf("不应执行 EXPUNGE，剩余邮件 UID = %v", uids)
+	}
+
+	deleted := imap.NewSearchCriteria()
+	deleted.WithFlags = []string{imap.DeletedFlag}
+	if uids, _ := c.client.UidSearch(deleted); len(uids) != 2 {
+		t.Errorf("已标记删除的邮件 UID = %v, 期望 6 和 7", uids)
+	}
+}
+
+func TestApplyActions_SelectsFolderAfterReconnect(t *testing.T) {
+	cfg := startTestIMAPServer(t, 1)
+	c := NewMailClient(nil)
+	if err := c.Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer c.Stop()
+
+	if _, err := c.client.Select(DefaultFolder, false); err != nil {
+		t.Fatal(err)
+	}
+	// 连接中断，ApplyActions 重新连接后没有选中任何文件夹
+	c.client.Logout()
+
+	if err := c.ApplyActions(DefaultFolder, 7, []models.MailboxAction{{Type: models.MailboxActionSeen}}); err != nil {
+		t.Fatalf("ApplyActions() error = %v", err)
+	}
+
+	seen := imap.NewSearchCriteria()
+	seen.WithFlags = []string{imap.SeenFlag}
+	uids, err := c.client.UidSearch(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 2 {
+		t.Errorf("已读邮件 UID = %v, 期望 6 和 7", uids)
+	}
+}
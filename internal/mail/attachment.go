@@ -0,0 +1,264 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	gotextproto "github.com/emersion/go-message/textproto"
+)
+
+// Attachment 邮件附件信息
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Size        int64 // 编码后的字节数
+}
+
+// mimeNode MIME结构树中的一个实体，叶子节点保留未解码的原始内容
+type mimeNode struct {
+	header     textproto.MIMEHeader
+	boundary   string
+	children   []*mimeNode
+	raw        []byte
+	attachment *Attachment
+	dropped    bool
+}
+
+// LimitMessageSize 邮件超过大小上限时按从大到小的顺序移除附件（含内嵌图片），
+// 并在正文前插入说明，返回处理后的邮件和被移除的附件
+func LimitMessageSize(raw []byte, maxSize int64) ([]byte, []Attachment, error) {
+	if maxSize <= 0 || int64(len(raw)) <= maxSize {
+		return raw, nil, nil
+	}
+
+	header, body, err := splitMessage(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, err := parseMIMENode(textproto.MIMEHeader{
+		"Content-Type":              header.Values("Content-Type"),
+		"Content-Transfer-Encoding": header.Values("Content-Transfer-Encoding"),
+		"Content-Disposition":       header.Values("Content-Disposition"),
+	}, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var candidates []*mimeNode
+	collectAttachments(root, &candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].attachment.Size > candidates[j].attachment.Size
+	})
+
+	var dropped []Attachment
+	total := int64(len(raw))
+	for _, node := range candidates {
+		if total <= maxSize {
+			break
+		}
+		node.dropped = true
+		total -= node.attachment.Size
+		dropped = append(dropped, *node.attachment)
+	}
+
+	if len(dropped) == 0 {
+		return raw, nil, nil
+	}
+
+	// 顶层改为 multipart/mixed：说明 + 裁剪后的原始内容
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	header.Del("Content-Type")
+	header.Del("Content-Transfer-Encoding")
+	header.Del("Content-Disposition")
+	header.Set("MIME-Version", "1.0")
+	header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+
+	if err := writeNotice(mw, maxSize, dropped); err != nil {
+		return nil, nil, err
+	}
+	if root.kept() {
+		part, err := mw.CreatePart(root.header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := root.writeBody(part); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	var out bytes.Buffer
+	if err := gotextproto.WriteHeader(&out, header); err != nil {
+		return nil, nil, err
+	}
+	out.Write(buf.Bytes())
+
+	return out.Bytes(), dropped, nil
+}
+
+// FormatAttachments 将附件列表格式化为便于记录的文本
+func FormatAttachments(attachments []Attachment) string {
+	names := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		names = append(names, fmt.Sprintf("%s (%s)", a.Filename, formatSize(a.Size)))
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseMIMENode 递归解析MIME实体
+func parseMIMENode(header textproto.MIMEHeader, body []byte) (*mimeNode, error) {
+	node := &mimeNode{header: header}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		node.boundary = params["boundary"]
+		mr := multipart.NewReader(bytes.NewReader(body), node.boundary)
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("解析MIME分段失败: %v", err)
+			}
+			content, err := io.ReadAll(part)
+			if err != nil {
+				return nil, fmt.Errorf("读取MIME分段失败: %v", err)
+			}
+			child, err := parseMIMENode(part.Header, content)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+		return node, nil
+	}
+
+	node.raw = body
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	// 显式附件、带文件名的分段以及非文本分段（如内嵌图片）都视为附件
+	if disposition == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/") {
+		if decoded, err := headerDecoder.DecodeHeader(filename); err == nil {
+			filename = decoded
+		}
+		if filename == "" {
+			filename = "未命名附件"
+		}
+		node.attachment = &Attachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			Size:        int64(len(body)),
+		}
+	}
+
+	return node, nil
+}
+
+// collectAttachments 收集所有附件节点
+func collectAttachments(node *mimeNode, out *[]*mimeNode) {
+	if node.attachment != nil {
+		*out = append(*out, node)
+	}
+	for _, child := range node.children {
+		collectAttachments(child, out)
+	}
+}
+
+// kept 判断节点在裁剪后是否仍有内容
+func (n *mimeNode) kept() bool {
+	if n.dropped {
+		return false
+	}
+	if n.boundary == "" {
+		return true
+	}
+	for _, child := range n.children {
+		if child.kept() {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBody 写出节点正文，多部分实体沿用原有的 boundary
+func (n *mimeNode) writeBody(w io.Writer) error {
+	if n.boundary == "" {
+		_, err := w.Write(n.raw)
+		return err
+	}
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(n.boundary); err != nil {
+		return err
+	}
+	for _, child := range n.children {
+		if !child.kept() {
+			continue
+		}
+		part, err := mw.CreatePart(child.header)
+		if err != nil {
+			return err
+		}
+		if err := child.writeBody(part); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeNotice 写入附件被移除的说明
+func writeNotice(mw *multipart.Writer, maxSize int64, dropped []Attachment) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	var notice strings.Builder
+	fmt.Fprintf(&notice, "此邮件超出转发大小限制（%s），以下附件未被转发：\r\n", formatSize(maxSize))
+	for _, a := range dropped {
+		fmt.Fprintf(&notice, "- %s (%s, %s)\r\n", a.Filename, a.ContentType, formatSize(a.Size))
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(notice.String())); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// formatSize 格式化字节数
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+func buildAttachmentMessage() string {
+	return "From: alice@example.com\r\n" +
+		"Subject: report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"mix\"\r\n" +
+		"\r\n" +
+		"--mix\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"see attachments\r\n" +
+		"--mix\r\n" +
+		"Content-Type: application/pdf; name=\"big.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"big.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		strings.Repeat("QUJD", 1000) + "\r\n" +
+		"--mix\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=\"=?UTF-8?B?5Zu+54mHLnBuZw==?=\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"iVBORw0KGgo=\r\n" +
+		"--mix--\r\n"
+}
+
+func TestLimitMessageSize(t *testing.T) {
+	raw := []byte(buildAttachmentMessage())
+
+	out, dropped, err := LimitMessageSize(raw, 2000)
+	if err != nil {
+		t.Fatalf("LimitMessageSize() error = %v", err)
+	}
+
+	if len(dropped) != 1 || dropped[0].Filename != "big.pdf" {
+		t.Fatalf("dropped = %+v, want only big.pdf", dropped)
+	}
+	if len(out) > 2000 {
+		t.Errorf("裁剪后大小 %d 仍超过上限", len(out))
+	}
+
+	text := string(out)
+	if strings.Contains(text, "QUJDQUJD") {
+		t.Error("超限附件的内容不应被转发")
+	}
+	if !strings.Contains(text, "iVBORw0KGgo=") || !strings.Contains(text, "see attachments") {
+		t.Error("未超限的正文和内嵌图片应保留")
+	}
+	if !strings.Contains(text, "boundary=\"mix\"") && !strings.Contains(text, "boundary=mix") {
+		t.Error("原有的 multipart 结构应保留")
+	}
+}
+
+func TestLimitMessageSize_UnderLimit(t *testing.T) {
+	raw := []byte(buildAttachmentMessage())
+
+	out, dropped, err := LimitMessageSize(raw, int64(len(raw)))
+	if err != nil {
+		t.Fatalf("LimitMessageSize() error = %v", err)
+	}
+	if len(dropped) != 0 || string(out) != string(raw) {
+		t.Error("未超限的邮件应原样返回")
+	}
+}
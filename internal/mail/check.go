@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// checkTimeout 连接测试中每条命令的超时时间
+const checkTimeout = 15 * time.Second
+
+// 连接测试的步骤
+const (
+	CheckIMAPConnect = "imap_connect" // 连接IMAP服务器并完成TLS握手
+	CheckIMAPLogin   = "imap_login"   // 登录IMAP服务器
+	CheckIMAPList    = "imap_list"    // 列出文件夹
+	CheckSMTPConnect = "smtp_connect" // 连接SMTP服务器并完成EHLO和TLS协商
+	CheckSMTPAuth    = "smtp_auth"    // SMTP认证
+)
+
+// CheckStep 连接测试中一个步骤的结果
+type CheckStep struct {
+	Name       string
+	OK         bool
+	DurationMs int64
+	Detail     string
+	Error      string
+}
+
+// CheckResult 连接测试结果，前一步失败时依赖它的步骤不会执行
+type CheckResult struct {
+	OK      bool
+	Steps   []CheckStep
+	Folders []string
+}
+
+// CheckConnection 按配置实际连接IMAP和SMTP服务器并登录，不收取或发送邮件
+func CheckConnection(config Config) CheckResult {
+	c := NewMailClient(nil)
+	c.config = config
+
+	result := CheckResult{OK: true}
+	run := func(name string, step func() (string, error)) bool {
+		start := time.Now()
+		detail, err := step()
+		s := CheckStep{Name: name, OK: err == nil, DurationMs: time.Since(start).Milliseconds(), Detail: detail}
+		if err != nil {
+			s.Error = err.Error()
+			result.OK = false
+		}
+		result.Steps = append(result.Steps, s)
+		return err == nil
+	}
+
+	var imapClient *client.Client
+	connected := run(CheckIMAPConnect, func() (string, error) {
+		var err error
+		if imapClient, err = c.dialIMAP(); err != nil {
+			return "", err
+		}
+		imapClient.Timeout = checkTimeout
+		addr, _, mode := imapAddress(config.Server, config.IMAPSecurity)
+		return addr + " (" + mode + ")", nil
+	})
+	if connected {
+		loggedIn := run(CheckIMAPLogin, func() (string, error) {
+			return "", c.login(imapClient)
+		})
+		if loggedIn {
+			run(CheckIMAPList, func() (string, error) {
+				folders, err := listFolders(imapClient)
+				for _, folder := range folders {
+					result.Folders = append(result.Folders, folder.Name)
+				}
+				return fmt.Sprintf("%d 个文件夹", len(folders)), err
+			})
+		}
+		imapClient.Logout()
+	}
+
+	var smtpClient *smtp.Client
+	var host string
+	connected = run(CheckSMTPConnect, func() (string, error) {
+		var err error
+		if smtpClient, host, err = c.connectSMTP(time.Now().Add(checkTimeout)); err != nil {
+			return "", err
+		}
+		if ok, mechanisms := smtpClient.Extension("AUTH"); ok {
+			return "AUTH " + mechanisms, nil
+		}
+		return "服务器未提供AUTH扩展", nil
+	})
+	if connected {
+		run(CheckSMTPAuth, func() (string, error) {
+			if config.SMTPAuth == models.SMTPAuthNone {
+				return "未启用认证", nil
+			}
+			return "", c.authSMTP(smtpClient, host)
+		})
+		smtpClient.Quit()
+		smtpClient.Close()
+	}
+
+	return result
+}
@@ -1,13 +1,15 @@
 package mail
 
 import (
-	"bytes"
-	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"net/smtp"
+	"net/textproto"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"mail-dispatcher/internal/config"
@@ -15,14 +17,55 @@ import (
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message"
 )
 
+// ErrIdleNotSupported 服务器不支持IDLE扩展，调用方应回退到轮询模式
+var ErrIdleNotSupported = errors.New("服务器不支持IDLE推送")
+
+// AuthError 服务器拒绝凭据或授权已失效，重试不会恢复，需要检查账户的凭据
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return "认证失败: " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// IsAuthError 判断错误是否由认证失败引起
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr)
+}
+
+// isConnectionError 判断错误是否由网络中断引起，这类错误不代表凭据有误
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// go-imap 没有导出连接关闭的错误，只能按消息判断
+	return strings.HasPrefix(err.Error(), "imap: connection closed")
+}
+
+// maxIdleBackoff IDLE断线重连的最大等待时间
+const maxIdleBackoff = 5 * time.Minute
+
+// folderCheckInterval 推送模式下监控多个文件夹时，检查IDLE以外文件夹的间隔
+const folderCheckInterval = 5 * time.Minute
+
 // MailClient 邮件客户端（支持IMAP获取和SMTP发送）
 type MailClient struct {
 	config    Config
 	client    *client.Client
 	stopChan  chan bool
+	stopOnce  sync.Once
+	newMail   chan struct{}
+	mu        sync.Mutex
+	listening chan struct{}
 	appConfig *config.Config
 }
 
@@ -30,56 +73,78 @@ type MailClient struct {
 func NewMailClient(appConfig *config.Config) *MailClient {
 	return &MailClient{
 		stopChan:  make(chan bool),
+		newMail:   make(chan struct{}, 1),
 		appConfig: appConfig,
 	}
 }
 
+// NewSender 创建只用于发送的邮件客户端，按 config 中的SMTP配置发送，不连接IMAP服务器
+func NewSender(appConfig *config.Config, config Config) *MailClient {
+	c := NewMailClient(appConfig)
+	c.config = config
+	return c
+}
+
 // Init 初始化邮件连接
 func (c *MailClient) Init(config Config) error {
 	c.config = config
 
-	// 解析服务器地址
-	server := config.Server
-	if !strings.Contains(server, ":") {
-		server += ":993" // 默认IMAPS端口
-	}
-
 	// 连接IMAP服务器
-	imapClient, err := client.DialTLS(server, &tls.Config{InsecureSkipVerify: true})
+	imapClient, err := c.dialIMAP()
 	if err != nil {
-		return fmt.Errorf("连接IMAP服务器失败: %v", err)
+		return fmt.Errorf("连接IMAP服务器失败: %w", err)
 	}
 
 	// 登录
-	if err := imapClient.Login(config.Username, config.Password); err != nil {
+	if err := c.login(imapClient); err != nil {
 		imapClient.Logout()
-		return fmt.Errorf("IMAP登录失败: %v", err)
+		return fmt.Errorf("IMAP登录失败: %w", err)
 	}
 
 	c.client = imapClient
+	c.watchUpdates(imapClient)
 	log.Printf("邮件客户端初始化成功: %s", config.Address)
 	return nil
 }
 
-// FetchNewEmails 获取新邮件
-func (c *MailClient) FetchNewEmails() ([]models.Email, error) {
+// Folders 返回监控的文件夹，未配置时只有收件箱
+func (c *MailClient) Folders() []string {
+	if len(c.config.Folders) == 0 {
+		return []string{DefaultFolder}
+	}
+	folders := make([]string, 0, len(c.config.Folders))
+	for _, folder := range c.config.Folders {
+		folders = append(folders, folder.Folder)
+	}
+	return folders
+}
+
+// folderSync 返回文件夹的水位线，不存在时新建
+func (c *MailClient) folderSync(folder string) *FolderSync {
+	for i := range c.config.Folders {
+		if SameFolder(c.config.Folders[i].Folder, folder) {
+			return &c.config.Folders[i]
+		}
+	}
+	c.config.Folders = append(c.config.Folders, FolderSync{Folder: folder})
+	return &c.config.Folders[len(c.config.Folders)-1]
+}
+
+// FetchNewEmails 获取文件夹中UID高于水位线的新邮件，结果按UID升序排列
+// 文件夹以读写方式选中，之后可以对返回的邮件执行处理后操作
+func (c *MailClient) FetchNewEmails(folder string) ([]models.Email, error) {
 	// 检查连接状态，如果断开则重连
 	if err := c.ensureConnection(); err != nil {
-		return nil, fmt.Errorf("确保连接失败: %v", err)
+		return nil, fmt.Errorf("确保连接失败: %w", err)
 	}
 
-	// 选择收件箱
-	_, err := c.client.Select("INBOX", false)
+	mbox, err := c.client.Select(folder, false)
 	if err != nil {
-		return nil, fmt.Errorf("选择收件箱失败: %v", err)
+		return nil, fmt.Errorf("选择文件夹 %s 失败: %v", folder, err)
 	}
 
-	// 搜索未读邮件
-	criteria := imap.NewSearchCriteria()
-	criteria.Since = time.Now().AddDate(0, 0, -7) // 最近7天的邮件
-	criteria.WithoutFlags = []string{imap.SeenFlag}
-
-	uids, err := c.client.Search(criteria)
+	sync := c.folderSync(folder)
+	uids, err := c.searchNewUIDs(mbox, sync)
 	if err != nil {
 		return nil, fmt.Errorf("搜索邮件失败: %v", err)
 	}
@@ -92,11 +157,11 @@ func (c *MailClient) FetchNewEmails() ([]models.Email, error) {
 	seqset := new(imap.SeqSet)
 	seqset.AddNum(uids...)
 
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchBody, imap.FetchUid}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchUid, fullBodySection.FetchItem()}
 	messages := make(chan *imap.Message, len(uids))
 
 	// 同步获取邮件
-	if err := c.client.Fetch(seqset, items, messages); err != nil {
+	if err := c.client.UidFetch(seqset, items, messages); err != nil {
 		return nil, fmt.Errorf("获取邮件内容失败: %v", err)
 	}
 
@@ -108,12 +173,208 @@ func (c *MailClient) FetchNewEmails() ([]models.Email, error) {
 			log.Printf("解析邮件失败: %v", err)
 			continue
 		}
+		email.Folder = folder
+		email.UIDValidity = sync.UIDValidity
 		emails = append(emails, email)
 	}
 
+	sort.Slice(emails, func(i, j int) bool { return emails[i].UID < emails[j].UID })
+
+	// 推进内存中的水位线，由调用方在处理完成后持久化
+	last := uids[len(uids)-1]
+	if last > sync.LastUID {
+		sync.LastUID = last
+	}
+	sync.batchEnd = last
+
 	return emails, nil
 }
 
+// FetchByUID 按UID重新获取文件夹中的一封邮件，文件夹为空表示收件箱，UIDVALIDITY 不一致时无法定位原邮件
+func (c *MailClient) FetchByUID(folder string, uid, uidValidity uint32) (models.Email, error) {
+	if err := c.ensureConnection(); err != nil {
+		return models.Email{}, fmt.Errorf("确保连接失败: %v", err)
+	}
+	if folder == "" {
+		folder = DefaultFolder
+	}
+
+	// 只读方式打开，不影响邮件状态
+	mbox, err := c.client.Select(folder, true)
+	if err != nil {
+		return models.Email{}, fmt.Errorf("选择文件夹 %s 失败: %v", folder, err)
+	}
+	if uidValidity != 0 && mbox.UidValidity != uidValidity {
+		return models.Email{}, fmt.Errorf("文件夹 %s 的 UIDVALIDITY 已变化 (%d -> %d)，无法定位原邮件", folder, uidValidity, mbox.UidValidity)
+	}
+
+	email, err := c.fetchUID(uid)
+	if err != nil {
+		return models.Email{}, err
+	}
+	email.Folder = folder
+	email.UIDValidity = mbox.UidValidity
+
+	return email, nil
+}
+
+// FetchByMessageID 在指定文件夹中按 Message-ID 查找邮件，用于重试已被处理后操作移动的邮件
+func (c *MailClient) FetchByMessageID(folder, messageID string) (models.Email, error) {
+	if err := c.ensureConnection(); err != nil {
+		return models.Email{}, fmt.Errorf("确保连接失败: %v", err)
+	}
+
+	mbox, err := c.client.Select(folder, true)
+	if err != nil {
+		return models.Email{}, fmt.Errorf("选择文件夹 %s 失败: %v", folder, err)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", messageID)
+	uids, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return models.Email{}, fmt.Errorf("搜索邮件失败: %v", err)
+	}
+	if len(uids) == 0 {
+		return models.Email{}, fmt.Errorf("文件夹 %s 中没有 Message-ID 为 %s 的邮件", folder, messageID)
+	}
+
+	email, err := c.fetchUID(uids[0])
+	if err != nil {
+		return models.Email{}, err
+	}
+	email.Folder = folder
+	email.UIDValidity = mbox.UidValidity
+
+	return email, nil
+}
+
+// fetchUID 获取当前文件夹中指定UID的邮件
+func (c *MailClient) fetchUID(uid uint32) (models.Email, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchUid, fullBodySection.FetchItem()}
+	messages := make(chan *imap.Message, 1)
+	if err := c.client.UidFetch(seqset, items, messages); err != nil {
+		return models.Email{}, fmt.Errorf("获取邮件内容失败: %v", err)
+	}
+
+	msg := <-messages
+	if msg == nil {
+		return models.Email{}, fmt.Errorf("邮件不存在: UID %d", uid)
+	}
+
+	return c.parseMessage(msg)
+}
+
+// searchNewUIDs 搜索高于水位线的邮件UID，并处理UIDVALIDITY变化
+func (c *MailClient) searchNewUIDs(mbox *imap.MailboxStatus, sync *FolderSync) ([]uint32, error) {
+	if mbox.UidValidity != sync.UIDValidity {
+		if sync.UIDValidity != 0 {
+			log.Printf("UIDVALIDITY 已变化 (%s %s): %d -> %d，重新同步",
+				c.config.Address, sync.Folder, sync.UIDValidity, mbox.UidValidity)
+		}
+		sync.UIDValidity = mbox.UidValidity
+		sync.LastUID = 0
+	}
+
+	criteria := imap.NewSearchCriteria()
+	sync.initial = sync.LastUID == 0
+	if sync.initial {
+		// 首次同步：只处理最近7天的未读邮件，之后完全以UID为准
+		criteria.Since = time.Now().AddDate(0, 0, -7)
+		criteria.WithoutFlags = []string{imap.SeenFlag}
+
+		uids, err := c.client.UidSearch(criteria)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+		// 以当前邮箱的最大UID作为初始水位线
+		highest, err := c.highestUID(mbox)
+		if err != nil {
+			return nil, err
+		}
+		sync.LastUID = highest
+		return uids, nil
+	}
+
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(sync.LastUID+1, 0)
+
+	found, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	// "N:*" 在没有新邮件时仍会匹配最大UID的邮件，需要过滤
+	var uids []uint32
+	for _, uid := range found {
+		if uid > sync.LastUID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	return uids, nil
+}
+
+// highestUID 返回文件夹中的最大UID，服务器未返回 UIDNEXT 时使用 UID SEARCH UID * 查询
+func (c *MailClient) highestUID(mbox *imap.MailboxStatus) (uint32, error) {
+	if mbox.UidNext > 0 {
+		return mbox.UidNext - 1, nil
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddNum(0) // 0 表示 "*"
+	uids, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return 0, err
+	}
+	var highest uint32
+	for _, uid := range uids {
+		if uid > highest {
+			highest = uid
+		}
+	}
+	return highest, nil
+}
+
+// Rewind 处理邮件失败时将水位线退回到该邮件之前，下次获取时重新处理
+// 首次同步跳过了已读和较早的邮件，退回到UID会重新拉取这些邮件，因此改为重新执行首次同步
+func (c *MailClient) Rewind(folder string, uid uint32) {
+	sync := c.folderSync(folder)
+	if sync.initial {
+		sync.LastUID = 0
+		return
+	}
+	if uid-1 < sync.LastUID {
+		sync.LastUID = uid - 1
+	}
+}
+
+// Progress 返回处理完该邮件后可以持久化的水位线
+// 首次同步的一批邮件全部处理完之前水位线保持为0，中断后重新执行首次同步
+func (c *MailClient) Progress(folder string, uid uint32) (uidValidity, lastUID uint32) {
+	sync := c.folderSync(folder)
+	switch {
+	case uid >= sync.batchEnd:
+		return sync.UIDValidity, sync.LastUID
+	case sync.initial:
+		return sync.UIDValidity, 0
+	}
+	return sync.UIDValidity, uid
+}
+
+// SyncState 返回文件夹当前的UIDVALIDITY和已扫描到的最大UID
+func (c *MailClient) SyncState(folder string) (uidValidity, lastUID uint32) {
+	sync := c.folderSync(folder)
+	return sync.UIDValidity, sync.LastUID
+}
+
 // ensureConnection 确保连接可用，如果断开则重连
 func (c *MailClient) ensureConnection() error {
 	if c.client == nil {
@@ -136,12 +397,6 @@ func (c *MailClient) reconnect() error {
 		c.client = nil
 	}
 
-	// 解析服务器地址
-	server := c.config.Server
-	if !strings.Contains(server, ":") {
-		server += ":993" // 默认IMAPS端口
-	}
-
 	// 使用配置中的重试参数
 	maxRetryCount := 3
 	retryInterval := 1
@@ -155,353 +410,322 @@ func (c *MailClient) reconnect() error {
 	var err error
 
 	for i := 0; i < maxRetryCount; i++ {
-		imapClient, err = client.DialTLS(server, &tls.Config{InsecureSkipVerify: true})
-		if err == nil {
+		imapClient, err = c.dialIMAP()
+		// 证书问题重试也不会恢复
+		if err == nil || IsTLSError(err) {
 			break
 		}
 		time.Sleep(time.Duration(retryInterval) * time.Second)
 	}
 
 	if err != nil {
-		return fmt.Errorf("连接IMAP服务器失败: %v", err)
+		return fmt.Errorf("连接IMAP服务器失败: %w", err)
 	}
 
-	// 登录，添加重试机制
+	// 登录，添加重试机制，密码错误时重试可能导致账户被服务商锁定
 	var loginErr error
 	for i := 0; i < maxRetryCount; i++ {
-		if err := imapClient.Login(c.config.Username, c.config.Password); err == nil {
+		if loginErr = c.login(imapClient); loginErr == nil || IsAuthError(loginErr) {
 			break
-		} else {
-			loginErr = err
 		}
 		time.Sleep(time.Duration(retryInterval) * time.Second)
 	}
 
 	if loginErr != nil {
 		imapClient.Logout()
-		return fmt.Errorf("IMAP登录失败: %v", loginErr)
+		return fmt.Errorf("IMAP登录失败: %w", loginErr)
 	}
 
 	c.client = imapClient
+	c.watchUpdates(imapClient)
 	return nil
 }
 
-// StartPushListener 启动推送监听（已禁用，只使用轮询）
-func (c *MailClient) StartPushListener(callback func(models.Email)) error {
-	// IDLE 推送已禁用，只使用轮询模式
-	return fmt.Errorf("IDLE推送已禁用，使用轮询模式")
+// watchUpdates 接收服务器的主动通知，收到新邮件(EXISTS)时发出信号
+func (c *MailClient) watchUpdates(imapClient *client.Client) {
+	// go-imap 以阻塞方式投递更新，必须持续读取直到连接关闭
+	updates := make(chan client.Update, 32)
+	imapClient.Updates = updates
+
+	go func() {
+		for {
+			select {
+			case update := <-updates:
+				if _, ok := update.(*client.MailboxUpdate); ok {
+					select {
+					case c.newMail <- struct{}{}:
+					default:
+					}
+				}
+			case <-imapClient.LoggedOut():
+				return
+			}
+		}
+	}()
 }
 
-// Stop 停止Provider
-func (c *MailClient) Stop() error {
-	close(c.stopChan)
-	if c.client != nil {
-		return c.client.Logout()
+// StartPushListener 启动IDLE推送监听，阻塞直到 Stop 被调用
+// 服务器不支持IDLE时返回 ErrIdleNotSupported，连接断开后会自动重建
+// callback 返回错误时水位线退回到该邮件之前，重连后从该邮件重新获取
+func (c *MailClient) StartPushListener(callback func(models.Email) error) error {
+	if err := c.ensureConnection(); err != nil {
+		return fmt.Errorf("确保连接失败: %w", err)
 	}
-	return nil
-}
-
-// GetName 获取Provider名称
-func (c *MailClient) GetName() string {
-	return "IMAP"
-}
 
-// SendEmail 发送邮件
-func (c *MailClient) SendEmail(email models.Email, toEmail string) error {
-	// 构建邮件头
-	headers := make(map[string]string)
-	headers["From"] = c.config.Username
-	headers["To"] = toEmail
-	headers["Subject"] = email.Subject
-	headers["Resent-From"] = c.config.Username
-	headers["Resent-To"] = toEmail
-	headers["X-Forwarded-By"] = "Mail-Dispatcher-System"
-
-	// 如果有原始发件人信息，保留在邮件头中
-	if email.From != "" {
-		headers["Original-From"] = email.From
+	supported, err := c.client.Support("IDLE")
+	if err != nil {
+		return fmt.Errorf("查询服务器能力失败: %v", err)
 	}
-
-	// 构建邮件内容
-	var body bytes.Buffer
-
-	// 写入邮件头
-	for key, value := range headers {
-		body.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	if !supported {
+		return ErrIdleNotSupported
 	}
-	body.WriteString("\r\n")
 
-	// 写入邮件正文
-	if email.Body != "" {
-		body.WriteString(email.Body)
-	} else {
-		body.WriteString("邮件内容")
+	c.mu.Lock()
+	select {
+	case <-c.stopChan:
+		c.mu.Unlock()
+		return nil
+	default:
 	}
+	listening := make(chan struct{})
+	c.listening = listening
+	c.mu.Unlock()
+	defer close(listening)
 
-	// 解析SMTP服务器地址和端口
-	smtpServer := c.getSMTPServer()
-	smtpPort := c.getSMTPPort()
+	log.Printf("IDLE推送监听已启动: %s", c.config.Address)
 
-	// 尝试发送邮件，支持不同的连接方式
-	err := c.sendMailWithFallback(smtpServer, smtpPort, toEmail, body.Bytes())
-	if err != nil {
-		return fmt.Errorf("发送邮件失败: %v", err)
-	}
+	backoff := time.Second
+	for {
+		err := c.idleOnce(callback)
 
-	log.Printf("IMAP Provider 邮件发送成功: %s -> %s", email.Subject, toEmail)
-	return nil
-}
+		select {
+		case <-c.stopChan:
+			return nil
+		default:
+		}
 
-// sendMailWithFallback 尝试多种方式发送邮件
-func (c *MailClient) sendMailWithFallback(smtpServer, smtpPort, toEmail string, body []byte) error {
-	// 方法1: 尝试 STARTTLS (端口587)
-	if smtpPort == "587" {
-		err := c.sendMailWithSTARTTLS(smtpServer, smtpPort, toEmail, body)
 		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		log.Printf("IDLE监听中断 (%s): %v，%v 后重连", c.config.Address, err, backoff)
+		select {
+		case <-c.stopChan:
 			return nil
+		case <-time.After(backoff):
 		}
-	}
 
-	// 方法2: 尝试 SSL/TLS (端口465)
-	err := c.sendMailWithSSL(smtpServer, "465", toEmail, body)
-	if err == nil {
-		return nil
-	}
+		// 处理邮件持续失败时同样逐渐延长间隔，直到一次IDLE正常结束
+		backoff *= 2
+		if backoff > maxIdleBackoff {
+			backoff = maxIdleBackoff
+		}
 
-	// 方法3: 尝试普通连接 (端口25)
-	err = c.sendMailPlain(smtpServer, "25", toEmail, body)
-	if err == nil {
-		return nil
+		if err := c.reconnect(); err != nil {
+			// 凭据失效时交给调用方处理，避免反复登录
+			if IsAuthError(err) {
+				return err
+			}
+			log.Printf("IDLE重连失败 (%s): %v", c.config.Address, err)
+		}
 	}
-
-	return fmt.Errorf("所有发送方式都失败")
 }
 
-// sendMailWithSTARTTLS 使用 STARTTLS 发送邮件
-func (c *MailClient) sendMailWithSTARTTLS(smtpServer, port, toEmail string, body []byte) error {
-	addr := fmt.Sprintf("%s:%s", smtpServer, port)
-
-	// 连接到SMTP服务器
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("连接SMTP服务器失败: %v", err)
-	}
-	defer conn.Close()
-
-	// 创建SMTP客户端
-	client, err := smtp.NewClient(conn, smtpServer)
-	if err != nil {
-		return fmt.Errorf("创建SMTP客户端失败: %v", err)
-	}
-	defer client.Close()
-
-	// 启用STARTTLS
-	if err = client.StartTLS(&tls.Config{ServerName: smtpServer}); err != nil {
-		return fmt.Errorf("启用STARTTLS失败: %v", err)
-	}
-
-	// 认证
-	if err = client.Auth(smtp.PlainAuth("", c.config.Username, c.config.Password, smtpServer)); err != nil {
-		return fmt.Errorf("SMTP认证失败: %v", err)
+// idleOnce 处理各监控文件夹中已到达的新邮件，然后在第一个文件夹上进入IDLE等待新邮件通知
+// IDLE 只能监听一个文件夹，监控多个文件夹时每隔 folderCheckInterval 结束IDLE检查其他文件夹
+func (c *MailClient) idleOnce(callback func(models.Email) error) error {
+	folders := c.Folders()
+	for _, folder := range folders[1:] {
+		emails, err := c.FetchNewEmails(folder)
+		if err != nil {
+			return err
+		}
+		if err := c.dispatch(folder, emails, callback); err != nil {
+			return err
+		}
 	}
 
-	// 发送邮件
-	if err = client.Mail(c.config.Username); err != nil {
-		return fmt.Errorf("设置发件人失败: %v", err)
+	if _, err := c.client.Select(folders[0], false); err != nil {
+		return fmt.Errorf("选择文件夹 %s 失败: %v", folders[0], err)
 	}
 
-	if err = client.Rcpt(toEmail); err != nil {
-		return fmt.Errorf("设置收件人失败: %v", err)
+	// SELECT 本身会触发 EXISTS 通知，丢弃后再主动拉取一次
+	select {
+	case <-c.newMail:
+	default:
 	}
 
-	w, err := client.Data()
+	emails, err := c.FetchNewEmails(folders[0])
 	if err != nil {
-		return fmt.Errorf("开始发送邮件数据失败: %v", err)
-	}
-
-	_, err = w.Write(body)
-	if err != nil {
-		return fmt.Errorf("写入邮件数据失败: %v", err)
+		return err
+	}
+	if err := c.dispatch(folders[0], emails, callback); err != nil {
+		return err
+	}
+
+	var recheck <-chan time.Time
+	if len(folders) > 1 {
+		timer := time.NewTimer(folderCheckInterval)
+		defer timer.Stop()
+		recheck = timer.C
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	imapClient := c.client
+	go func() {
+		done <- imapClient.Idle(stop, nil)
+	}()
+
+	select {
+	case <-c.newMail:
+		close(stop)
+		return <-done
+	case <-recheck:
+		close(stop)
+		return <-done
+	case err := <-done:
+		if err == nil {
+			err = errors.New("IDLE意外结束")
+		}
+		return err
+	case <-imapClient.LoggedOut():
+		close(stop)
+		<-done
+		return errors.New("IMAP连接已断开")
+	case <-c.stopChan:
+		close(stop)
+		<-done
+		return nil
 	}
+}
 
-	err = w.Close()
-	if err != nil {
-		return fmt.Errorf("完成发送邮件失败: %v", err)
+// dispatch 依次处理一批邮件，失败时水位线退回到该邮件之前并停止处理后续邮件
+func (c *MailClient) dispatch(folder string, emails []models.Email, callback func(models.Email) error) error {
+	for _, email := range emails {
+		if err := callback(email); err != nil {
+			c.Rewind(folder, email.UID)
+			return fmt.Errorf("处理邮件失败 (%s UID %d): %w", folder, email.UID, err)
+		}
 	}
-
 	return nil
 }
 
-// sendMailWithSSL 使用 SSL/TLS 发送邮件
-func (c *MailClient) sendMailWithSSL(smtpServer, port, toEmail string, body []byte) error {
-	addr := fmt.Sprintf("%s:%s", smtpServer, port)
-
-	// 创建TLS配置
-	tlsConfig := &tls.Config{
-		ServerName: smtpServer,
-	}
-
-	// 连接到SMTP服务器
-	conn, err := tls.Dial("tcp", addr, tlsConfig)
-	if err != nil {
-		return fmt.Errorf("连接SMTP服务器失败: %v", err)
-	}
-	defer conn.Close()
-
-	// 创建SMTP客户端
-	client, err := smtp.NewClient(conn, smtpServer)
-	if err != nil {
-		return fmt.Errorf("创建SMTP客户端失败: %v", err)
-	}
-	defer client.Close()
-
-	// 认证
-	if err = client.Auth(smtp.PlainAuth("", c.config.Username, c.config.Password, smtpServer)); err != nil {
-		return fmt.Errorf("SMTP认证失败: %v", err)
-	}
-
-	// 发送邮件
-	if err = client.Mail(c.config.Username); err != nil {
-		return fmt.Errorf("设置发件人失败: %v", err)
-	}
+// Stop 停止Provider，会等待推送监听退出后再断开连接
+func (c *MailClient) Stop() error {
+	var err error
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		close(c.stopChan)
+		listening := c.listening
+		c.mu.Unlock()
+
+		if listening != nil {
+			<-listening
+		}
+		if c.client != nil {
+			err = c.client.Logout()
+		}
+	})
+	return err
+}
 
-	if err = client.Rcpt(toEmail); err != nil {
-		return fmt.Errorf("设置收件人失败: %v", err)
-	}
+// GetName 获取Provider名称
+func (c *MailClient) GetName() string {
+	return "IMAP"
+}
 
-	w, err := client.Data()
+// SendEmail 按转发方式构建邮件并发送，mode 为空时使用 redirect
+func (c *MailClient) SendEmail(email models.Email, toEmail, mode string) error {
+	body, err := c.buildMessage(email, toEmail, mode)
 	if err != nil {
-		return fmt.Errorf("开始发送邮件数据失败: %v", err)
+		return fmt.Errorf("构建转发邮件失败: %v", err)
 	}
 
-	_, err = w.Write(body)
-	if err != nil {
-		return fmt.Errorf("写入邮件数据失败: %v", err)
+	if c.config.DKIM != nil {
+		if body, err = c.config.DKIM.sign(body); err != nil {
+			return err
+		}
 	}
 
-	err = w.Close()
-	if err != nil {
-		return fmt.Errorf("完成发送邮件失败: %v", err)
+	if err := c.sendMail(c.envelopeSender(email), toEmail, body); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
 	}
 
+	log.Printf("IMAP Provider 邮件发送成功: %s -> %s", email.Subject, toEmail)
 	return nil
 }
 
-// sendMailPlain 使用普通连接发送邮件
-func (c *MailClient) sendMailPlain(smtpServer, port, toEmail string, body []byte) error {
-	addr := fmt.Sprintf("%s:%s", smtpServer, port)
-
-	// 连接到SMTP服务器
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("连接SMTP服务器失败: %v", err)
+// SendRawEmail 发送原始邮件数据
+func (c *MailClient) SendRawEmail(rawData []byte, toEmail string) error {
+	email := models.Email{RawData: rawData}
+	if err := parseBody(&email, rawData); err != nil {
+		log.Printf("解析邮件正文失败: %v", err)
 	}
-	defer conn.Close()
 
-	// 创建SMTP客户端
-	client, err := smtp.NewClient(conn, smtpServer)
-	if err != nil {
-		return fmt.Errorf("创建SMTP客户端失败: %v", err)
+	if err := c.SendEmail(email, toEmail, models.ForwardModeRedirect); err != nil {
+		return fmt.Errorf("发送原始邮件失败: %w", err)
 	}
-	defer client.Close()
 
-	// 发送邮件
-	if err = client.Mail(c.config.Username); err != nil {
-		return fmt.Errorf("设置发件人失败: %v", err)
-	}
+	log.Printf("IMAP Provider 原始邮件发送成功: %s", toEmail)
+	return nil
+}
 
-	if err = client.Rcpt(toEmail); err != nil {
-		return fmt.Errorf("设置收件人失败: %v", err)
-	}
+// buildMessage 按转发方式构建待发送的邮件，没有原文时只能以正文引用的方式转发
+func (c *MailClient) buildMessage(email models.Email, toEmail, mode string) ([]byte, error) {
+	info := forwardInfo{
+		From: c.config.Address,
+		To:   toEmail,
+		Hops: ForwardHops(email) + 1,
+		Date: time.Now(),
 
-	w, err := client.Data()
-	if err != nil {
-		return fmt.Errorf("开始发送邮件数据失败: %v", err)
+		Rewrite: c.config.FromMode == models.FromModeRewrite,
 	}
-
-	_, err = w.Write(body)
-	if err != nil {
-		return fmt.Errorf("写入邮件数据失败: %v", err)
+	if info.From == "" {
+		info.From = c.config.Username
 	}
 
-	err = w.Close()
-	if err != nil {
-		return fmt.Errorf("完成发送邮件失败: %v", err)
+	if len(email.RawData) == 0 {
+		return buildInline(email, info)
 	}
 
-	return nil
-}
-
-// SendRawEmail 发送原始邮件数据
-func (c *MailClient) SendRawEmail(rawData []byte, toEmail string) error {
-	// 添加转发头信息
-	forwardedData := c.addForwardHeaders(rawData, toEmail)
-
-	// 解析SMTP服务器地址和端口
-	smtpServer := c.getSMTPServer()
-	smtpPort := c.getSMTPPort()
-
-	// 尝试发送邮件
-	err := c.sendMailWithFallback(smtpServer, smtpPort, toEmail, forwardedData)
-	if err != nil {
-		return fmt.Errorf("发送原始邮件失败: %v", err)
+	switch mode {
+	case models.ForwardModeWrap:
+		return buildWrapped(email, info)
+	case models.ForwardModeInline:
+		return buildInline(email, info)
 	}
-
-	log.Printf("IMAP Provider 原始邮件发送成功: %s", toEmail)
-	return nil
+	return buildRedirect(email.RawData, info)
 }
 
-// addForwardHeaders 添加转发头信息
-func (c *MailClient) addForwardHeaders(rawData []byte, toEmail string) []byte {
-	// 添加转发相关的头信息
-	headers := []string{
-		fmt.Sprintf("Resent-From: %s", c.config.Username),
-		fmt.Sprintf("Resent-To: %s", toEmail),
-		"X-Forwarded-By: Mail-Dispatcher-System",
-		"",
+// envelopeSender 返回信封发件人，SRS 模式下改写原邮件的退信地址，
+// 改写失败时回退到账户地址
+func (c *MailClient) envelopeSender(email models.Email) string {
+	if c.config.EnvelopeMode != models.EnvelopeModeSRS || c.config.SRS == nil {
+		return c.config.Username
 	}
 
-	// 在原始数据前添加头信息
-	var result []byte
-	for _, header := range headers {
-		result = append(result, []byte(header+"\r\n")...)
+	sender := email.From
+	if returnPath := firstHeader(email.Headers, "Return-Path"); returnPath != "" {
+		sender = strings.Trim(strings.TrimSpace(returnPath), "<>")
 	}
-	result = append(result, rawData...)
 
-	return result
-}
-
-// getSMTPServer 获取SMTP服务器地址
-func (c *MailClient) getSMTPServer() string {
-	// 根据IMAP服务器推断SMTP服务器
-	server := c.config.Server
-	if strings.Contains(server, "qq.com") {
-		return "smtp.qq.com"
-	} else if strings.Contains(server, "gmail.com") {
-		return "smtp.gmail.com"
-	} else if strings.Contains(server, "163.com") {
-		return "smtp.163.com"
-	} else if strings.Contains(server, "126.com") {
-		return "smtp.126.com"
+	rewritten, err := c.config.SRS.Forward(sender, time.Now())
+	if err != nil {
+		log.Printf("改写信封发件人失败，使用账户地址: %v", err)
+		return c.config.Username
 	}
-	// 默认使用IMAP服务器对应的SMTP服务器
-	return strings.Replace(server, "imap.", "smtp.", 1)
+	return rewritten
 }
 
-// getSMTPPort 获取SMTP端口
-func (c *MailClient) getSMTPPort() string {
-	// 根据IMAP服务器推断SMTP端口
-	server := c.config.Server
-	if strings.Contains(server, "qq.com") {
-		return "587"
-	} else if strings.Contains(server, "gmail.com") {
-		return "587"
-	} else if strings.Contains(server, "163.com") {
-		return "25"
-	} else if strings.Contains(server, "126.com") {
-		return "25"
+// SMTPCode 从发送错误中提取SMTP响应码，不是服务器响应导致的错误返回0
+func SMTPCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
 	}
-	return "587" // 默认使用587端口
+	return 0
 }
 
 // parseMessage 解析IMAP消息
@@ -509,51 +733,44 @@ func (c *MailClient) parseMessage(msg *imap.Message) (models.Email, error) {
 	email := models.Email{
 		ReceivedAt: time.Now(),
 	}
+	if !msg.InternalDate.IsZero() {
+		email.ReceivedAt = msg.InternalDate
+	}
 
 	// 首先尝试使用 IMAP envelope 数据
 	if msg.Envelope != nil {
-		if len(msg.Envelope.Subject) > 0 {
-			// 获取完整的主题字节数组
-			subjectBytes := msg.Envelope.Subject
-			// 直接使用 UTF-8 解码
-			subject := string(subjectBytes)
-			email.Subject = subject
-		}
+		// go-imap 已通过 imap.CharsetReader 解码编码字
+		email.Subject = msg.Envelope.Subject
 		if len(msg.Envelope.From) > 0 {
 			email.From = msg.Envelope.From[0].Address()
+			email.FromName = msg.Envelope.From[0].PersonalName
 		}
 		if len(msg.Envelope.To) > 0 {
 			email.To = msg.Envelope.To[0].Address()
 		}
 	}
 
-	// 如果 envelope 没有数据，尝试使用 go-message 解析邮件正文
-	if email.Subject == "" && msg.Body != nil {
-		section := &imap.BodySectionName{}
-		r := msg.GetBody(section)
-		if r != nil {
-			// 使用 go-message 解析邮件
-			entity, err := message.Read(r)
-			if err != nil {
-				log.Printf("解析邮件失败: %v", err)
-			} else {
-				// 解析邮件头
-				header := entity.Header
-				if subject := header.Get("Subject"); subject != "" {
-					email.Subject = subject
-				}
-				if from := header.Get("From"); from != "" {
-					email.From = from
-				}
-				if to := header.Get("To"); to != "" {
-					email.To = to
-				}
-			}
+	// 读取完整原文，以原始邮件头为准解码主题和地址并解析正文
+	if r := msg.GetBody(fullBodySection); r != nil {
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return email, fmt.Errorf("读取邮件原文失败: %v", err)
+		}
+		email.RawData = raw
+
+		if err := parseBody(&email, raw); err != nil {
+			log.Printf("解析邮件正文失败: %v", err)
 		}
 	}
 
-	// 生成MessageID
-	email.MessageID = fmt.Sprintf("%d-%d", c.config.AccountID, msg.Uid)
+	email.UID = msg.Uid
+
+	// 使用邮件自身的 Message-ID 和内容摘要去重，同一封邮件出现在多个账户中也只处理一次
+	email.MessageID = normalizeMessageID(firstHeader(email.Headers, "Message-Id"))
+	if email.MessageID == "" && msg.Envelope != nil {
+		email.MessageID = normalizeMessageID(msg.Envelope.MessageId)
+	}
+	email.ContentHash = contentHash(email)
 
 	return email, nil
 }
@@ -1,8 +1,24 @@
 package mail
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/models"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"golang.org/x/oauth2"
 )
 
 func TestMailClient_Init(t *testing.T) {
@@ -30,3 +46,284 @@ func TestMailClient_GetName(t *testing.T) {
 		t.Errorf("期望 'IMAP'，得到 '%s'", client.GetName())
 	}
 }
+
+func TestMailClient_StopTwice(t *testing.T) {
+	client := NewMailClient(&config.Config{})
+	if err := client.Stop(); err != nil {
+		t.Errorf("未连接时停止不应报错: %v", err)
+	}
+	if err := client.Stop(); err != nil {
+		t.Errorf("重复停止不应报错: %v", err)
+	}
+}
+
+func TestSMTPCode(t *testing.T) {
+	rejected := &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"服务器拒收", rejected, 550},
+		{"多层包装", fmt.Errorf("发送邮件失败: %w", fmt.Errorf("设置收件人失败: %w", rejected)), 550},
+		{"连接失败", errors.New("dial tcp: connection refused"), 0},
+		{"无错误", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SMTPCode(tt.err); got != tt.want {
+				t.Errorf("SMTPCode() = %d, 期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"连接被拒绝", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"连接中断", fmt.Errorf("读取响应失败: %w", io.ErrUnexpectedEOF), true},
+		{"命令执行期间连接关闭", errors.New("imap: connection closed during command execution"), true},
+		{"密码错误", errors.New("[AUTHENTICATIONFAILED] Invalid credentials"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	authErr := &AuthError{Err: errors.New("Invalid credentials")}
+	if !IsAuthError(fmt.Errorf("IMAP登录失败: %w", authErr)) {
+		t.Error("包装后的 AuthError 应被识别")
+	}
+	if IsAuthError(errors.New("Invalid credentials")) {
+		t.Error("普通错误不应被识别为认证失败")
+	}
+}
+
+func TestIsCredentialRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"认证失败响应码", &loginRejection{Code: "AUTHENTICATIONFAILED", Info: "Invalid credentials"}, true},
+		{"授权失败响应码", &loginRejection{Code: "AUTHORIZATIONFAILED", Info: "Not allowed"}, true},
+		{"不带响应码的 NO", &loginRejection{Info: "LOGIN failed"}, true},
+		{"服务暂不可用", &loginRejection{Code: "UNAVAILABLE", Info: "Backend down"}, false},
+		{"会话被占用", &loginRejection{Code: "INUSE", Info: "Mailbox in use"}, false},
+		{"连接数过多", &loginRejection{Code: "ALERT", Info: "Too many simultaneous connections"}, false},
+		{"登录时服务器断开", errors.New("imap: connection closed during command execution"), false},
+		{"令牌已被吊销", fmt.Errorf("获取访问令牌失败: %w", &oauth2.RetrieveError{ErrorCode: "invalid_grant"}), true},
+		{"令牌端点故障", fmt.Errorf("获取访问令牌失败: %w", &oauth2.RetrieveError{Response: &http.Response{StatusCode: 503}}), false},
+		{"网络错误", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCredentialRejected(tt.err); got != tt.want {
+				t.Errorf("isCredentialRejected() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMailClient_LoginRejected(t *testing.T) {
+	cfg := startTestIMAPServer(t, 0)
+	cfg.Password = "wrong"
+
+	err := NewMailClient(nil).Init(cfg)
+	if !IsAuthError(err) {
+		t.Errorf("密码错误应返回 AuthError: %v", err)
+	}
+}
+
+func TestCheckConnection_Unreachable(t *testing.T) {
+	// 先占用一个端口再释放，确保连接被拒绝
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	result := CheckConnection(Config{
+		Server:       addr.String(),
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     addr.Port,
+		SMTPSecurity: "none",
+	})
+
+	if result.OK {
+		t.Fatal("连接被拒绝时测试不应通过")
+	}
+	var names []string
+	for _, step := range result.Steps {
+		names = append(names, step.Name)
+		if step.OK || step.Error == "" {
+			t.Errorf("步骤 %s 应失败并返回错误: %+v", step.Name, step)
+		}
+	}
+	// 连接失败后不再执行登录和认证
+	if fmt.Sprint(names) != fmt.Sprint([]string{CheckIMAPConnect, CheckSMTPConnect}) {
+		t.Errorf("执行的步骤 = %v", names)
+	}
+}
+
+// startTestIMAPServer 启动使用内存后端的IMAP服务器，收件箱中已有一封 UID 为 6 的已读邮件，
+// 之后按顺序追加 count 封未读邮件（UID 7 起）
+func startTestIMAPServer(t *testing.T, count int) Config {
+	t.Helper()
+
+	be := memory.New()
+	user, err := be.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		body := fmt.Sprintf("From: alice@example.com\r\nTo: router@example.com\r\nSubject: test %d\r\nMessage-ID: <%d@example.com>\r\n\r\nbody\r\n", i, i)
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 借用 httptest 的自签名证书
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(be)
+	srv.AllowInsecureAuth = true
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	return Config{
+		Address:  "router@example.com",
+		Username: "username",
+		Password: "password",
+		Server:   listener.Addr().String(),
+		TLS:      TLSPolicy{Mode: models.TLSModeInsecure},
+	}
+}
+
+func TestStartPushListener_FailureKeepsWatermark(t *testing.T) {
+	cfg := startTestIMAPServer(t, 3)
+	cfg.Folders = []FolderSync{{Folder: DefaultFolder, UIDValidity: 1, LastUID: 6}}
+
+	c := NewMailClient(nil)
+	if err := c.Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		handled []uint32
+		saved   []uint32
+		failed  bool
+	)
+	done := make(chan struct{})
+	go func() {
+		// 模拟调度器：第二封邮件第一次处理失败，成功后按 Progress 保存水位线
+		c.StartPushListener(func(email models.Email) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, email.UID)
+			if email.UID == 8 && !failed {
+				failed = true
+				return errors.New("投递队列不可用")
+			}
+			_, lastUID := c.Progress(email.Folder, email.UID)
+			saved = append(saved, lastUID)
+			if email.UID == 9 {
+				close(done)
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("等待重新处理失败的邮件超时")
+	}
+	c.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []uint32{7, 8, 8, 9}; fmt.Sprint(handled) != fmt.Sprint(want) {
+		t.Errorf("处理顺序 = %v, 期望 %v", handled, want)
+	}
+	// 第二封失败前后保存的水位线都不能越过它
+	if want := []uint32{7, 8, 9}; fmt.Sprint(saved) != fmt.Sprint(want) {
+		t.Errorf("保存的水位线 = %v, 期望 %v", saved, want)
+	}
+}
+
+func TestRewindAndProgress(t *testing.T) {
+	tests := []struct {
+		name         string
+		sync         FolderSync
+		uid          uint32
+		wantProgress uint32
+		wantRewind   uint32
+	}{
+		{"增量同步批次中间", FolderSync{LastUID: 20, batchEnd: 20}, 15, 15, 14},
+		{"增量同步批次末尾", FolderSync{LastUID: 20, batchEnd: 20}, 20, 20, 19},
+		{"首次同步批次中间", FolderSync{LastUID: 100, batchEnd: 90, initial: true}, 80, 0, 0},
+		{"首次同步批次末尾", FolderSync{LastUID: 100, batchEnd: 90, initial: true}, 90, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMailClient(nil)
+			tt.sync.Folder = DefaultFolder
+			c.config.Folders = []FolderSync{tt.sync}
+
+			if _, got := c.Progress(DefaultFolder, tt.uid); got != tt.wantProgress {
+				t.Errorf("Progress() = %d, want %d", got, tt.wantProgress)
+			}
+			c.Rewind(DefaultFolder, tt.uid)
+			if _, got := c.SyncState(DefaultFolder); got != tt.wantRewind {
+				t.Errorf("Rewind() 后水位线 = %d, want %d", got, tt.wantRewind)
+			}
+		})
+	}
+}
+
+func TestFetchNewEmails_FirstSync(t *testing.T) {
+	cfg := startTestIMAPServer(t, 2)
+
+	c := NewMailClient(nil)
+	if err := c.Init(cfg); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer c.Stop()
+
+	emails, err := c.FetchNewEmails(DefaultFolder)
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("首次同步应只获取未读邮件，得到 %d 封", len(emails))
+	}
+	if _, lastUID := c.SyncState(DefaultFolder); lastUID != 8 {
+		t.Errorf("初始水位线 = %d, 期望 8", lastUID)
+	}
+}
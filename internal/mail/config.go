@@ -2,12 +2,38 @@ package mail
 
 // Config 邮件客户端配置
 type Config struct {
-	AccountID uint
-	Provider  string
-	Address   string
-	Username  string
-	Password  string
-	Server    string
-	Settings  string
-	LastUID   uint32
+	AccountID    uint
+	Provider     string
+	Address      string
+	Username     string
+	Password     string
+	Server       string
+	IMAPSecurity string
+	Settings     string
+
+	// Folders 监控的文件夹及其UID水位线，为空时只监控收件箱
+	Folders []FolderSync
+
+	// TLS 证书校验策略，同时用于IMAP和SMTP连接
+	TLS TLSPolicy
+
+	// SMTP 发信配置，用户名和密码为空时使用IMAP的登录凭据
+	SMTPHost     string
+	SMTPPort     int
+	SMTPSecurity string
+	SMTPAuth     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// 转发时的发件人和信封发件人，为空时保留原发件人并使用账户地址作为信封发件人
+	FromMode     string
+	EnvelopeMode string
+
+	// DKIM 不为空时对发出的邮件签名
+	DKIM *DKIMSigner
+	// SRS 按 SRS 改写信封发件人时使用，未配置密钥时为空
+	SRS *SRS
+
+	// TokenSource 返回 OAuth2 访问令牌，设置后IMAP和SMTP使用 XOAUTH2/OAUTHBEARER 认证
+	TokenSource func() (string, error)
 }
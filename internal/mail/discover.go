@@ -0,0 +1,424 @@
+package mail
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	"golang.org/x/net/idna"
+)
+
+// discoverHTTPTimeout 下载自动配置文件的超时时间
+const discoverHTTPTimeout = 10 * time.Second
+
+// maxAutoconfigSize 自动配置文件的大小上限
+const maxAutoconfigSize = 1 << 20
+
+// 服务器配置的来源
+const (
+	DiscoverySourceBuiltin    = "builtin"    // 内置的常见邮箱
+	DiscoverySourceSRV        = "srv"        // RFC 6186 SRV记录
+	DiscoverySourceAutoconfig = "autoconfig" // Mozilla 自动配置文件
+	DiscoverySourceMX         = "mx"         // 根据MX记录识别的托管服务商
+	DiscoverySourceGuess      = "guess"      // 按 imap.<域名> 和 smtp.<域名> 推测
+)
+
+// defaultAutoconfigURLs 自动配置文件的地址，{domain} 和 {email} 会被替换，依次尝试
+var defaultAutoconfigURLs = []string{
+	"https://autoconfig.{domain}/mail/config-v1.1.xml?emailaddress={email}",
+	"https://{domain}/.well-known/autoconfig/mail/config-v1.1.xml?emailaddress={email}",
+	"https://autoconfig.thunderbird.net/v1.1/{domain}",
+}
+
+// knownIMAPServers 常见邮箱的IMAP服务器，SMTP配置由 SuggestSMTP 推测
+var knownIMAPServers = map[string]string{
+	"gmail.com":   "imap.gmail.com:993",
+	"qq.com":      "imap.qq.com:993",
+	"163.com":     "imap.163.com:993",
+	"126.com":     "imap.126.com:993",
+	"outlook.com": "outlook.office365.com:993",
+	"hotmail.com": "outlook.office365.com:993",
+	"yahoo.com":   "imap.mail.yahoo.com:993",
+	"sina.com":    "imap.sina.com:993",
+	"sohu.com":    "imap.sohu.com:993",
+}
+
+// mxProviders 根据MX记录识别的托管邮箱服务商，用于使用自有域名的企业邮箱
+var mxProviders = []struct {
+	suffix   string
+	settings ServerSettings
+}{
+	{"google.com", ServerSettings{IMAPServer: "imap.gmail.com:993", SMTPHost: "smtp.gmail.com", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS}},
+	{"googlemail.com", ServerSettings{IMAPServer: "imap.gmail.com:993", SMTPHost: "smtp.gmail.com", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS}},
+	{"outlook.com", ServerSettings{IMAPServer: "outlook.office365.com:993", SMTPHost: "smtp.office365.com", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS}},
+	{"qq.com", ServerSettings{IMAPServer: "imap.exmail.qq.com:993", SMTPHost: "smtp.exmail.qq.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS}},
+	{"qiye.163.com", ServerSettings{IMAPServer: "imap.qiye.163.com:993", SMTPHost: "smtp.qiye.163.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS}},
+	{"zoho.com", ServerSettings{IMAPServer: "imap.zoho.com:993", SMTPHost: "smtp.zoho.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS}},
+	{"yahoodns.net", ServerSettings{IMAPServer: "imap.mail.yahoo.com:993", SMTPHost: "smtp.mail.yahoo.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS}},
+}
+
+// Resolver 自动发现使用的DNS查询，*net.Resolver 满足该接口
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// ServerSettings 发现的服务器配置，IMAP或SMTP部分为空表示未找到
+type ServerSettings struct {
+	IMAPServer   string // host:port
+	IMAPSecurity string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPSecurity string
+	Username     string // 自动配置文件建议的登录用户名
+	Source       string // 配置的来源，多个来源以 + 连接
+}
+
+// merge 用 other 补全缺少的IMAP或SMTP配置
+func (s *ServerSettings) merge(other ServerSettings) {
+	used := false
+	if s.IMAPServer == "" && other.IMAPServer != "" {
+		s.IMAPServer, s.IMAPSecurity = other.IMAPServer, other.IMAPSecurity
+		used = true
+	}
+	if s.SMTPHost == "" && other.SMTPHost != "" {
+		s.SMTPHost, s.SMTPPort, s.SMTPSecurity = other.SMTPHost, other.SMTPPort, other.SMTPSecurity
+		used = true
+	}
+	if s.Username == "" {
+		s.Username = other.Username
+	}
+	if used {
+		if s.Source != "" {
+			s.Source += "+"
+		}
+		s.Source += other.Source
+	}
+}
+
+// complete 判断IMAP和SMTP配置是否都已找到
+func (s ServerSettings) complete() bool {
+	return s.IMAPServer != "" && s.SMTPHost != ""
+}
+
+// Apply 将发现的配置填入账户中未填写的字段
+func (s ServerSettings) Apply(account *models.MailAccount) {
+	if account.Server == "" {
+		account.Server = s.IMAPServer
+		if account.IMAPSecurity == "" {
+			account.IMAPSecurity = s.IMAPSecurity
+		}
+	}
+	if account.SMTPHost == "" && s.SMTPHost != "" {
+		account.SMTPHost = s.SMTPHost
+		if account.SMTPPort == 0 && account.SMTPSecurity == "" {
+			account.SMTPPort = s.SMTPPort
+			account.SMTPSecurity = s.SMTPSecurity
+		}
+	}
+}
+
+// Discoverer 根据邮箱地址发现IMAP和SMTP服务器
+type Discoverer struct {
+	Resolver       Resolver
+	HTTPClient     *http.Client
+	AutoconfigURLs []string
+}
+
+// NewDiscoverer 创建使用系统DNS和公开自动配置地址的发现器
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{
+		Resolver:       net.DefaultResolver,
+		HTTPClient:     newAutoconfigClient(),
+		AutoconfigURLs: defaultAutoconfigURLs,
+	}
+}
+
+// errPrivateAddress 自动配置地址解析到了内网或本机地址
+var errPrivateAddress = errors.New("自动配置地址指向内网或本机地址")
+
+// newAutoconfigClient 创建下载自动配置文件的HTTP客户端。域名来自调用方提供的邮箱地址，
+// 因此不跟随重定向，不使用代理，并在建立连接前拒绝内网、本机和链路本地地址，避免被用来探测内网服务
+func newAutoconfigClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: discoverHTTPTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   discoverHTTPTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicIP 判断是否为公网单播地址
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// Discover 依次使用内置列表、SRV记录、自动配置文件和MX记录发现服务器配置，
+// 都没有找到时按 imap.<域名> 和 smtp.<域名> 推测
+func (d *Discoverer) Discover(ctx context.Context, email string) (ServerSettings, error) {
+	domain := EmailDomain(email)
+	if domain == "" {
+		return ServerSettings{}, fmt.Errorf("无效的邮箱地址: %s", email)
+	}
+	// 国际化域名转换为 Punycode 后再用于DNS查询和自动配置地址
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return ServerSettings{}, fmt.Errorf("无效的邮箱地址: %s", email)
+	}
+
+	if server, ok := knownIMAPServers[domain]; ok {
+		host, port, security := SuggestSMTP(server)
+		return ServerSettings{
+			IMAPServer:   server,
+			IMAPSecurity: models.IMAPSecurityTLS,
+			SMTPHost:     host,
+			SMTPPort:     port,
+			SMTPSecurity: security,
+			Source:       DiscoverySourceBuiltin,
+		}, nil
+	}
+
+	var result ServerSettings
+	for _, lookup := range []func(context.Context, string, string) ServerSettings{d.lookupSRV, d.fetchAutoconfig, d.lookupMX} {
+		result.merge(lookup(ctx, email, domain))
+		if result.complete() {
+			return result, nil
+		}
+	}
+
+	result.merge(ServerSettings{
+		IMAPServer:   "imap." + domain + ":993",
+		IMAPSecurity: models.IMAPSecurityTLS,
+		SMTPHost:     "smtp." + domain,
+		SMTPPort:     587,
+		SMTPSecurity: models.SMTPSecurityStartTLS,
+		Source:       DiscoverySourceGuess,
+	})
+	return result, nil
+}
+
+// EmailDomain 返回邮箱地址的域名部分，地址无效或域名不是合法主机名（含端口、路径或IP地址）时返回空字符串
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return ""
+	}
+	domain := strings.ToLower(strings.TrimSuffix(email[at+1:], "."))
+	if !validHostname(domain) {
+		return ""
+	}
+	return domain
+}
+
+// validHostname 判断是否为至少包含两级的合法域名，国际化域名按 IDNA 规则校验
+func validHostname(domain string) bool {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil || len(ascii) > 253 || net.ParseIP(ascii) != nil {
+		return false
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupSRV 按 RFC 6186 和 RFC 8314 查询SRV记录，优先使用隐式TLS
+func (d *Discoverer) lookupSRV(ctx context.Context, _, domain string) ServerSettings {
+	var s ServerSettings
+	if host, port, ok := d.srv(ctx, "imaps", domain); ok {
+		s.IMAPServer, s.IMAPSecurity = net.JoinHostPort(host, strconv.Itoa(port)), models.IMAPSecurityTLS
+	} else if host, port, ok := d.srv(ctx, "imap", domain); ok {
+		s.IMAPServer, s.IMAPSecurity = net.JoinHostPort(host, strconv.Itoa(port)), models.IMAPSecurityStartTLS
+	}
+	if host, port, ok := d.srv(ctx, "submissions", domain); ok {
+		s.SMTPHost, s.SMTPPort, s.SMTPSecurity = host, port, models.SMTPSecurityTLS
+	} else if host, port, ok := d.srv(ctx, "submission", domain); ok {
+		s.SMTPHost, s.SMTPPort, s.SMTPSecurity = host, port, models.SMTPSecurityStartTLS
+	}
+	s.Source = DiscoverySourceSRV
+	return s
+}
+
+// srv 查询优先级最高的SRV记录，目标为 "." 表示域名明确不提供该服务
+func (d *Discoverer) srv(ctx context.Context, service, domain string) (string, int, bool) {
+	_, records, err := d.Resolver.LookupSRV(ctx, service, "tcp", domain)
+	if err != nil || len(records) == 0 {
+		return "", 0, false
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	target := strings.TrimSuffix(records[0].Target, ".")
+	if target == "" || records[0].Port == 0 {
+		return "", 0, false
+	}
+	return target, int(records[0].Port), true
+}
+
+// lookupMX 根据MX记录识别托管邮箱服务商
+func (d *Discoverer) lookupMX(ctx context.Context, _, domain string) ServerSettings {
+	records, err := d.Resolver.LookupMX(ctx, domain)
+	if err != nil || len(records) == 0 {
+		return ServerSettings{}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Pref < records[j].Pref
+	})
+
+	host := strings.ToLower(strings.TrimSuffix(records[0].Host, "."))
+	for _, provider := range mxProviders {
+		if host == provider.suffix || strings.HasSuffix(host, "."+provider.suffix) {
+			s := provider.settings
+			s.IMAPSecurity = models.IMAPSecurityTLS
+			s.Source = DiscoverySourceMX
+			return s
+		}
+	}
+	return ServerSettings{}
+}
+
+// autoconfigServer 自动配置文件中的服务器
+type autoconfigServer struct {
+	Type       string `xml:"type,attr"`
+	Hostname   string `xml:"hostname"`
+	Port       int    `xml:"port"`
+	SocketType string `xml:"socketType"`
+	Username   string `xml:"username"`
+}
+
+// autoconfigFile Mozilla 自动配置文件，只解析需要的字段
+type autoconfigFile struct {
+	Incoming []autoconfigServer `xml:"emailProvider>incomingServer"`
+	Outgoing []autoconfigServer `xml:"emailProvider>outgoingServer"`
+}
+
+// fetchAutoconfig 依次尝试下载自动配置文件，使用第一个包含可用服务器的文件
+func (d *Discoverer) fetchAutoconfig(ctx context.Context, email, domain string) ServerSettings {
+	replacer := strings.NewReplacer("{domain}", domain, "{email}", url.QueryEscape(email))
+	for _, tmpl := range d.AutoconfigURLs {
+		file, err := d.getAutoconfig(ctx, replacer.Replace(tmpl))
+		if err != nil {
+			continue
+		}
+		if s := parseAutoconfig(file, email, domain); s.IMAPServer != "" || s.SMTPHost != "" {
+			return s
+		}
+	}
+	return ServerSettings{}
+}
+
+// getAutoconfig 下载并解析自动配置文件
+func (d *Discoverer) getAutoconfig(ctx context.Context, rawURL string) (autoconfigFile, error) {
+	var file autoconfigFile
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return file, err
+	}
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return file, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return file, fmt.Errorf("下载自动配置文件失败: %s", resp.Status)
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, maxAutoconfigSize)).Decode(&file)
+	return file, err
+}
+
+// parseAutoconfig 从自动配置文件中选出支持加密的IMAP和SMTP服务器，展开其中的占位符
+func parseAutoconfig(file autoconfigFile, email, domain string) ServerSettings {
+	localPart := email[:strings.LastIndex(email, "@")]
+	expand := strings.NewReplacer("%EMAILADDRESS%", email, "%EMAILLOCALPART%", localPart, "%EMAILDOMAIN%", domain).Replace
+
+	s := ServerSettings{Source: DiscoverySourceAutoconfig}
+	for _, server := range file.Incoming {
+		if server.Type != "imap" || server.Hostname == "" {
+			continue
+		}
+		// 不使用明文IMAP
+		var security string
+		switch strings.ToUpper(server.SocketType) {
+		case "SSL":
+			security = models.IMAPSecurityTLS
+		case "STARTTLS":
+			security = models.IMAPSecurityStartTLS
+		default:
+			continue
+		}
+		port := server.Port
+		if port == 0 {
+			port = 993
+			if security == models.IMAPSecurityStartTLS {
+				port = 143
+			}
+		}
+		s.IMAPServer = net.JoinHostPort(expand(server.Hostname), strconv.Itoa(port))
+		s.IMAPSecurity = security
+		s.Username = expand(server.Username)
+		break
+	}
+
+	for _, server := range file.Outgoing {
+		if server.Type != "smtp" || server.Hostname == "" {
+			continue
+		}
+		// 不使用明文SMTP，避免自动发现的配置以明文发送凭据
+		var security string
+		switch strings.ToUpper(server.SocketType) {
+		case "SSL":
+			security = models.SMTPSecurityTLS
+		case "STARTTLS":
+			security = models.SMTPSecurityStartTLS
+		default:
+			continue
+		}
+		port := server.Port
+		if port == 0 {
+			port = DefaultSMTPPort(security)
+		}
+		s.SMTPHost, s.SMTPPort, s.SMTPSecurity = expand(server.Hostname), port, security
+		break
+	}
+
+	return s
+}
@@ -0,0 +1,251 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mail-dispatcher/internal/models"
+)
+
+// fakeResolver 用于离线测试的DNS记录，键为 "_service._proto.name" 或域名
+type fakeResolver struct {
+	srv map[string][]*net.SRV
+	mx  map[string][]*net.MX
+}
+
+func (r fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv["_"+service+"._"+proto+"."+name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return "", records, nil
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	records, ok := r.mx[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+const testAutoconfig = `<?xml version="1.0" encoding="UTF-8"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.org">
+    <incomingServer type="pop3">
+      <hostname>pop.example.org</hostname>
+      <port>995</port>
+      <socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>mail.%EMAILDOMAIN%</hostname>
+      <port>143</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>mail.%EMAILDOMAIN%</hostname>
+      <port>465</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILADDRESS%</username>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+// testPlainAutoconfig 只提供明文SMTP的自动配置文件
+const testPlainAutoconfig = `<?xml version="1.0" encoding="UTF-8"?>
+<clientConfig version="1.1">
+  <emailProvider id="plain.example">
+    <incomingServer type="imap">
+      <hostname>imap.plain.example</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>relay.plain.example</hostname>
+      <port>25</port>
+      <socketType>plain</socketType>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+func TestDiscoverer_Discover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.org":
+			w.Write([]byte(testAutoconfig))
+		case "/plain.example":
+			w.Write([]byte(testPlainAutoconfig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	resolver := fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_imaps._tcp.srv.example":      {{Target: "imap2.srv.example.", Port: 993, Priority: 10}, {Target: "imap1.srv.example.", Port: 993, Priority: 0}},
+			"_submission._tcp.srv.example": {{Target: "smtp.srv.example.", Port: 587}},
+			"_imap._tcp.partial.example":   {{Target: "mail.partial.example.", Port: 143}},
+			"_imaps._tcp.disabled.example": {{Target: ".", Port: 0}},
+			"_submissions._tcp.mx.example": {{Target: "relay.mx.example.", Port: 465}},
+		},
+		mx: map[string][]*net.MX{
+			"mx.example":       {{Host: "alt1.aspmx.l.google.com.", Pref: 5}, {Host: "aspmx.l.google.com.", Pref: 1}},
+			"disabled.example": {{Host: "mx.disabled.example.", Pref: 10}},
+		},
+	}
+	discoverer := &Discoverer{
+		Resolver:       resolver,
+		HTTPClient:     server.Client(),
+		AutoconfigURLs: []string{server.URL + "/{domain}?emailaddress={email}"},
+	}
+
+	tests := []struct {
+		name  string
+		email string
+		want  ServerSettings
+	}{
+		{
+			name:  "内置邮箱",
+			email: "user@QQ.com",
+			want:  ServerSettings{IMAPServer: "imap.qq.com:993", IMAPSecurity: models.IMAPSecurityTLS, SMTPHost: "smtp.qq.com", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS, Source: DiscoverySourceBuiltin},
+		},
+		{
+			name:  "SRV记录按优先级选择",
+			email: "user@srv.example",
+			want:  ServerSettings{IMAPServer: "imap1.srv.example:993", IMAPSecurity: models.IMAPSecurityTLS, SMTPHost: "smtp.srv.example", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS, Source: DiscoverySourceSRV},
+		},
+		{
+			name:  "自动配置文件展开占位符",
+			email: "alice@example.org",
+			want:  ServerSettings{IMAPServer: "mail.example.org:143", IMAPSecurity: models.IMAPSecurityStartTLS, SMTPHost: "mail.example.org", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS, Username: "alice", Source: DiscoverySourceAutoconfig},
+		},
+		{
+			name:  "自动配置文件中的明文SMTP由推测补全",
+			email: "user@plain.example",
+			want:  ServerSettings{IMAPServer: "imap.plain.example:993", IMAPSecurity: models.IMAPSecurityTLS, SMTPHost: "smtp.plain.example", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS, Source: "autoconfig+guess"},
+		},
+		{
+			name:  "SRV缺少的SMTP配置由推测补全",
+			email: "user@partial.example",
+			want:  ServerSettings{IMAPServer: "mail.partial.example:143", IMAPSecurity: models.IMAPSecurityStartTLS, SMTPHost: "smtp.partial.example", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS, Source: "srv+guess"},
+		},
+		{
+			name:  "SRV的SMTP与MX识别的IMAP合并",
+			email: "user@mx.example",
+			want:  ServerSettings{IMAPServer: "imap.gmail.com:993", IMAPSecurity: models.IMAPSecurityTLS, SMTPHost: "relay.mx.example", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS, Source: "srv+mx"},
+		},
+		{
+			name:  "SRV目标为点时忽略",
+			email: "user@disabled.example",
+			want:  ServerSettings{IMAPServer: "imap.disabled.example:993", IMAPSecurity: models.IMAPSecurityTLS, SMTPHost: "smtp.disabled.example", SMTPPort: 587, SMTPSecurity: models.SMTPSecurityStartTLS, Source: DiscoverySourceGuess},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discoverer.Discover(context.Background(), tt.email)
+			if err != nil {
+				t.Fatalf("Discover() 返回错误: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Discover() = %+v, 期望 %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, email := range []string{"invalid", "x@10.0.0.5", "x@internal.svc:8443", "x@internal.svc/path#", "x@[127.0.0.1]", "x@localhost"} {
+		if _, err := discoverer.Discover(context.Background(), email); err == nil {
+			t.Errorf("无效的邮箱地址 %s 应返回错误", email)
+		}
+	}
+}
+
+func TestEmailDomain(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"普通域名", "User@Example.COM", "example.com"},
+		{"末尾带点", "user@example.com.", "example.com"},
+		{"国际化域名", "user@例子.中国", "例子.中国"},
+		{"IPv4地址", "user@192.168.1.1", ""},
+		{"IPv6地址", "user@[::1]", ""},
+		{"带端口", "user@example.com:8080", ""},
+		{"带路径", "user@example.com/admin?", ""},
+		{"单级域名", "user@localhost", ""},
+		{"空标签", "user@example..com", ""},
+		{"缺少域名", "user@", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EmailDomain(tt.email); got != tt.want {
+				t.Errorf("EmailDomain(%q) = %q, 期望 %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDiscoverer_RejectsPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testAutoconfig))
+	}))
+	defer server.Close()
+
+	if _, err := NewDiscoverer().getAutoconfig(context.Background(), server.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("下载本机地址上的自动配置文件应被拒绝: %v", err)
+	}
+}
+
+func TestServerSettings_Apply(t *testing.T) {
+	settings := ServerSettings{
+		IMAPServer:   "mail.example.org:143",
+		IMAPSecurity: models.IMAPSecurityStartTLS,
+		SMTPHost:     "mail.example.org",
+		SMTPPort:     465,
+		SMTPSecurity: models.SMTPSecurityTLS,
+	}
+
+	tests := []struct {
+		name    string
+		account models.MailAccount
+		want    models.MailAccount
+	}{
+		{
+			name:    "全部补全",
+			account: models.MailAccount{},
+			want:    models.MailAccount{Server: "mail.example.org:143", IMAPSecurity: models.IMAPSecurityStartTLS, SMTPHost: "mail.example.org", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS},
+		},
+		{
+			name:    "已填写的字段保持不变",
+			account: models.MailAccount{Server: "imap.example.org:993", SMTPHost: "relay.example.org", SMTPPort: 25},
+			want:    models.MailAccount{Server: "imap.example.org:993", SMTPHost: "relay.example.org", SMTPPort: 25},
+		},
+		{
+			name:    "填写了SMTP端口时不覆盖",
+			account: models.MailAccount{SMTPPort: 2525},
+			want:    models.MailAccount{Server: "mail.example.org:143", IMAPSecurity: models.IMAPSecurityStartTLS, SMTPHost: "mail.example.org", SMTPPort: 2525},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := tt.account
+			settings.Apply(&account)
+			if account.Server != tt.want.Server || account.IMAPSecurity != tt.want.IMAPSecurity ||
+				account.SMTPHost != tt.want.SMTPHost || account.SMTPPort != tt.want.SMTPPort ||
+				account.SMTPSecurity != tt.want.SMTPSecurity {
+				t.Errorf("Apply() = %s %s %s:%d %s, 期望 %s %s %s:%d %s",
+					account.Server, account.IMAPSecurity, account.SMTPHost, account.SMTPPort, account.SMTPSecurity,
+					tt.want.Server, tt.want.IMAPSecurity, tt.want.SMTPHost, tt.want.SMTPPort, tt.want.SMTPSecurity)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimRSABits 生成RSA密钥的长度
+const dkimRSABits = 2048
+
+// dkimHeaderKeys 参与签名的邮件头，按 RFC 6376 5.4.1 的建议选取，
+// 转发时添加的 Resent-* 头也包含在内
+var dkimHeaderKeys = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"Resent-Date", "Resent-From", "Resent-To", "Resent-Message-ID",
+}
+
+// DKIMSigner 发信时使用的DKIM签名配置
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Signer   crypto.Signer
+}
+
+// sign 为邮件添加 DKIM-Signature 头
+func (s *DKIMSigner) sign(message []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := dkim.Sign(&buf, bytes.NewReader(message), &dkim.SignOptions{
+		Domain:                 s.Domain,
+		Selector:               s.Selector,
+		Signer:                 s.Signer,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             dkimHeaderKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DKIM签名失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateDKIMKey 生成DKIM私钥，返回 PKCS#8 PEM 格式
+func GenerateDKIMKey(algorithm string) (string, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case models.DKIMAlgorithmRSA:
+		key, err = rsa.GenerateKey(rand.Reader, dkimRSABits)
+	case models.DKIMAlgorithmEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("不支持的DKIM算法: %s", algorithm)
+	}
+	if err != nil {
+		return "", fmt.Errorf("生成DKIM密钥失败: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("编码DKIM密钥失败: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseDKIMKey 解析 PEM 格式的私钥，支持 PKCS#8 和 PKCS#1，返回私钥和对应的算法
+func ParseDKIMKey(privateKey string) (crypto.Signer, string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, "", errors.New("私钥不是有效的PEM格式")
+	}
+
+	var key interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("解析私钥失败: %v", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return nil, "", errors.New("RSA私钥长度不能小于1024位")
+		}
+		return k, models.DKIMAlgorithmRSA, nil
+	case ed25519.PrivateKey:
+		return k, models.DKIMAlgorithmEd25519, nil
+	}
+	return nil, "", errors.New("DKIM只支持RSA和Ed25519私钥")
+}
+
+// DKIMRecord 返回需要发布在 <selector>._domainkey.<domain> 的 TXT 记录
+func DKIMRecord(signer crypto.Signer) (string, error) {
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		// RFC 8463 使用原始的32字节公钥
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	}
+	return "", errors.New("DKIM只支持RSA和Ed25519私钥")
+}
@@ -0,0 +1,239 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// DefaultFolder 未配置监控文件夹时只监控收件箱
+const DefaultFolder = "INBOX"
+
+// 文件夹所属的命名空间（RFC 2342）
+const (
+	NamespacePersonal = "personal" // 当前用户的文件夹，包括 Gmail 标签
+	NamespaceOther    = "other"    // 其他用户共享给当前用户的文件夹
+	NamespaceShared   = "shared"   // 公共文件夹
+)
+
+// FolderSync 监控文件夹的UID水位线
+type FolderSync struct {
+	Folder      string
+	UIDValidity uint32
+	LastUID     uint32
+
+	initial  bool   // 最近一次获取是否为首次同步
+	batchEnd uint32 // 最近一次获取到的最大UID
+}
+
+// Folder 服务器上的文件夹
+type Folder struct {
+	Name       string
+	Delimiter  string
+	Attributes []string
+	Namespace  string
+	Selectable bool
+}
+
+// SameFolder 判断两个文件夹名称是否相同，INBOX 不区分大小写（RFC 3501 5.1），空名称表示收件箱
+func SameFolder(a, b string) bool {
+	if a == "" {
+		a = DefaultFolder
+	}
+	if b == "" {
+		b = DefaultFolder
+	}
+	if strings.EqualFold(a, DefaultFolder) && strings.EqualFold(b, DefaultFolder) {
+		return true
+	}
+	return a == b
+}
+
+// ListFolders 列出账户的所有文件夹，服务器支持 NAMESPACE 时同时列出共享和公共文件夹
+func (c *MailClient) ListFolders() ([]Folder, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, fmt.Errorf("确保连接失败: %w", err)
+	}
+	return listFolders(c.client)
+}
+
+// listFolders 列出已登录连接中的所有文件夹
+func listFolders(imapClient *client.Client) ([]Folder, error) {
+	folders, err := listPattern(imapClient, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := lookupNamespaces(imapClient)
+	if err != nil {
+		// 命名空间只用于补充共享文件夹，查询失败时仍返回个人文件夹
+		return folders, nil
+	}
+
+	seen := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		seen[folder.Name] = true
+	}
+	// 部分服务器的 LIST "" "*" 不包含其他命名空间，按前缀单独列出
+	for _, prefix := range append(append([]string{}, ns.other...), ns.shared...) {
+		if prefix == "" {
+			continue
+		}
+		extra, err := listPattern(imapClient, prefix+"*")
+		if err != nil {
+			continue
+		}
+		for _, folder := range extra {
+			if !seen[folder.Name] {
+				seen[folder.Name] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+
+	for i := range folders {
+		folders[i].Namespace = ns.classify(folders[i].Name)
+	}
+	sort.SliceStable(folders, func(i, j int) bool {
+		return namespaceOrder(folders[i].Namespace) < namespaceOrder(folders[j].Namespace)
+	})
+	return folders, nil
+}
+
+// listPattern 执行 LIST 命令
+func listPattern(imapClient *client.Client, pattern string) ([]Folder, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.List("", pattern, mailboxes)
+	}()
+
+	var folders []Folder
+	for mailbox := range mailboxes {
+		folder := Folder{
+			Name:       mailbox.Name,
+			Delimiter:  mailbox.Delimiter,
+			Attributes: mailbox.Attributes,
+			Namespace:  NamespacePersonal,
+			Selectable: true,
+		}
+		for _, attr := range mailbox.Attributes {
+			if strings.EqualFold(attr, imap.NoSelectAttr) || strings.EqualFold(attr, `\NonExistent`) {
+				folder.Selectable = false
+			}
+		}
+		folders = append(folders, folder)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("列出文件夹失败: %v", err)
+	}
+	return folders, nil
+}
+
+// namespaceOrder 个人文件夹排在共享文件夹之前
+func namespaceOrder(namespace string) int {
+	switch namespace {
+	case NamespaceOther:
+		return 1
+	case NamespaceShared:
+		return 2
+	}
+	return 0
+}
+
+// namespaces 其他用户和公共命名空间的前缀
+type namespaces struct {
+	other  []string
+	shared []string
+}
+
+// classify 按最长前缀判断文件夹所属的命名空间
+func (n namespaces) classify(name string) string {
+	namespace, longest := NamespacePersonal, 0
+	for _, prefix := range n.other {
+		if prefix != "" && strings.HasPrefix(name, prefix) && len(prefix) > longest {
+			namespace, longest = NamespaceOther, len(prefix)
+		}
+	}
+	for _, prefix := range n.shared {
+		if prefix != "" && strings.HasPrefix(name, prefix) && len(prefix) > longest {
+			namespace, longest = NamespaceShared, len(prefix)
+		}
+	}
+	return namespace
+}
+
+// lookupNamespaces 查询服务器的命名空间，不支持 NAMESPACE 扩展时返回空
+func lookupNamespaces(imapClient *client.Client) (namespaces, error) {
+	supported, err := imapClient.Support("NAMESPACE")
+	if err != nil || !supported {
+		return namespaces{}, err
+	}
+
+	handler := &namespaceResponse{}
+	status, err := imapClient.Execute(namespaceCommand{}, handler)
+	if err != nil {
+		return namespaces{}, err
+	}
+	if err := status.Err(); err != nil {
+		return namespaces{}, err
+	}
+	return handler.namespaces, nil
+}
+
+// namespaceCommand NAMESPACE 命令（RFC 2342）
+type namespaceCommand struct{}
+
+func (namespaceCommand) Command() *imap.Command {
+	return &imap.Command{Name: "NAMESPACE"}
+}
+
+// namespaceResponse 解析 NAMESPACE 响应，三个字段依次为个人、其他用户和公共命名空间
+type namespaceResponse struct {
+	namespaces namespaces
+}
+
+func (r *namespaceResponse) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "NAMESPACE" {
+		return responses.ErrUnhandled
+	}
+	if len(fields) < 3 {
+		return errors.New("NAMESPACE 响应格式错误")
+	}
+	r.namespaces.other = namespacePrefixes(fields[1])
+	r.namespaces.shared = namespacePrefixes(fields[2])
+	return nil
+}
+
+// namespacePrefixes 解析命名空间列表中的前缀，NIL 表示没有该类命名空间
+func namespacePrefixes(field interface{}) []string {
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var prefixes []string
+	for _, item := range list {
+		desc, ok := item.([]interface{})
+		if !ok || len(desc) == 0 {
+			continue
+		}
+		prefix, err := imap.ParseString(desc[0])
+		if err != nil {
+			continue
+		}
+		// 前缀与文件夹名称一样使用 modified UTF-7 编码
+		if decoded, err := utf7.Encoding.NewDecoder().String(prefix); err == nil {
+			prefix = decoded
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestSameFolder(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"收件箱不区分大小写", "INBOX", "Inbox", true},
+		{"空名称表示收件箱", "", "INBOX", true},
+		{"其他文件夹区分大小写", "Alerts", "alerts", false},
+		{"相同的共享文件夹", "Shared/ops", "Shared/ops", true},
+		{"不同的文件夹", "INBOX", "INBOX/Alerts", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameFolder(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameFolder(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespacePrefixes(t *testing.T) {
+	tests := []struct {
+		name  string
+		field interface{}
+		want  []string
+	}{
+		{"NIL", nil, nil},
+		{"单个命名空间", []interface{}{[]interface{}{"Other Users/", "/"}}, []string{"Other Users/"}},
+		{"多个命名空间", []interface{}{[]interface{}{"#shared/", "/"}, []interface{}{"Public/", "/"}}, []string{"#shared/", "Public/"}},
+		{"modified UTF-7 解码", []interface{}{[]interface{}{"&UXFOqw-/", "/"}}, []string{"共享/"}},
+		{"跳过格式错误的项", []interface{}{"Public/", []interface{}{}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := namespacePrefixes(tt.field); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("namespacePrefixes() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceResponse(t *testing.T) {
+	resp := &imap.DataResp{Fields: []interface{}{
+		"NAMESPACE",
+		[]interface{}{[]interface{}{"", "/"}},
+		[]interface{}{[]interface{}{"Other Users/", "/"}},
+		nil,
+	}}
+
+	handler := &namespaceResponse{}
+	if err := handler.Handle(resp); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if !reflect.DeepEqual(handler.namespaces.other, []string{"Other Users/"}) || handler.namespaces.shared != nil {
+		t.Errorf("namespaces = %#v", handler.namespaces)
+	}
+}
+
+func TestNamespacesClassify(t *testing.T) {
+	ns := namespaces{other: []string{"Other Users/"}, shared: []string{"Shared/", "Other Users/public/"}}
+
+	tests := []struct {
+		name   string
+		folder string
+		want   string
+	}{
+		{"收件箱", "INBOX", NamespacePersonal},
+		{"Gmail 标签", "[Gmail]/Alerts", NamespacePersonal},
+		{"其他用户的文件夹", "Other Users/bob/INBOX", NamespaceOther},
+		{"公共文件夹", "Shared/ops", NamespaceShared},
+		{"按最长前缀判断", "Other Users/public/news", NamespaceShared},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ns.classify(tt.folder); got != tt.want {
+				t.Errorf("classify(%q) = %q, want %q", tt.folder, got, tt.want)
+			}
+		})
+	}
+}
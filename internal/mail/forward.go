@@ -0,0 +1,306 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	gotextproto "github.com/emersion/go-message/textproto"
+)
+
+// forwardInfo 转发时写入邮件头的信息
+type forwardInfo struct {
+	From string    // 转发账户的地址
+	To   string    // 转发目标地址
+	Hops int       // 本次转发后的转发次数
+	Date time.Time // 转发时间
+
+	// Rewrite 将发件人改为转发账户，原发件人写入 Reply-To
+	Rewrite bool
+}
+
+// OriginalFromHeader 改写发件人时保存原 From 头的字段
+const OriginalFromHeader = "X-Original-From"
+
+// traceHeaders 所有转发方式都会写入的追踪头
+func (f forwardInfo) traceHeaders(header *gotextproto.Header) {
+	header.Set(HopsHeader, strconv.Itoa(f.Hops))
+	header.Set(ForwardedByHeader, ForwardedByValue)
+}
+
+// buildRedirect 按 RFC 5322 3.6.6 重新发送邮件：原邮件头和正文保持不变，
+// 在顶部添加一组 Resent-* 头，多次转发时新的一组位于已有的之前
+func buildRedirect(raw []byte, info forwardInfo) ([]byte, error) {
+	header, body, err := splitMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Rewrite {
+		rewriteFrom(&header, info.From)
+	}
+	info.traceHeaders(&header)
+	// Add 添加的字段写在最前面，按相反的顺序添加以得到 Date、From、To、Message-ID 的顺序
+	header.Add("Resent-Message-ID", newMessageID(info.From))
+	header.Add("Resent-To", info.To)
+	header.Add("Resent-From", info.From)
+	header.Add("Resent-Date", info.Date.Format(time.RFC1123Z))
+
+	var buf bytes.Buffer
+	if err := gotextproto.WriteHeader(&buf, header); err != nil {
+		return nil, fmt.Errorf("写入邮件头失败: %v", err)
+	}
+	buf.Write(body)
+
+	return buf.Bytes(), nil
+}
+
+// rewriteFrom 将发件人改为转发账户，显示名称保留原发件人，
+// 收件人回复时仍然发给原发件人
+func rewriteFrom(header *gotextproto.Header, account string) {
+	original := header.Get("From")
+	if original == "" {
+		header.Set("From", account)
+		return
+	}
+
+	name := original
+	if addr, err := netmail.ParseAddress(original); err == nil {
+		name = addr.Address
+		if addr.Name != "" {
+			name = addr.Name
+		}
+	}
+
+	if !header.Has("Reply-To") {
+		header.Set("Reply-To", original)
+	}
+	header.Set(OriginalFromHeader, original)
+	header.Set("From", (&netmail.Address{Name: name + " via " + account, Address: account}).String())
+}
+
+// buildWrapped 将原邮件作为 message/rfc822 附件转发，原文逐字节保留
+func buildWrapped(email models.Email, info forwardInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	if err := writeTextPart(mw, "转发的邮件见附件。\r\n\r\n"+quotedHeaderBlock(email)); err != nil {
+		return nil, err
+	}
+
+	partHeader := textproto.MIMEHeader{
+		"Content-Type":        {"message/rfc822"},
+		"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": "forwarded.eml"})},
+	}
+	// message/rfc822 只允许 7bit、8bit 和 binary 编码
+	if !is7bit(email.RawData) {
+		partHeader.Set("Content-Transfer-Encoding", "8bit")
+	}
+	part, err := mw.CreatePart(partHeader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(email.RawData); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header := newForwardHeader(email, info, mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+
+	var out bytes.Buffer
+	if err := gotextproto.WriteHeader(&out, header); err != nil {
+		return nil, fmt.Errorf("写入邮件头失败: %v", err)
+	}
+	out.Write(buf.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// buildInline 以正文引用的方式转发：正文前附上原邮件的头信息，原邮件的附件原样附上
+func buildInline(email models.Email, info forwardInfo) ([]byte, error) {
+	var attachments []*mimeNode
+	if len(email.RawData) > 0 {
+		header, body, err := splitMessage(email.RawData)
+		if err != nil {
+			return nil, err
+		}
+		root, err := parseMIMENode(textproto.MIMEHeader{
+			"Content-Type":              header.Values("Content-Type"),
+			"Content-Transfer-Encoding": header.Values("Content-Transfer-Encoding"),
+			"Content-Disposition":       header.Values("Content-Disposition"),
+		}, body)
+		if err != nil {
+			return nil, err
+		}
+		collectAttachments(root, &attachments)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	quoted := quotedHeaderBlock(email)
+	if email.HTMLBody == "" {
+		if err := writeTextPart(mw, quoted+email.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		// 纯文本和HTML正文放在 multipart/alternative 中
+		var alt bytes.Buffer
+		aw := multipart.NewWriter(&alt)
+		if err := writeTextPart(aw, quoted+email.Body); err != nil {
+			return nil, err
+		}
+		htmlQuoted := "<p>" + strings.ReplaceAll(html.EscapeString(strings.TrimRight(quoted, "\r\n")), "\r\n", "<br>\r\n") + "</p>\r\n"
+		if err := writeQPPart(aw, "text/html; charset=utf-8", htmlQuoted+email.HTMLBody); err != nil {
+			return nil, err
+		}
+		if err := aw.Close(); err != nil {
+			return nil, err
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": aw.Boundary()})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(alt.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, node := range attachments {
+		part, err := mw.CreatePart(node.header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(node.raw); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header := newForwardHeader(email, info, mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+
+	var out bytes.Buffer
+	if err := gotextproto.WriteHeader(&out, header); err != nil {
+		return nil, fmt.Errorf("写入邮件头失败: %v", err)
+	}
+	out.Write(buf.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// newForwardHeader 构建由转发账户发出的新邮件的邮件头，
+// 引用原邮件的 Message-ID 以便邮件客户端关联会话
+func newForwardHeader(email models.Email, info forwardInfo, contentType string) gotextproto.Header {
+	// Set 添加的字段写在最前面，按相反的顺序设置
+	var header gotextproto.Header
+	header.Set("Content-Type", contentType)
+	header.Set("MIME-Version", "1.0")
+	info.traceHeaders(&header)
+	if info.Rewrite && email.From != "" {
+		header.Set("Reply-To", (&netmail.Address{Name: email.FromName, Address: email.From}).String())
+	}
+	if email.MessageID != "" {
+		header.Set("References", "<"+email.MessageID+">")
+	}
+	header.Set("Message-ID", newMessageID(info.From))
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", forwardSubject(email.Subject)))
+	header.Set("To", info.To)
+	header.Set("From", info.From)
+	header.Set("Date", info.Date.Format(time.RFC1123Z))
+	return header
+}
+
+// forwardSubject 为主题加上 Fwd: 前缀，已有前缀时不重复添加
+func forwardSubject(subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), "fwd:") {
+		return subject
+	}
+	return "Fwd: " + subject
+}
+
+// quotedHeaderBlock 原邮件的头信息，放在转发正文之前
+func quotedHeaderBlock(email models.Email) string {
+	from := email.From
+	if email.FromName != "" {
+		from = fmt.Sprintf("%s <%s>", email.FromName, email.From)
+	}
+
+	var b strings.Builder
+	b.WriteString("---------- 转发的邮件 ----------\r\n")
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	if date := firstHeader(email.Headers, "Date"); date != "" {
+		fmt.Fprintf(&b, "Date: %s\r\n", date)
+	}
+	fmt.Fprintf(&b, "Subject: %s\r\n", email.Subject)
+	to := firstHeader(email.Headers, "To")
+	if to == "" {
+		to = email.To
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	if cc := firstHeader(email.Headers, "Cc"); cc != "" {
+		fmt.Fprintf(&b, "Cc: %s\r\n", cc)
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// writeTextPart 写入UTF-8纯文本分段
+func writeTextPart(mw *multipart.Writer, text string) error {
+	return writeQPPart(mw, "text/plain; charset=utf-8", text)
+}
+
+// writeQPPart 写入使用 quoted-printable 编码的文本分段
+func writeQPPart(mw *multipart.Writer, contentType, text string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID 生成新的 Message-ID，域名取自发件地址
+func newMessageID(from string) string {
+	domain := "mail-dispatcher.local"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+// is7bit 判断内容是否只包含7位ASCII字符
+func is7bit(data []byte) bool {
+	for _, c := range data {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
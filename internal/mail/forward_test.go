@@ -0,0 +1,269 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	gomail "github.com/emersion/go-message/mail"
+)
+
+var testForwardInfo = forwardInfo{
+	From: "router@example.com",
+	To:   "target@example.com",
+	Hops: 1,
+	Date: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC),
+}
+
+// headerNames 按顺序返回邮件头的字段名
+func headerNames(t *testing.T, raw []byte) []string {
+	t.Helper()
+	header, _, err := splitMessage(raw)
+	if err != nil {
+		t.Fatalf("splitMessage() error = %v", err)
+	}
+	var names []string
+	fields := header.Fields()
+	for fields.Next() {
+		names = append(names, fields.Key())
+	}
+	return names
+}
+
+func TestBuildRedirect(t *testing.T) {
+	forwarded, err := buildRedirect([]byte(multipartMessage), testForwardInfo)
+	if err != nil {
+		t.Fatalf("buildRedirect() error = %v", err)
+	}
+
+	_, originalBody, _ := splitMessage([]byte(multipartMessage))
+	header, body, err := splitMessage(forwarded)
+	if err != nil {
+		t.Fatalf("splitMessage() error = %v", err)
+	}
+
+	if !bytes.Equal(body, originalBody) {
+		t.Error("转发后的正文应与原文逐字节一致")
+	}
+	if header.Get("From") != "alice@example.com" || header.Get("To") != "router@example.com" {
+		t.Errorf("原邮件头不应被改写: From = %q, To = %q", header.Get("From"), header.Get("To"))
+	}
+	if header.Get("Resent-To") != "target@example.com" || header.Get("Resent-From") != "router@example.com" {
+		t.Errorf("Resent-To = %q, Resent-From = %q", header.Get("Resent-To"), header.Get("Resent-From"))
+	}
+	if header.Get("Resent-Date") != "Mon, 12 Oct 2026 10:00:00 +0000" {
+		t.Errorf("Resent-Date = %q", header.Get("Resent-Date"))
+	}
+	if id := header.Get("Resent-Message-Id"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Resent-Message-ID = %q", id)
+	}
+	if !strings.Contains(header.Get("Content-Type"), "boundary=\"b1\"") {
+		t.Errorf("Content-Type 丢失了 boundary: %q", header.Get("Content-Type"))
+	}
+
+	names := headerNames(t, forwarded)
+	want := []string{"Resent-Date", "Resent-From", "Resent-To", "Resent-Message-Id"}
+	for i, name := range want {
+		if names[i] != name {
+			t.Fatalf("邮件头顺序 = %v, 期望以 %v 开头", names, want)
+		}
+	}
+}
+
+func TestBuildRedirect_PrependsResentBlock(t *testing.T) {
+	first, err := buildRedirect([]byte(multipartMessage), testForwardInfo)
+	if err != nil {
+		t.Fatalf("buildRedirect() error = %v", err)
+	}
+	second := testForwardInfo
+	second.From, second.To, second.Hops = "target@example.com", "final@example.com", 2
+	forwarded, err := buildRedirect(first, second)
+	if err != nil {
+		t.Fatalf("buildRedirect() error = %v", err)
+	}
+
+	var email models.Email
+	if err := parseBody(&email, forwarded); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+	if got := email.Headers["Resent-To"]; len(got) != 2 || got[0] != "final@example.com" || got[1] != "target@example.com" {
+		t.Errorf("Resent-To = %v, 新的一组应位于之前", got)
+	}
+	if got := email.Headers[HopsHeader]; len(got) != 1 || got[0] != "2" {
+		t.Errorf("%s = %v, 期望只有一个值 2", HopsHeader, got)
+	}
+	if got := ForwardedAddresses(email); len(got) != 4 {
+		t.Errorf("ForwardedAddresses() = %v, 期望包含两次转发的地址", got)
+	}
+}
+
+func TestBuildWrapped(t *testing.T) {
+	var email models.Email
+	email.RawData = []byte(multipartMessage)
+	email.MessageID = "abc@example.com"
+	if err := parseBody(&email, email.RawData); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	forwarded, err := buildWrapped(email, testForwardInfo)
+	if err != nil {
+		t.Fatalf("buildWrapped() error = %v", err)
+	}
+
+	mr, err := gomail.CreateReader(bytes.NewReader(forwarded))
+	if err != nil {
+		t.Fatalf("CreateReader() error = %v", err)
+	}
+	if from := mr.Header.Get("From"); from != "router@example.com" {
+		t.Errorf("From = %q", from)
+	}
+	if subject, _ := mr.Header.Subject(); subject != "Fwd: 报警 - 张三" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if refs := mr.Header.Get("References"); refs != "<abc@example.com>" {
+		t.Errorf("References = %q", refs)
+	}
+
+	var attached []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart() error = %v", err)
+		}
+		if contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); contentType == "message/rfc822" {
+			attached, _ = io.ReadAll(part.Body)
+		}
+	}
+	if !bytes.Equal(attached, []byte(multipartMessage)) {
+		t.Error("附件应与原邮件逐字节一致")
+	}
+}
+
+func TestBuildInline(t *testing.T) {
+	raw := "From: =?UTF-8?B?5byg5LiJ?= <zhangsan@example.com>\r\n" +
+		"To: router@example.com\r\n" +
+		"Date: Mon, 12 Oct 2026 09:00:00 +0800\r\n" +
+		"Subject: Fwd: report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"见附件\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQK\r\n" +
+		"--b1--\r\n"
+
+	var email models.Email
+	email.RawData = []byte(raw)
+	if err := parseBody(&email, email.RawData); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	forwarded, err := buildInline(email, testForwardInfo)
+	if err != nil {
+		t.Fatalf("buildInline() error = %v", err)
+	}
+
+	var parsed models.Email
+	if err := parseBody(&parsed, forwarded); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+	if parsed.Subject != "Fwd: report" {
+		t.Errorf("Subject = %q, 已有前缀时不应重复添加", parsed.Subject)
+	}
+	for _, want := range []string{"From: 张三 <zhangsan@example.com>", "Date: Mon, 12 Oct 2026 09:00:00 +0800", "To: router@example.com", "见附件"} {
+		if !strings.Contains(parsed.Body, want) {
+			t.Errorf("正文缺少 %q: %q", want, parsed.Body)
+		}
+	}
+	if len(parsed.Attachments) != 1 || parsed.Attachments[0] != "report.pdf" {
+		t.Errorf("Attachments = %v, 期望保留 report.pdf", parsed.Attachments)
+	}
+	if parsed.Headers[HopsHeader][0] != "1" || parsed.Headers[ForwardedByHeader][0] != ForwardedByValue {
+		t.Errorf("缺少转发追踪头: %v", parsed.Headers)
+	}
+}
+
+func TestRewriteFrom(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		wantFrom    string
+		wantReplyTo string
+	}{
+		{
+			name:        "只有地址",
+			message:     multipartMessage,
+			wantFrom:    `"alice@example.com via router@example.com" <router@example.com>`,
+			wantReplyTo: "alice@example.com",
+		},
+		{
+			name:        "带显示名称",
+			message:     "From: Alice <alice@example.com>\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantFrom:    `"Alice via router@example.com" <router@example.com>`,
+			wantReplyTo: "Alice <alice@example.com>",
+		},
+		{
+			name:        "保留已有的 Reply-To",
+			message:     "From: alice@example.com\r\nReply-To: list@example.com\r\n\r\nbody\r\n",
+			wantFrom:    `"alice@example.com via router@example.com" <router@example.com>`,
+			wantReplyTo: "list@example.com",
+		},
+	}
+
+	info := testForwardInfo
+	info.Rewrite = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded, err := buildRedirect([]byte(tt.message), info)
+			if err != nil {
+				t.Fatalf("buildRedirect() error = %v", err)
+			}
+			header, _, _ := splitMessage(forwarded)
+			if got := header.Get("From"); got != tt.wantFrom {
+				t.Errorf("From = %q, want %q", got, tt.wantFrom)
+			}
+			if got := header.Get("Reply-To"); got != tt.wantReplyTo {
+				t.Errorf("Reply-To = %q, want %q", got, tt.wantReplyTo)
+			}
+			if header.Get(OriginalFromHeader) == "" {
+				t.Errorf("缺少 %s 头", OriginalFromHeader)
+			}
+			if header.Get("Resent-From") != "router@example.com" {
+				t.Errorf("Resent-From = %q", header.Get("Resent-From"))
+			}
+		})
+	}
+}
+
+func TestBuildWrapped_RewriteSetsReplyTo(t *testing.T) {
+	info := testForwardInfo
+	info.Rewrite = true
+	email := models.Email{From: "alice@example.com", FromName: "Alice", Subject: "hi", RawData: []byte(multipartMessage)}
+
+	forwarded, err := buildWrapped(email, info)
+	if err != nil {
+		t.Fatalf("buildWrapped() error = %v", err)
+	}
+	header, _, _ := splitMessage(forwarded)
+	if got := header.Get("Reply-To"); got != `"Alice" <alice@example.com>` {
+		t.Errorf("Reply-To = %q", got)
+	}
+	if got := header.Get("From"); got != "router@example.com" {
+		t.Errorf("From = %q", got)
+	}
+}
@@ -0,0 +1,165 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	netmail "net/mail"
+	nettextproto "net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
+	gomail "github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+func init() {
+	// 让 go-imap 解析 envelope 时也能识别非UTF-8字符集
+	imap.CharsetReader = charset.Reader
+}
+
+// headerDecoder 解码 RFC 2047 编码字，支持 GBK、Big5 等非UTF-8字符集
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// fullBodySection 完整邮件原文，使用 PEEK 避免隐式设置 \Seen 标记
+var fullBodySection = &imap.BodySectionName{Peek: true}
+
+// parseBody 从邮件原文中解析文本和HTML正文
+func parseBody(email *models.Email, raw []byte) error {
+	mr, err := gomail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return err
+	}
+	defer mr.Close()
+
+	parseHeader(email, mr.Header)
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+
+		header, ok := part.Header.(*gomail.InlineHeader)
+		if !ok {
+			if attachment, ok := part.Header.(*gomail.AttachmentHeader); ok {
+				filename, _ := attachment.Filename()
+				email.Attachments = append(email.Attachments, filename)
+			}
+			continue
+		}
+
+		contentType, _, _ := header.ContentType()
+		switch contentType {
+		case "text/plain":
+			if email.Body != "" {
+				continue
+			}
+			body, err := io.ReadAll(part.Body)
+			if err != nil {
+				return fmt.Errorf("读取文本正文失败: %v", err)
+			}
+			email.Body = string(body)
+		case "text/html":
+			if email.HTMLBody != "" {
+				continue
+			}
+			body, err := io.ReadAll(part.Body)
+			if err != nil {
+				return fmt.Errorf("读取HTML正文失败: %v", err)
+			}
+			email.HTMLBody = string(body)
+		}
+	}
+
+	return nil
+}
+
+// parseHeader 解码主题和地址头，同时保留原始值，解码失败时沿用 envelope 中的数据
+func parseHeader(email *models.Email, header gomail.Header) {
+	email.Headers = make(map[string][]string, header.Len())
+	fields := header.Fields()
+	for fields.Next() {
+		key := nettextproto.CanonicalMIMEHeaderKey(fields.Key())
+		email.Headers[key] = append(email.Headers[key], decodeHeaderValue(fields.Value()))
+	}
+
+	if raw := header.Get("Subject"); raw != "" {
+		email.RawSubject = raw
+		email.Subject = decodeHeaderValue(raw)
+	}
+	if raw := header.Get("From"); raw != "" {
+		email.RawFrom = raw
+		if addr := parseAddress(raw); addr != nil {
+			email.From = addr.Address
+			email.FromName = addr.Name
+		}
+	}
+	if raw := header.Get("To"); raw != "" {
+		if addr := parseAddress(raw); addr != nil {
+			email.To = addr.Address
+		}
+	}
+}
+
+// decodeHeaderValue 解码邮件头中的编码字，兼容未编码直接写入的 GBK 等字节
+func decodeHeaderValue(raw string) string {
+	value := toUTF8(raw)
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parseAddress 解析地址列表中的第一个地址，显示名称中的编码字会被解码
+func parseAddress(raw string) *netmail.Address {
+	parser := &netmail.AddressParser{WordDecoder: headerDecoder}
+	list, err := parser.ParseList(toUTF8(raw))
+	if err != nil || len(list) == 0 {
+		return nil
+	}
+	return list[0]
+}
+
+// toUTF8 将非法的UTF-8字节按 GB18030 解码，部分国内邮件客户端会直接写入原始字节
+func toUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	r, err := charset.Reader("gb18030", strings.NewReader(s))
+	if err != nil {
+		return s
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil || !utf8.Valid(decoded) {
+		return s
+	}
+	return string(decoded)
+}
+
+// splitMessage 拆分邮件原文为邮件头和未经改动的正文字节
+func splitMessage(raw []byte) (textproto.Header, []byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return textproto.Header{}, nil, fmt.Errorf("解析邮件头失败: %v", err)
+	}
+
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return textproto.Header{}, nil, fmt.Errorf("读取邮件正文失败: %v", err)
+	}
+
+	return header, body, nil
+}
@@ -0,0 +1,88 @@
+package mail
+
+import (
+	"testing"
+
+	"mail-dispatcher/internal/models"
+)
+
+const multipartMessage = "From: alice@example.com\r\n" +
+	"To: router@example.com\r\n" +
+	"Subject: =?UTF-8?B?5oql6K2mIC0g5byg5LiJ?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=gbk\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"xOO6ww==\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>=E4=BD=A0=E5=A5=BD</p>\r\n" +
+	"--b1--\r\n"
+
+func TestParseBody(t *testing.T) {
+	var email models.Email
+	if err := parseBody(&email, []byte(multipartMessage)); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	if email.Body != "你好" {
+		t.Errorf("Body = %q, want %q", email.Body, "你好")
+	}
+	if email.HTMLBody != "<p>你好</p>" {
+		t.Errorf("HTMLBody = %q, want %q", email.HTMLBody, "<p>你好</p>")
+	}
+	if email.From != "alice@example.com" {
+		t.Errorf("From = %q, want %q", email.From, "alice@example.com")
+	}
+}
+
+func TestDecodeHeaderValue(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"GBK", "=?GBK?B?sai+ryAtINXFyP0=?=", "报警 - 张三"},
+		{"GB2312", "=?gb2312?B?1MvOrNfp?=", "运维组"},
+		{"Big5", "=?big5?B?s/jEtSAtILFppFQ=?=", "報警 - 張三"},
+		{"ISO-2022-JP", "=?ISO-2022-JP?B?GyRCN1k5cBsoQiAtIBskQkVEQ2YbKEI=?=", "警告 - 田中"},
+		{"未编码的GBK字节", "\xc0\xee\xcb\xc4", "李四"},
+		{"纯ASCII", "Alert - Ops", "Alert - Ops"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeHeaderValue(tt.raw); got != tt.want {
+				t.Errorf("decodeHeaderValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBody_DecodesHeaders(t *testing.T) {
+	raw := "From: =?GBK?B?1cXI/Q==?= <zhangsan@qq.com>\r\n" +
+		"To: router@example.com\r\n" +
+		"Subject: =?GBK?B?sai+ryAtINXFyP0=?=\r\n" +
+		"\r\n" +
+		"body\r\n"
+
+	var email models.Email
+	if err := parseBody(&email, []byte(raw)); err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	if email.Subject != "报警 - 张三" {
+		t.Errorf("Subject = %q", email.Subject)
+	}
+	if email.RawSubject != "=?GBK?B?sai+ryAtINXFyP0=?=" {
+		t.Errorf("RawSubject = %q", email.RawSubject)
+	}
+	if email.From != "zhangsan@qq.com" || email.FromName != "张三" {
+		t.Errorf("From = %q, FromName = %q", email.From, email.FromName)
+	}
+}
@@ -0,0 +1,213 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+)
+
+// xoauth2Mechanism Google 和 Microsoft 使用的 XOAUTH2 SASL 机制
+const xoauth2Mechanism = "XOAUTH2"
+
+// login 登录IMAP服务器，只有服务器拒绝凭据或提供方吊销授权时返回 AuthError，
+// 服务器临时不可用、连接数过多等错误可以重试，不计入认证失败
+func (c *MailClient) login(imapClient *client.Client) error {
+	err := c.authenticate(imapClient)
+	if err != nil && !IsAuthError(err) && isCredentialRejected(err) {
+		return &AuthError{Err: err}
+	}
+	return err
+}
+
+// authenticate 使用密码认证，配置了访问令牌来源时使用 OAuth 认证
+func (c *MailClient) authenticate(imapClient *client.Client) error {
+	if c.config.TokenSource == nil {
+		return execLogin(imapClient, &commands.Login{Username: c.config.Username, Password: c.config.Password}, nil)
+	}
+
+	token, err := c.config.TokenSource()
+	if err != nil {
+		return fmt.Errorf("获取访问令牌失败: %w", err)
+	}
+
+	// 优先使用标准的 OAUTHBEARER，Outlook 等只支持 XOAUTH2 的服务器退回 XOAUTH2
+	if ok, _ := imapClient.SupportAuth(sasl.OAuthBearer); ok {
+		return authenticateSASL(imapClient, sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: c.config.Username,
+			Token:    token,
+		}))
+	}
+	return authenticateSASL(imapClient, newXOAuth2Client(c.config.Username, token))
+}
+
+// authenticateSASL 执行 AUTHENTICATE 命令，服务器支持 SASL-IR 时随命令发送初始响应
+func authenticateSASL(imapClient *client.Client, auth sasl.Client) error {
+	mech, ir, err := auth.Start()
+	if err != nil {
+		return err
+	}
+
+	cmd := &commands.Authenticate{Mechanism: mech}
+	res := &responses.Authenticate{
+		Mechanism:       auth,
+		InitialResponse: ir,
+		RepliesCh:       make(chan []byte, 10),
+	}
+	if ok, err := imapClient.Support("SASL-IR"); err != nil {
+		return err
+	} else if ok {
+		cmd.InitialResponse = ir
+		res.InitialResponse = nil
+	}
+	return execLogin(imapClient, cmd, res)
+}
+
+// execLogin 执行 LOGIN 或 AUTHENTICATE 命令，与 go-imap 的 Login/Authenticate 相同，
+// 但服务器拒绝时返回带响应码的 loginRejection，go-imap 只保留响应文本
+func execLogin(imapClient *client.Client, cmd imap.Commander, h responses.Handler) error {
+	status, err := imapClient.Execute(cmd, h)
+	if err != nil {
+		return err
+	}
+	if status != nil && status.Type == imap.StatusRespNo {
+		return &loginRejection{Code: string(status.Code), Info: status.Info}
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+
+	imapClient.SetState(imap.AuthenticatedState, nil)
+	// 登录后服务器的能力会变化，重新查询
+	_, err = imapClient.Capability()
+	return err
+}
+
+// loginRejection 服务器以 NO 拒绝登录
+type loginRejection struct {
+	Code string
+	Info string
+}
+
+func (e *loginRejection) Error() string {
+	if e.Code == "" {
+		return e.Info
+	}
+	return "[" + e.Code + "] " + e.Info
+}
+
+// transientLoginCodes 表示服务器临时故障的响应码（RFC 5530），不代表凭据错误
+var transientLoginCodes = map[string]bool{
+	"UNAVAILABLE": true,
+	"INUSE":       true,
+	"SERVERBUG":   true,
+	"LIMIT":       true,
+}
+
+// isCredentialRejected 判断错误是否表示凭据被拒绝：服务器以认证失败响应码或不带临时故障响应码的 NO 拒绝登录，
+// 或者令牌端点返回 invalid_grant（刷新令牌已失效或被吊销）
+func isCredentialRejected(err error) bool {
+	if isConnectionError(err) {
+		return false
+	}
+
+	var rejection *loginRejection
+	if errors.As(err, &rejection) {
+		switch {
+		case rejection.Code == "AUTHENTICATIONFAILED" || rejection.Code == "AUTHORIZATIONFAILED":
+			return true
+		case transientLoginCodes[rejection.Code]:
+			return false
+		}
+		// 部分服务器连接数过多时不返回响应码，只在文本中说明
+		return !strings.Contains(strings.ToLower(rejection.Info), "too many")
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
+
+// xoauth2Error 服务器在认证失败时返回的JSON
+type xoauth2Error struct {
+	Status  string `json:"status"`
+	Schemes string `json:"schemes"`
+	Scope   string `json:"scope"`
+}
+
+// xoauth2Client 实现 XOAUTH2 SASL 客户端
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+// newXOAuth2Client 创建 XOAUTH2 SASL 客户端
+func newXOAuth2Client(username, token string) sasl.Client {
+	return &xoauth2Client{username: username, token: token}
+}
+
+// Start 发送包含用户名和访问令牌的初始响应
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	ir := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return xoauth2Mechanism, []byte(ir), nil
+}
+
+// Next 认证失败时服务器会返回错误详情，解析后作为错误返回
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	var authErr xoauth2Error
+	if err := json.Unmarshal(challenge, &authErr); err != nil {
+		return nil, fmt.Errorf("XOAUTH2 认证失败: %s", challenge)
+	}
+	return nil, fmt.Errorf("XOAUTH2 认证失败 (%s)", authErr.Status)
+}
+
+// saslAuth 将 SASL 客户端适配为 net/smtp 的认证接口
+type saslAuth struct {
+	client sasl.Client
+	host   string
+}
+
+// Start 开始认证，令牌等同于密码，同样拒绝在未加密的连接上发送
+func (a *saslAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("未加密的连接")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("服务器名称不匹配")
+	}
+	return a.client.Start()
+}
+
+// Next 处理服务器的质询
+func (a *saslAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
+
+// smtpOAuth 根据服务器支持的机制创建 OAuth 认证器
+func (c *MailClient) smtpOAuth(smtpClient *smtp.Client, host, username string) (smtp.Auth, error) {
+	token, err := c.config.TokenSource()
+	if err != nil {
+		return nil, fmt.Errorf("获取访问令牌失败: %v", err)
+	}
+
+	_, mechanisms := smtpClient.Extension("AUTH")
+	for _, mechanism := range strings.Fields(strings.ToUpper(mechanisms)) {
+		if mechanism == sasl.OAuthBearer {
+			return &saslAuth{
+				client: sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{Username: username, Token: token}),
+				host:   host,
+			}, nil
+		}
+	}
+	return &saslAuth{client: newXOAuth2Client(username, token), host: host}, nil
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestXOAuth2Client(t *testing.T) {
+	client := newXOAuth2Client("user@example.com", "ya29.token")
+
+	mech, ir, err := client.Start()
+	if err != nil || mech != "XOAUTH2" {
+		t.Fatalf("Start() = %s, %v", mech, err)
+	}
+	if want := "user=user@example.com\x01auth=Bearer ya29.token\x01\x01"; string(ir) != want {
+		t.Errorf("初始响应错误: %q, 期望 %q", ir, want)
+	}
+
+	// 认证失败时服务器返回JSON格式的错误详情
+	_, err = client.Next([]byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`))
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("应返回包含状态码的错误: %v", err)
+	}
+}
+
+func TestSASLAuth(t *testing.T) {
+	auth := &saslAuth{client: newXOAuth2Client("user@example.com", "token"), host: "smtp.example.com"}
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: false}); err == nil {
+		t.Error("未加密的连接应该拒绝认证")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.other.com", TLS: true}); err == nil {
+		t.Error("服务器名称不匹配时应该拒绝认证")
+	}
+
+	proto, ir, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+	if err != nil || proto != "XOAUTH2" || !strings.Contains(string(ir), "auth=Bearer token") {
+		t.Fatalf("Start() = %s, %q, %v", proto, ir, err)
+	}
+	if resp, err := auth.Next(nil, false); resp != nil || err != nil {
+		t.Errorf("认证完成后不应再响应: %q, %v", resp, err)
+	}
+}
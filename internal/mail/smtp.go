@@ -0,0 +1,277 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/models"
+)
+
+// smtpDialTimeout 连接SMTP服务器的超时时间
+const smtpDialTimeout = 30 * time.Second
+
+// smtpSendTimeout 发送一封邮件的整个SMTP会话（连接、认证和传输数据）的超时时间，
+// 避免服务器停止响应时一直占用投递任务
+var smtpSendTimeout = 5 * time.Minute
+
+// SuggestSMTP 根据IMAP服务器推测SMTP配置，只用于创建账户时填充默认值
+func SuggestSMTP(imapServer string) (host string, port int, security string) {
+	server := strings.ToLower(strings.TrimSpace(imapServer))
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		server = h
+	}
+
+	switch {
+	case strings.Contains(server, "qq.com"):
+		return "smtp.qq.com", 587, models.SMTPSecurityStartTLS
+	case strings.Contains(server, "gmail.com"):
+		return "smtp.gmail.com", 587, models.SMTPSecurityStartTLS
+	case strings.Contains(server, "163.com"):
+		return "smtp.163.com", 465, models.SMTPSecurityTLS
+	case strings.Contains(server, "126.com"):
+		return "smtp.126.com", 465, models.SMTPSecurityTLS
+	case strings.Contains(server, "office365.com"):
+		return "smtp.office365.com", 587, models.SMTPSecurityStartTLS
+	}
+
+	// 默认使用IMAP服务器对应的SMTP服务器
+	return strings.Replace(server, "imap.", "smtp.", 1), 587, models.SMTPSecurityStartTLS
+}
+
+// DefaultSMTPPort 返回安全模式对应的常用端口
+func DefaultSMTPPort(security string) int {
+	switch security {
+	case models.SMTPSecurityTLS:
+		return 465
+	case models.SMTPSecurityNone:
+		return 25
+	}
+	return 587
+}
+
+// ApplySMTPDefaults 补全账户中未填写的SMTP配置，已填写的字段保持不变
+func ApplySMTPDefaults(account *models.MailAccount) {
+	if account.SMTPHost == "" {
+		host, port, security := SuggestSMTP(account.Server)
+		account.SMTPHost = host
+		if account.SMTPPort == 0 && account.SMTPSecurity == "" {
+			account.SMTPPort = port
+			account.SMTPSecurity = security
+		}
+	}
+
+	if account.SMTPSecurity == "" {
+		switch account.SMTPPort {
+		case 465:
+			account.SMTPSecurity = models.SMTPSecurityTLS
+		case 25:
+			account.SMTPSecurity = models.SMTPSecurityNone
+		default:
+			account.SMTPSecurity = models.SMTPSecurityStartTLS
+		}
+	}
+	if account.SMTPPort == 0 {
+		account.SMTPPort = DefaultSMTPPort(account.SMTPSecurity)
+	}
+
+	if account.SMTPAuth == "" {
+		if account.SMTPSecurity == models.SMTPSecurityNone {
+			account.SMTPAuth = models.SMTPAuthNone
+		} else {
+			account.SMTPAuth = models.SMTPAuthPlain
+		}
+	}
+}
+
+// sendMail 按账户的SMTP配置发送邮件，from 为信封发件人，为空表示退信地址为空
+func (c *MailClient) sendMail(from, toEmail string, body []byte) error {
+	client, host, err := c.connectSMTP(time.Now().Add(smtpSendTimeout))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = c.authSMTP(client, host); err != nil {
+		return err
+	}
+
+	// 发送邮件
+	if err = client.Mail(from); err != nil {
+		return fmt.Errorf("设置发件人失败: %w", err)
+	}
+
+	if err = client.Rcpt(toEmail); err != nil {
+		return fmt.Errorf("设置收件人失败: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("开始发送邮件数据失败: %w", err)
+	}
+
+	if _, err = w.Write(body); err != nil {
+		return fmt.Errorf("写入邮件数据失败: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("完成发送邮件失败: %w", err)
+	}
+
+	// 邮件已被服务器接受，QUIT 失败不影响结果
+	client.Quit()
+	return nil
+}
+
+// connectSMTP 连接SMTP服务器并完成EHLO和TLS协商，返回客户端和用于认证的主机名
+// deadline 为零值时不限制连接的读写时间
+func (c *MailClient) connectSMTP(deadline time.Time) (*smtp.Client, string, error) {
+	host := c.config.SMTPHost
+	if host == "" {
+		return nil, "", fmt.Errorf("账户未配置SMTP服务器")
+	}
+
+	security := c.config.SMTPSecurity
+	if security == "" {
+		security = models.SMTPSecurityStartTLS
+	}
+	port := c.config.SMTPPort
+	if port == 0 {
+		port = DefaultSMTPPort(security)
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	tlsConfig, err := c.config.TLS.Config(host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 连接到SMTP服务器
+	var conn net.Conn
+	if security == models.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		if isHandshakeError(err) {
+			return nil, "", &TLSError{Server: addr, Err: err}
+		}
+		return nil, "", fmt.Errorf("连接SMTP服务器失败: %w", err)
+	}
+	conn.SetDeadline(deadline)
+
+	// 创建SMTP客户端
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("创建SMTP客户端失败: %w", err)
+	}
+
+	// 发送EHLO，启用STARTTLS时服务器不支持则不降级为明文
+	if err = client.Hello("localhost"); err != nil {
+		client.Close()
+		return nil, "", fmt.Errorf("SMTP握手失败: %w", err)
+	}
+	hasStartTLS, _ := client.Extension("STARTTLS")
+	if security == models.SMTPSecurityStartTLS {
+		if !hasStartTLS {
+			client.Close()
+			return nil, "", fmt.Errorf("SMTP服务器不支持STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			if isHandshakeError(err) {
+				return nil, "", &TLSError{Server: addr, Err: err}
+			}
+			return nil, "", fmt.Errorf("启用STARTTLS失败: %w", err)
+		}
+	}
+
+	return client, host, nil
+}
+
+// authSMTP 按账户配置的认证方式登录SMTP服务器
+func (c *MailClient) authSMTP(client *smtp.Client, host string) error {
+	auth, err := c.smtpAuth(client, host)
+	if err != nil {
+		return fmt.Errorf("SMTP认证失败: %w", err)
+	}
+	if auth != nil {
+		if err = client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP认证失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// smtpAuth 根据配置的认证方式创建认证器，不需要认证时返回 nil
+// 使用 OAuth2 的账户未单独配置SMTP密码时，SMTP同样使用访问令牌认证
+func (c *MailClient) smtpAuth(client *smtp.Client, host string) (smtp.Auth, error) {
+	username := c.config.SMTPUsername
+	password := c.config.SMTPPassword
+	if username == "" {
+		username = c.config.Username
+	}
+
+	switch {
+	case c.config.SMTPAuth == models.SMTPAuthNone:
+		return nil, nil
+	case password == "" && c.config.TokenSource != nil:
+		return c.smtpOAuth(client, host, username)
+	case password == "":
+		password = c.config.Password
+	}
+
+	switch c.config.SMTPAuth {
+	case models.SMTPAuthLogin:
+		return &loginAuth{username: username, password: password, host: host}, nil
+	case models.SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(username, password), nil
+	}
+	return smtp.PlainAuth("", username, password, host), nil
+}
+
+// loginAuth 实现 AUTH LOGIN，部分国内邮箱和旧版 Exchange 只支持这种方式
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// Start 开始认证，与 PlainAuth 一样拒绝在未加密的连接上发送密码
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("未加密的连接")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("服务器名称不匹配")
+	}
+	return "LOGIN", nil, nil
+}
+
+// Next 按服务器提示依次返回用户名和密码
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("未知的LOGIN认证提示: %s", fromServer)
+}
+
+// isLocalhost 判断是否为本机地址
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,214 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestSuggestSMTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		server       string
+		wantHost     string
+		wantPort     int
+		wantSecurity string
+	}{
+		{"QQ邮箱", "imap.qq.com:993", "smtp.qq.com", 587, models.SMTPSecurityStartTLS},
+		{"163邮箱", "imap.163.com:993", "smtp.163.com", 465, models.SMTPSecurityTLS},
+		{"Outlook", "outlook.office365.com:993", "smtp.office365.com", 587, models.SMTPSecurityStartTLS},
+		{"自建邮箱去掉端口", "imap.example.com:993", "smtp.example.com", 587, models.SMTPSecurityStartTLS},
+		{"不带端口", "imap.example.com", "smtp.example.com", 587, models.SMTPSecurityStartTLS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, security := SuggestSMTP(tt.server)
+			if host != tt.wantHost || port != tt.wantPort || security != tt.wantSecurity {
+				t.Errorf("SuggestSMTP() = %s, %d, %s, 期望 %s, %d, %s",
+					host, port, security, tt.wantHost, tt.wantPort, tt.wantSecurity)
+			}
+		})
+	}
+}
+
+func TestApplySMTPDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		account models.MailAccount
+		want    models.MailAccount
+	}{
+		{
+			name:    "全部推测",
+			account: models.MailAccount{Server: "imap.163.com:993"},
+			want:    models.MailAccount{SMTPHost: "smtp.163.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS, SMTPAuth: models.SMTPAuthPlain},
+		},
+		{
+			name:    "按端口推断安全模式",
+			account: models.MailAccount{SMTPHost: "relay.example.com", SMTPPort: 25},
+			want:    models.MailAccount{SMTPHost: "relay.example.com", SMTPPort: 25, SMTPSecurity: models.SMTPSecurityNone, SMTPAuth: models.SMTPAuthNone},
+		},
+		{
+			name:    "按安全模式推断端口",
+			account: models.MailAccount{SMTPHost: "smtp.example.com", SMTPSecurity: models.SMTPSecurityTLS, SMTPAuth: models.SMTPAuthLogin},
+			want:    models.MailAccount{SMTPHost: "smtp.example.com", SMTPPort: 465, SMTPSecurity: models.SMTPSecurityTLS, SMTPAuth: models.SMTPAuthLogin},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.account
+			ApplySMTPDefaults(&got)
+			if got.SMTPHost != tt.want.SMTPHost || got.SMTPPort != tt.want.SMTPPort ||
+				got.SMTPSecurity != tt.want.SMTPSecurity || got.SMTPAuth != tt.want.SMTPAuth {
+				t.Errorf("ApplySMTPDefaults() = %s:%d %s/%s, 期望 %s:%d %s/%s",
+					got.SMTPHost, got.SMTPPort, got.SMTPSecurity, got.SMTPAuth,
+					tt.want.SMTPHost, tt.want.SMTPPort, tt.want.SMTPSecurity, tt.want.SMTPAuth)
+			}
+		})
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	auth := &loginAuth{username: "user@example.com", password: "secret", host: "smtp.example.com"}
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: false}); err == nil {
+		t.Error("未加密的连接应该拒绝认证")
+	}
+
+	proto, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+	if err != nil || proto != "LOGIN" {
+		t.Fatalf("Start() = %s, %v", proto, err)
+	}
+
+	if resp, _ := auth.Next([]byte("Username:"), true); string(resp) != "user@example.com" {
+		t.Errorf("用户名响应错误: %s", resp)
+	}
+	if resp, _ := auth.Next([]byte("Password:"), true); string(resp) != "secret" {
+		t.Errorf("密码响应错误: %s", resp)
+	}
+}
+
+// startTestSMTPServer 启动只接收一封邮件的明文SMTP服务器，收到的邮件数据写入返回的通道
+func startTestSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO":
+				tp.PrintfLine("250 localhost")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestNewSender_SkipsIMAP(t *testing.T) {
+	addr, received := startTestSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	smtpPort, _ := strconv.Atoi(port)
+
+	// IMAP服务器不可达，发送不应受影响
+	sender := NewSender(nil, Config{
+		Address:      "router@example.com",
+		Server:       "127.0.0.1:1",
+		SMTPHost:     host,
+		SMTPPort:     smtpPort,
+		SMTPSecurity: models.SMTPSecurityNone,
+		SMTPAuth:     models.SMTPAuthNone,
+	})
+	raw := []byte("From: alice@example.com\r\nTo: router@example.com\r\nSubject: test\r\nMessage-ID: <1@example.com>\r\n\r\nbody\r\n")
+	if err := sender.SendRawEmail(raw, "target@example.com"); err != nil {
+		t.Fatalf("SendRawEmail() error = %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "Subject: test") {
+			t.Errorf("收到的邮件缺少原主题: %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待SMTP服务器收到邮件超时")
+	}
+	if err := sender.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+}
+
+func TestSendMail_Timeout(t *testing.T) {
+	// 服务器发送欢迎消息后不再响应
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		time.Sleep(5 * time.Second)
+	}()
+
+	defer func(timeout time.Duration) { smtpSendTimeout = timeout }(smtpSendTimeout)
+	smtpSendTimeout = 200 * time.Millisecond
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	smtpPort, _ := strconv.Atoi(port)
+	sender := NewSender(nil, Config{
+		SMTPHost:     host,
+		SMTPPort:     smtpPort,
+		SMTPSecurity: models.SMTPSecurityNone,
+		SMTPAuth:     models.SMTPAuthNone,
+	})
+
+	start := time.Now()
+	if err := sender.sendMail("router@example.com", "target@example.com", []byte("Subject: test\r\n\r\nbody\r\n")); err == nil {
+		t.Fatal("服务器不响应时应返回错误")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("发送超时未生效，耗时 %v", elapsed)
+	}
+}
@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// srsHashLength SRS地址中哈希的长度，与 libsrs2 默认值一致
+const srsHashLength = 4
+
+// srsTimeBase32 SRS时间戳使用的 base32 字母表
+const srsTimeBase32 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// SRS 按 Sender Rewriting Scheme 改写信封发件人，
+// 使转发的邮件以本域名通过SPF校验，退信仍能还原出原发件人
+type SRS struct {
+	Secret []byte
+	Domain string // 改写后地址使用的域名，通常为转发账户的域名
+}
+
+// Forward 改写信封发件人：普通地址改写为 SRS0，已经是 SRS 地址时改写为 SRS1，
+// 空发件人（退信）保持为空
+func (s *SRS) Forward(sender string, now time.Time) (string, error) {
+	if sender == "" {
+		return "", nil
+	}
+	at := strings.LastIndex(sender, "@")
+	if at <= 0 || at == len(sender)-1 {
+		return "", errors.New("无效的信封发件人: " + sender)
+	}
+	local, domain := sender[:at], sender[at+1:]
+
+	// 同一域名无需改写
+	if strings.EqualFold(domain, s.Domain) {
+		return sender, nil
+	}
+
+	upper := strings.ToUpper(local)
+	switch {
+	case strings.HasPrefix(upper, "SRS0=") || strings.HasPrefix(upper, "SRS0+") || strings.HasPrefix(upper, "SRS0-"):
+		// 上一跳转发者的域名作为 SRS1 中的第一跳
+		rest := local[4:]
+		return "SRS1=" + s.hash(domain, rest) + "=" + domain + "=" + rest + "@" + s.Domain, nil
+	case strings.HasPrefix(upper, "SRS1=") || strings.HasPrefix(upper, "SRS1+") || strings.HasPrefix(upper, "SRS1-"):
+		// 保留第一跳，只重新计算哈希
+		parts := strings.SplitN(local[5:], "=", 3)
+		if len(parts) == 3 {
+			return "SRS1=" + s.hash(parts[1], parts[2]) + "=" + parts[1] + "=" + parts[2] + "@" + s.Domain, nil
+		}
+	}
+
+	timestamp := srsTimestamp(now)
+	return "SRS0=" + s.hash(timestamp, domain, local) + "=" + timestamp + "=" + domain + "=" + local + "@" + s.Domain, nil
+}
+
+// hash 计算各字段的 HMAC-SHA1，不区分大小写
+func (s *SRS) hash(fields ...string) string {
+	mac := hmac.New(sha1.New, s.Secret)
+	for _, field := range fields {
+		mac.Write([]byte(strings.ToLower(field)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:srsHashLength]
+}
+
+// srsTimestamp 以天为单位的时间戳，取模1024后编码为两个 base32 字符
+func srsTimestamp(now time.Time) string {
+	days := now.Unix() / 86400 % 1024
+	return string([]byte{srsTimeBase32[days>>5], srsTimeBase32[days&31]})
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSRSForward(t *testing.T) {
+	srs := &SRS{Secret: []byte("secret"), Domain: "router.example"}
+	now := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		sender     string
+		wantPrefix string
+		wantSuffix string
+	}{
+		{name: "普通地址", sender: "alice@example.com", wantPrefix: "SRS0=", wantSuffix: "=example.com=alice@router.example"},
+		{name: "已改写为 SRS0", sender: "SRS0=abcd=AB=example.com=alice@relay.example", wantPrefix: "SRS1=", wantSuffix: "=relay.example==abcd=AB=example.com=alice@router.example"},
+		{name: "已改写为 SRS1", sender: "SRS1=wxyz=first.example==abcd=AB=example.com=alice@relay.example", wantPrefix: "SRS1=", wantSuffix: "=first.example==abcd=AB=example.com=alice@router.example"},
+		{name: "本域名地址", sender: "bob@router.example", wantPrefix: "bob@router.example", wantSuffix: "bob@router.example"},
+		{name: "空发件人", sender: "", wantPrefix: "", wantSuffix: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := srs.Forward(tt.sender, now)
+			if err != nil {
+				t.Fatalf("Forward() error = %v", err)
+			}
+			if !strings.HasPrefix(got, tt.wantPrefix) || !strings.HasSuffix(got, tt.wantSuffix) {
+				t.Errorf("Forward(%q) = %q", tt.sender, got)
+			}
+		})
+	}
+}
+
+func TestSRSForward_Format(t *testing.T) {
+	srs := &SRS{Secret: []byte("secret"), Domain: "router.example"}
+	now := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+
+	first, err := srs.Forward("Alice@Example.com", now)
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	parts := strings.Split(strings.TrimSuffix(first, "@router.example"), "=")
+	if len(parts) != 5 || len(parts[1]) != srsHashLength || len(parts[2]) != 2 {
+		t.Fatalf("SRS0 地址格式错误: %q", first)
+	}
+
+	// 相同输入得到相同地址，哈希不区分大小写
+	second, _ := srs.Forward("alice@example.com", now)
+	if parts2 := strings.Split(second, "="); parts2[1] != parts[1] {
+		t.Errorf("哈希应不区分大小写: %q, %q", first, second)
+	}
+
+	// 时间戳每天变化
+	next, _ := srs.Forward("alice@example.com", now.Add(24*time.Hour))
+	if strings.Split(next, "=")[2] == parts[2] {
+		t.Errorf("时间戳应按天变化: %q, %q", first, next)
+	}
+
+	if _, err := srs.Forward("invalid", now); err == nil {
+		t.Error("无效地址应返回错误")
+	}
+}
@@ -0,0 +1,201 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// imapDialTimeout 连接IMAP服务器并完成握手的超时时间
+const imapDialTimeout = 30 * time.Second
+
+// errFingerprintMismatch 服务器证书与固定的指纹不一致
+var errFingerprintMismatch = errors.New("证书指纹不匹配")
+
+// TLSError TLS握手失败，通常是证书不受信任、主机名不匹配或指纹不一致，需要检查账户的TLS配置
+type TLSError struct {
+	Server string
+	Err    error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("TLS握手失败 (%s): %v", e.Server, e.Err)
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// IsTLSError 判断错误是否由TLS握手失败引起
+func IsTLSError(err error) bool {
+	var tlsErr *TLSError
+	return errors.As(err, &tlsErr)
+}
+
+// TLSPolicy 账户的证书校验策略
+type TLSPolicy struct {
+	Mode         string // verify、pin 或 insecure，为空时等同于 verify
+	CACert       string // PEM格式的CA证书，为空时使用系统CA
+	Fingerprints string // 证书的SHA-256指纹，多个以逗号分隔
+}
+
+// Config 根据策略创建连接 serverName 使用的TLS配置
+func (p TLSPolicy) Config(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName}
+
+	switch p.Mode {
+	case "", models.TLSModeVerify:
+		if p.CACert != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(p.CACert)) {
+				return nil, errors.New("CA证书不是有效的PEM格式")
+			}
+			cfg.RootCAs = pool
+		}
+	case models.TLSModePin:
+		pins, err := ParseFingerprints(p.Fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		if len(pins) == 0 {
+			return nil, errors.New("固定指纹模式需要设置证书指纹")
+		}
+		// 只校验服务器证书的指纹，适用于自签名证书
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errFingerprintMismatch
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			fingerprint := hex.EncodeToString(sum[:])
+			if !pins[fingerprint] {
+				return fmt.Errorf("%w: %s", errFingerprintMismatch, fingerprint)
+			}
+			return nil
+		}
+	case models.TLSModeInsecure:
+		cfg.InsecureSkipVerify = true
+	default:
+		return nil, fmt.Errorf("未知的TLS模式: %s", p.Mode)
+	}
+
+	return cfg, nil
+}
+
+// ParseFingerprints 解析逗号分隔的SHA-256证书指纹，允许使用冒号分隔的十六进制格式
+func ParseFingerprints(value string) (map[string]bool, error) {
+	pins := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		fingerprint := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(item), ":", ""))
+		if fingerprint == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("无效的SHA-256证书指纹: %s", strings.TrimSpace(item))
+		}
+		pins[fingerprint] = true
+	}
+	return pins, nil
+}
+
+// isHandshakeError 判断错误是否来自TLS握手或证书校验
+func isHandshakeError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.Is(err, errFingerprintMismatch) ||
+		errors.As(err, &verifyErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// imapAddress 解析IMAP服务器地址，未指定安全模式时143端口使用STARTTLS，其余使用隐式TLS
+func imapAddress(server, security string) (addr, host, mode string) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, ""
+	}
+
+	mode = security
+	if mode == "" {
+		mode = models.IMAPSecurityTLS
+		if port == "143" {
+			mode = models.IMAPSecurityStartTLS
+		}
+	}
+	if port == "" {
+		port = "993" // 默认IMAPS端口
+		if mode == models.IMAPSecurityStartTLS {
+			port = "143"
+		}
+	}
+
+	return net.JoinHostPort(host, port), host, mode
+}
+
+// dialIMAP 按账户的安全模式和证书校验策略连接IMAP服务器
+func (c *MailClient) dialIMAP() (*client.Client, error) {
+	addr, host, mode := imapAddress(c.config.Server, c.config.IMAPSecurity)
+	tlsConfig, err := c.config.TLS.Config(host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, imapDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// 握手和读取欢迎消息期间使用超时，连接建立后清除
+	conn.SetDeadline(time.Now().Add(imapDialTimeout))
+
+	var imapClient *client.Client
+	if mode == models.IMAPSecurityStartTLS {
+		if imapClient, err = client.New(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// 服务器不支持时不降级为明文
+		if ok, _ := imapClient.SupportStartTLS(); !ok {
+			imapClient.Logout()
+			return nil, fmt.Errorf("IMAP服务器不支持STARTTLS")
+		}
+		if err := imapClient.StartTLS(tlsConfig); err != nil {
+			imapClient.Logout()
+			if isHandshakeError(err) {
+				return nil, &TLSError{Server: addr, Err: err}
+			}
+			return nil, fmt.Errorf("启用STARTTLS失败: %v", err)
+		}
+	} else {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			// 握手期间超时或连接被重置属于网络问题，仍应重试
+			if isHandshakeError(err) {
+				return nil, &TLSError{Server: addr, Err: err}
+			}
+			return nil, fmt.Errorf("TLS握手失败: %w", err)
+		}
+		if imapClient, err = client.New(tlsConn); err != nil {
+			tlsConn.Close()
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return imapClient, nil
+}
@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestIMAPAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		security string
+		wantAddr string
+		wantMode string
+	}{
+		{"默认隐式TLS", "imap.example.com", "", "imap.example.com:993", models.IMAPSecurityTLS},
+		{"993端口", "imap.example.com:993", "", "imap.example.com:993", models.IMAPSecurityTLS},
+		{"143端口使用STARTTLS", "imap.example.com:143", "", "imap.example.com:143", models.IMAPSecurityStartTLS},
+		{"指定STARTTLS未指定端口", "imap.example.com", models.IMAPSecurityStartTLS, "imap.example.com:143", models.IMAPSecurityStartTLS},
+		{"非标准端口按指定模式", "imap.example.com:1143", models.IMAPSecurityStartTLS, "imap.example.com:1143", models.IMAPSecurityStartTLS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, host, mode := imapAddress(tt.server, tt.security)
+			if addr != tt.wantAddr || mode != tt.wantMode || host != "imap.example.com" {
+				t.Errorf("imapAddress() = %s, %s, %s, 期望 %s, %s", addr, host, mode, tt.wantAddr, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestParseFingerprints(t *testing.T) {
+	fingerprint := strings.Repeat("ab", sha256.Size)
+	colon := strings.ToUpper(strings.TrimSuffix(strings.Repeat("ab:", sha256.Size), ":"))
+
+	pins, err := ParseFingerprints(fingerprint + ", " + colon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || !pins[fingerprint] {
+		t.Errorf("冒号分隔和大写格式应归一化为同一指纹: %v", pins)
+	}
+
+	if _, err := ParseFingerprints("abcd"); err == nil {
+		t.Error("长度不足的指纹应返回错误")
+	}
+}
+
+func TestTLSPolicy_Config(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	addr := server.Listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		policy  TLSPolicy
+		wantErr bool
+	}{
+		{"系统CA不信任自签名证书", TLSPolicy{Mode: models.TLSModeVerify}, true},
+		{"自定义CA", TLSPolicy{Mode: models.TLSModeVerify, CACert: caPEM}, false},
+		{"固定指纹", TLSPolicy{Mode: models.TLSModePin, Fingerprints: hex.EncodeToString(sum[:])}, false},
+		{"指纹不匹配", TLSPolicy{Mode: models.TLSModePin, Fingerprints: strings.Repeat("00", sha256.Size)}, true},
+		{"不校验证书", TLSPolicy{Mode: models.TLSModeInsecure}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.policy.Config("example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := tls.Dial("tcp", addr, cfg)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("握手结果 = %v, 期望失败: %v", err, tt.wantErr)
+			}
+			if err != nil && !isHandshakeError(err) {
+				t.Errorf("应识别为TLS握手错误: %v", err)
+			}
+		})
+	}
+
+	if _, err := (TLSPolicy{Mode: models.TLSModePin}).Config("example.com"); err == nil {
+		t.Error("固定指纹模式未设置指纹时应返回错误")
+	}
+	if _, err := (TLSPolicy{CACert: "not a certificate"}).Config("example.com"); err == nil {
+		t.Error("无效的CA证书应返回错误")
+	}
+}
+
+func TestDialIMAP_HandshakeErrors(t *testing.T) {
+	// 接受连接后立即断开，模拟握手期间的网络中断
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	untrusted := startTestIMAPServer(t, 0)
+	untrusted.TLS = TLSPolicy{Mode: models.TLSModeVerify}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantTLS bool
+	}{
+		{"握手时连接被关闭", Config{Server: listener.Addr().String(), IMAPSecurity: models.IMAPSecurityTLS}, false},
+		{"证书不受信任", untrusted, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMailClient(nil)
+			c.config = tt.config
+			_, err := c.dialIMAP()
+			if err == nil {
+				t.Fatal("dialIMAP() 应返回错误")
+			}
+			if got := IsTLSError(err); got != tt.wantTLS {
+				t.Errorf("IsTLSError(%v) = %v, 期望 %v", err, got, tt.wantTLS)
+			}
+		})
+	}
+}
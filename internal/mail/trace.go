@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+
+	"mail-dispatcher/internal/models"
+)
+
+// 转发时写入的追踪头，用于识别本系统转发的邮件
+const (
+	ForwardedByHeader = "X-Forwarded-By"
+	ForwardedByValue  = "Mail-Dispatcher-System"
+	HopsHeader        = "X-Mail-Dispatcher-Hops" // 已经过本系统转发的次数
+)
+
+// normalizeMessageID 去掉 Message-ID 两侧的空白和尖括号
+func normalizeMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	id = strings.TrimSuffix(id, ">")
+	return strings.TrimSpace(id)
+}
+
+// contentHash 计算邮件内容的摘要，只包含日期、主题和正文，
+// 不受各邮箱添加的 Received、Delivered-To 等投递头以及转发时改写的地址头影响
+func contentHash(email models.Email) string {
+	h := sha256.New()
+	h.Write([]byte(firstHeader(email.Headers, "Date") + "\n" + email.Subject + "\n"))
+
+	if len(email.RawData) > 0 {
+		if _, body, err := splitMessage(email.RawData); err == nil {
+			h.Write(body)
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	h.Write([]byte(email.Body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// firstHeader 返回邮件头的第一个值
+func firstHeader(headers map[string][]string, key string) string {
+	if values := headers[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ForwardHops 返回邮件已经过本系统转发的次数
+// 旧版本转发的邮件没有计数头，只要带有转发标记就按一次计算
+func ForwardHops(email models.Email) int {
+	hops := 0
+	for _, value := range email.Headers[HopsHeader] {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n > hops {
+			hops = n
+		}
+	}
+	if hops == 0 {
+		for _, value := range email.Headers[ForwardedByHeader] {
+			if strings.TrimSpace(value) == ForwardedByValue {
+				return 1
+			}
+		}
+	}
+	return hops
+}
+
+// ForwardedAddresses 返回 Resent-* 头中的地址（小写），这些地址已经转发或收到过该邮件
+func ForwardedAddresses(email models.Email) []string {
+	var addresses []string
+	for _, key := range []string{"Resent-From", "Resent-Sender", "Resent-To", "Resent-Cc"} {
+		for _, value := range email.Headers[key] {
+			for _, addr := range parseAddressList(value) {
+				addresses = append(addresses, strings.ToLower(addr))
+			}
+		}
+	}
+	return addresses
+}
+
+// parseAddressList 解析地址列表，无法解析时把整个值当作一个地址
+func parseAddressList(raw string) []string {
+	var result []string
+	if list, err := (&netmail.AddressParser{WordDecoder: headerDecoder}).ParseList(raw); err == nil {
+		for _, addr := range list {
+			result = append(result, addr.Address)
+		}
+		return result
+	}
+	if raw = strings.TrimSpace(raw); raw != "" {
+		result = append(result, raw)
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package mail
+
+import (
+	"testing"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestNormalizeMessageID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want string
+	}{
+		{"带尖括号", "<abc@example.com>", "abc@example.com"},
+		{"带空白", "  <abc@example.com> ", "abc@example.com"},
+		{"没有尖括号", "abc@example.com", "abc@example.com"},
+		{"空值", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeMessageID(tt.id); got != tt.want {
+				t.Errorf("normalizeMessageID(%q) = %q, 期望 %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	parse := func(raw string) models.Email {
+		email := models.Email{RawData: []byte(raw)}
+		if err := parseBody(&email, email.RawData); err != nil {
+			t.Fatalf("parseBody() 返回错误: %v", err)
+		}
+		return email
+	}
+
+	original := parse("Received: from a.example.com\r\nDelivered-To: a@example.com\r\nFrom: alice@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0800\r\nSubject: 告警\r\n\r\n磁盘空间不足\r\n")
+	otherMailbox := parse("Received: from b.example.com\r\nDelivered-To: b@example.com\r\nFrom: alice@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0800\r\nSubject: 告警\r\n\r\n磁盘空间不足\r\n")
+	forwarded := parse("From: router@example.com\r\nResent-From: router@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0800\r\nSubject: 告警\r\n\r\n磁盘空间不足\r\n")
+	otherBody := parse("From: alice@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0800\r\nSubject: 告警\r\n\r\n磁盘空间已恢复\r\n")
+	otherDate := parse("From: alice@example.com\r\nDate: Mon, 12 Oct 2026 10:05:00 +0800\r\nSubject: 告警\r\n\r\n磁盘空间不足\r\n")
+
+	hash := contentHash(original)
+	if got := contentHash(otherMailbox); got != hash {
+		t.Error("投递头不同时内容摘要应相同")
+	}
+	if got := contentHash(forwarded); got != hash {
+		t.Error("转发时改写地址头后内容摘要应相同")
+	}
+	if got := contentHash(otherBody); got == hash {
+		t.Error("正文不同时内容摘要应不同")
+	}
+	if got := contentHash(otherDate); got == hash {
+		t.Error("日期不同时内容摘要应不同")
+	}
+}
+
+func TestForwardHops(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		want    int
+	}{
+		{"未转发", map[string][]string{}, 0},
+		{"旧版本转发", map[string][]string{ForwardedByHeader: {ForwardedByValue}}, 1},
+		{"其他系统的转发标记", map[string][]string{ForwardedByHeader: {"Other-Forwarder"}}, 0},
+		{"计数头", map[string][]string{ForwardedByHeader: {ForwardedByValue}, HopsHeader: {"3"}}, 3},
+		{"多个计数头取最大值", map[string][]string{HopsHeader: {"2", " 4 ", "x"}}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ForwardHops(models.Email{Headers: tt.headers}); got != tt.want {
+				t.Errorf("ForwardHops() = %d, 期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedAddresses(t *testing.T) {
+	email := models.Email{Headers: map[string][]string{
+		"Resent-From": {"Router <Router@Example.com>"},
+		"Resent-To":   {"a@example.com, b@example.com", "c@example.com"},
+		"To":          {"ignored@example.com"},
+	}}
+
+	got := ForwardedAddresses(email)
+	want := []string{"router@example.com", "a@example.com", "b@example.com", "c@example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("ForwardedAddresses() = %v, 期望 %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ForwardedAddresses()[%d] = %s, 期望 %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package middleware 提供 HTTP 中间件
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"mail-dispatcher/internal/models"
+	"mail-dispatcher/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey 认证通过的调用方在 gin.Context 中的键
+const principalKey = "principal"
+
+// anonymous 未启用认证时的调用方
+var anonymous = services.Principal{Name: "anonymous", Role: models.RoleAdmin}
+
+// Authenticate 校验请求头中的API密钥或JWT，支持 "Authorization: Bearer <token>" 和 "X-API-Key: <key>"
+func Authenticate(authService *services.AuthService, auditService *services.AuditService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !authService.Enabled() {
+			ctx.Set(principalKey, anonymous)
+			ctx.Next()
+			return
+		}
+
+		token := requestToken(ctx)
+		if token == "" {
+			recordFailure(ctx, auditService, models.AuditAuthFailed, "", "缺少认证信息")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少认证信息"})
+			return
+		}
+
+		principal, err := authService.Authenticate(token)
+		if err != nil {
+			recordFailure(ctx, auditService, models.AuditAuthFailed, "", err.Error()+" (凭据: "+tokenHint(token)+")")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "认证失败: " + err.Error()})
+			return
+		}
+
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// Authorize 按请求方法校验角色，查询需要只读权限，修改需要操作员权限
+func Authorize(auditService *services.AuditService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		required := models.RoleOperator
+		if ctx.Request.Method == http.MethodGet || ctx.Request.Method == http.MethodHead {
+			required = models.RoleReadOnly
+		}
+		checkRole(ctx, auditService, required)
+	}
+}
+
+// RequireRole 要求调用方至少具有指定角色
+func RequireRole(role string, auditService *services.AuditService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		checkRole(ctx, auditService, role)
+	}
+}
+
+// CurrentPrincipal 返回当前请求的调用方
+func CurrentPrincipal(ctx *gin.Context) (services.Principal, bool) {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return services.Principal{}, false
+	}
+	principal, ok := value.(services.Principal)
+	return principal, ok
+}
+
+// checkRole 角色不足时中止请求并记录审计事件
+func checkRole(ctx *gin.Context, auditService *services.AuditService, required string) {
+	principal, ok := CurrentPrincipal(ctx)
+	if !ok {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少认证信息"})
+		return
+	}
+	if !services.RoleAllows(principal.Role, required) {
+		recordFailure(ctx, auditService, models.AuditAuthForbidden, principal.Name, "需要 "+required+" 角色，当前为 "+principal.Role)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "权限不足，需要 " + required + " 角色"})
+		return
+	}
+	ctx.Next()
+}
+
+// requestToken 读取请求中的凭据
+func requestToken(ctx *gin.Context) string {
+	if key := strings.TrimSpace(ctx.GetHeader("X-API-Key")); key != "" {
+		return key
+	}
+	auth := ctx.GetHeader("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// tokenHint 审计日志中只保留凭据的前几个字符，用于识别是哪个密钥
+func tokenHint(token string) string {
+	if len(token) > 12 {
+		return token[:12] + "..."
+	}
+	return "***"
+}
+
+// recordFailure 记录认证或授权失败
+func recordFailure(ctx *gin.Context, auditService *services.AuditService, action, actor, detail string) {
+	auditService.Record(models.AuditEvent{
+		Actor:  actor,
+		Action: action,
+		Method: ctx.Request.Method,
+		Path:   ctx.Request.URL.Path,
+		IP:     ctx.ClientIP(),
+		Detail: detail,
+	})
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -12,6 +13,101 @@ type ForwardTarget struct {
 	Name        string `gorm:"uniqueIndex;size:100;not null;comment:转发对象名称"`
 	Email       string `gorm:"size:255;not null;comment:目标邮箱地址"`
 	Description string `gorm:"size:500;comment:描述或备注"`
+	MaxSize     int64  `gorm:"default:0;comment:转发邮件大小上限(字节)，0表示不限制"`
+	ForwardMode string `gorm:"size:20;default:redirect;comment:转发方式(redirect/wrap/inline)"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+// 转发方式
+const (
+	ForwardModeRedirect = "redirect" // 按 RFC 5322 添加 Resent-* 头重新发送，原邮件头和正文不变
+	ForwardModeWrap     = "wrap"     // 将原邮件作为 message/rfc822 附件转发
+	ForwardModeInline   = "inline"   // 在正文前引用原邮件的头信息转发
+)
+
+// 转发时的发件人
+const (
+	FromModeOriginal = "original" // 保留原发件人
+	FromModeRewrite  = "rewrite"  // 改为转发账户，原发件人写入 Reply-To，避免 DMARC 校验失败
+)
+
+// 转发时的信封发件人
+const (
+	EnvelopeModeAccount = "account" // 使用转发账户地址
+	EnvelopeModeSRS     = "srs"     // 按 SRS 改写原发件人，退信可以还原
+)
+
+// 处理后对原邮件执行的操作
+const (
+	MailboxActionSeen    = "seen"    // 标记为已读
+	MailboxActionKeyword = "keyword" // 添加自定义关键字
+	MailboxActionCopy    = "copy"    // 复制到其他文件夹
+	MailboxActionMove    = "move"    // 移动到其他文件夹
+	MailboxActionDelete  = "delete"  // 删除并清除
+)
+
+// MailboxAction 邮件处理后对来源邮箱中的原邮件执行的操作
+type MailboxAction struct {
+	Type   string
+	Target string // keyword 为关键字，copy 和 move 为目标文件夹
+}
+
+// 收信模式
+const (
+	FetchModePoll = "poll" // 定时轮询
+	FetchModePush = "push" // IMAP IDLE 推送
+)
+
+// SMTP 连接安全模式
+const (
+	SMTPSecurityTLS      = "tls"      // 隐式TLS，通常为465端口
+	SMTPSecurityStartTLS = "starttls" // 明文连接后升级，通常为587端口
+	SMTPSecurityNone     = "none"     // 不加密，通常为25端口
+)
+
+// IMAP 连接安全模式
+const (
+	IMAPSecurityTLS      = "tls"      // 隐式TLS，通常为993端口
+	IMAPSecurityStartTLS = "starttls" // 明文连接后升级，通常为143端口
+)
+
+// TLS 证书校验模式，同时用于IMAP和SMTP连接
+const (
+	TLSModeVerify   = "verify"   // 使用系统CA或账户配置的CA证书校验
+	TLSModePin      = "pin"      // 只校验证书的SHA-256指纹，适用于自签名证书
+	TLSModeInsecure = "insecure" // 不校验证书，仅用于测试
+)
+
+// SMTP 认证方式
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+	SMTPAuthNone    = "none"
+)
+
+// 账户认证方式
+const (
+	AuthTypePassword = "password" // 用户名和密码（或应用专用密码）
+	AuthTypeOAuth2   = "oauth2"   // OAuth2 访问令牌，使用 XOAUTH2/OAUTHBEARER 认证
+)
+
+// OAuth2 提供方
+const (
+	OAuthProviderGoogle    = "google"
+	OAuthProviderMicrosoft = "microsoft"
+)
+
+// TargetGroup 转发分组（通讯组），可包含多个转发目标和额外的邮箱地址
+type TargetGroup struct {
+	ID          uint            `gorm:"primaryKey"`
+	Name        string          `gorm:"uniqueIndex;size:100;not null;comment:分组名称"`
+	Description string          `gorm:"size:500;comment:描述或备注"`
+	Addresses   []string        `gorm:"serializer:json;type:text;comment:额外的邮箱地址"`
+	Targets     []ForwardTarget `gorm:"many2many:target_group_members"`
+	TargetIDs   []uint          `gorm:"-"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	DeletedAt   gorm.DeletedAt `gorm:"index"`
@@ -19,44 +115,313 @@ type ForwardTarget struct {
 
 // MailAccount 邮箱账户表
 type MailAccount struct {
-	ID        uint   `gorm:"primaryKey"`
-	Address   string `gorm:"size:255;not null;comment:邮箱地址"`
-	Username  string `gorm:"size:255;comment:登录用户名"`
-	Password  string `gorm:"size:500;comment:密码或OAuth token"`
-	Server    string `gorm:"size:255;comment:IMAP服务器地址"`
-	Settings  string `gorm:"type:text;comment:其他配置JSON"`
-	LastUID   uint32 `gorm:"comment:上次处理的IMAP UID"`
-	IsActive  bool   `gorm:"default:true;comment:是否启用"`
+	ID             uint   `gorm:"primaryKey"`
+	Address        string `gorm:"size:255;not null;comment:邮箱地址"`
+	Username       string `gorm:"size:255;comment:登录用户名"`
+	AuthType       string `gorm:"size:20;default:password;comment:认证方式(password/oauth2)"`
+	Password       string `gorm:"type:text;serializer:secret;comment:密码，加密存储"`
+	Server         string `gorm:"size:255;comment:IMAP服务器地址"`
+	IMAPSecurity   string `gorm:"size:20;comment:IMAP安全模式(tls/starttls)，为空时按端口选择"`
+	TLSMode        string `gorm:"size:20;default:verify;comment:证书校验模式(verify/pin/insecure)"`
+	TLSCACert      string `gorm:"type:text;comment:PEM格式的CA证书，为空时使用系统CA"`
+	TLSFingerprint string `gorm:"size:500;comment:固定的证书SHA-256指纹，多个以逗号分隔"`
+	Settings       string `gorm:"type:text;comment:其他配置JSON"`
+	SMTPHost       string `gorm:"size:255;comment:SMTP服务器地址"`
+	SMTPPort       int    `gorm:"comment:SMTP端口"`
+	SMTPSecurity   string `gorm:"size:20;comment:SMTP安全模式(tls/starttls/none)"`
+	SMTPAuth       string `gorm:"size:20;comment:SMTP认证方式(plain/login/cram-md5/none)"`
+	SMTPUsername   string `gorm:"size:255;comment:SMTP用户名，为空时使用IMAP用户名"`
+	SMTPPassword   string `gorm:"type:text;serializer:secret;comment:SMTP密码，为空时使用IMAP密码，加密存储"`
+
+	OAuthProvider     string     `gorm:"column:oauth_provider;size:20;comment:OAuth2提供方(google/microsoft)"`
+	OAuthAccessToken  string     `gorm:"column:oauth_access_token;type:text;serializer:secret;comment:OAuth2访问令牌，加密存储"`
+	OAuthRefreshToken string     `gorm:"column:oauth_refresh_token;type:text;serializer:secret;comment:OAuth2刷新令牌，加密存储"`
+	OAuthExpiry       *time.Time `gorm:"column:oauth_expiry;comment:OAuth2访问令牌过期时间"`
+
+	Folders      []string   `gorm:"serializer:json;type:text;comment:监控的文件夹，为空时只监控收件箱"`
+	LastUID      uint32     `gorm:"comment:升级前收件箱的IMAP UID水位线，已迁移到 FolderState"`
+	UIDValidity  uint32     `gorm:"comment:升级前收件箱的UIDVALIDITY，已迁移到 FolderState"`
+	FetchMode    string     `gorm:"size:20;default:poll;comment:收信模式(poll/push)"`
+	PollInterval int        `gorm:"default:0;comment:轮询间隔(秒)，0表示使用全局配置"`
+	PollSchedule string     `gorm:"size:100;comment:活跃时段(cron表达式)，为空表示不限"`
+	PollFailures int        `gorm:"default:0;comment:连续轮询失败次数"`
+	NextPollAt   *time.Time `gorm:"comment:下次轮询时间，为空表示尽快轮询"`
+
+	HealthState   string     `gorm:"size:20;default:healthy;comment:健康状态(healthy/failing/paused)"`
+	LastSuccessAt *time.Time `gorm:"comment:上次成功连接的时间"`
+	LastError     string     `gorm:"type:text;comment:最近一次错误"`
+	LastErrorAt   *time.Time `gorm:"comment:最近一次错误的时间"`
+	AuthFailures  int        `gorm:"default:0;comment:连续认证失败次数"`
+
+	FromMode     string `gorm:"size:20;default:original;comment:转发时的发件人(original/rewrite)"`
+	EnvelopeMode string `gorm:"size:20;default:account;comment:转发时的信封发件人(account/srs)"`
+
+	DispatchedActions []MailboxAction `gorm:"serializer:json;type:text;comment:转发成功后对原邮件执行的操作"`
+	FailedActions     []MailboxAction `gorm:"serializer:json;type:text;comment:路由失败后对原邮件执行的操作"`
+
+	MaxSize   int64 `gorm:"default:0;comment:转发邮件大小上限(字节)，0表示不限制"`
+	IsActive  bool  `gorm:"default:true;comment:是否启用"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// FolderState 账户各监控文件夹的UID水位线
+type FolderState struct {
+	ID          uint   `gorm:"primaryKey"`
+	AccountID   uint   `gorm:"not null;uniqueIndex:idx_folder_state;comment:账户ID"`
+	Folder      string `gorm:"size:255;not null;uniqueIndex:idx_folder_state;comment:文件夹名称"`
+	UIDValidity uint32 `gorm:"comment:文件夹的UIDVALIDITY"`
+	LastUID     uint32 `gorm:"comment:上次处理的IMAP UID"`
+	UpdatedAt   time.Time
+}
+
+// 账户健康状态
+const (
+	HealthHealthy  = "healthy"  // 最近一次连接成功
+	HealthFailing  = "failing"  // 连续失败，按退避间隔重试
+	HealthPaused   = "paused"   // 连续认证失败，已自动停用
+	HealthDisabled = "disabled" // 手动停用，只出现在健康检查接口中
+)
+
+// RedactedValue API 响应中代替凭据的占位符
+const RedactedValue = "******"
+
+// MarshalJSON 序列化时隐藏凭据，已设置的凭据显示为占位符
+func (a MailAccount) MarshalJSON() ([]byte, error) {
+	type account MailAccount
+	redacted := account(a)
+	if redacted.Password != "" {
+		redacted.Password = RedactedValue
+	}
+	if redacted.SMTPPassword != "" {
+		redacted.SMTPPassword = RedactedValue
+	}
+	if redacted.OAuthAccessToken != "" {
+		redacted.OAuthAccessToken = RedactedValue
+	}
+	if redacted.OAuthRefreshToken != "" {
+		redacted.OAuthRefreshToken = RedactedValue
+	}
+	return json.Marshal(redacted)
+}
+
+// OAuthState OAuth2 授权请求表，回调时根据 state 找到要关联的账户
+type OAuthState struct {
+	ID           uint      `gorm:"primaryKey"`
+	State        string    `gorm:"uniqueIndex;size:64;not null;comment:授权请求的state参数"`
+	AccountID    uint      `gorm:"not null;comment:要关联的账户ID"`
+	Provider     string    `gorm:"size:20;not null;comment:OAuth2提供方"`
+	CodeVerifier string    `gorm:"type:text;serializer:secret;comment:PKCE校验码，加密存储"`
+	ExpiresAt    time.Time `gorm:"index;comment:过期时间"`
+	CreatedAt    time.Time
+}
+
 // MailLog 邮件处理日志表
 type MailLog struct {
 	ID          uint        `gorm:"primaryKey"`
 	AccountID   uint        `gorm:"not null;comment:来源账户ID"`
 	Account     MailAccount `gorm:"foreignKey:AccountID"`
 	MessageID   string      `gorm:"size:500;comment:邮件Message-ID"`
+	DedupKey    *string     `gorm:"size:64;uniqueIndex:idx_mail_log_dedup;comment:去重键，由Message-ID和内容摘要计算，重试、路由失败和环路的日志为空"`
 	Subject     string      `gorm:"size:500;comment:邮件主题"`
+	RawSubject  string      `gorm:"size:1000;comment:未解码的原始主题"`
 	From        string      `gorm:"size:255;comment:发件人地址"`
+	FromName    string      `gorm:"size:255;comment:发件人显示名称"`
+	RawFrom     string      `gorm:"size:1000;comment:未解码的原始发件人"`
 	To          string      `gorm:"size:255;comment:原邮件收件人"`
+	Folder      string      `gorm:"size:255;comment:原邮件所在的文件夹，为空表示收件箱"`
+	UID         uint32      `gorm:"comment:原邮件在文件夹中的UID"`
+	UIDValidity uint32      `gorm:"comment:原邮件所在文件夹的UIDVALIDITY"`
 	ReceivedAt  time.Time   `gorm:"comment:邮件接收时间"`
-	ForwardTo   string      `gorm:"size:255;comment:转发目标地址"`
+	ForwardTo   string      `gorm:"size:255;uniqueIndex:idx_mail_log_dedup;comment:转发目标地址"`
 	Status      string      `gorm:"size:50;not null;comment:处理状态"`
 	Error       string      `gorm:"type:text;comment:错误信息"`
+	Dropped     string      `gorm:"type:text;comment:超出大小限制未转发的附件"`
 	ForwardedAt *time.Time  `gorm:"comment:转发时间"`
+	RetryOfID   uint        `gorm:"index;comment:重试来源日志ID，0表示首次处理"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// 投递队列状态
+const (
+	OutboundPending = "pending" // 等待投递或等待重试
+	OutboundSending = "sending" // 正在投递
+	OutboundSent    = "sent"    // 投递成功
+	OutboundDead    = "dead"    // 超过最大尝试次数或被拒收，不再重试
+)
+
+// OutboundMessage 待投递邮件队列表，每个收件人一行
+type OutboundMessage struct {
+	ID            uint       `gorm:"primaryKey"`
+	AccountID     uint       `gorm:"not null;index;comment:发送账户ID"`
+	MailLogID     uint       `gorm:"index;comment:对应的邮件日志ID"`
+	MessageID     string     `gorm:"size:500;comment:邮件Message-ID"`
+	Subject       string     `gorm:"size:500;comment:邮件主题"`
+	ToAddress     string     `gorm:"size:255;not null;comment:收件人地址"`
+	MaxSize       int64      `gorm:"default:0;comment:转发目标的大小上限(字节)"`
+	ForwardMode   string     `gorm:"size:20;comment:转发方式，为空时使用 redirect"`
+	Email         Email      `gorm:"serializer:json;type:longtext;comment:待发送的邮件内容"`
+	Status        string     `gorm:"size:20;not null;index;comment:投递状态(pending/sending/sent/dead)"`
+	Attempts      int        `gorm:"default:0;comment:已尝试次数"`
+	MaxAttempts   int        `gorm:"default:0;comment:最大尝试次数"`
+	NextAttemptAt time.Time  `gorm:"index;comment:下次尝试时间"`
+	LastError     string     `gorm:"type:text;comment:最近一次错误"`
+	LastCode      int        `gorm:"default:0;comment:最近一次SMTP响应码"`
+	SentAt        *time.Time `gorm:"comment:投递成功时间"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// DeliveryAttempt 投递尝试记录表
+type DeliveryAttempt struct {
+	ID         uint   `gorm:"primaryKey"`
+	OutboundID uint   `gorm:"not null;index;comment:投递队列ID"`
+	Attempt    int    `gorm:"not null;comment:第几次尝试"`
+	Status     string `gorm:"size:20;not null;comment:尝试结果(sent/failed)"`
+	SMTPCode   int    `gorm:"default:0;comment:SMTP响应码，0表示未收到服务器响应"`
+	Error      string `gorm:"type:text;comment:错误信息"`
+	CreatedAt  time.Time
+}
+
+// 路由规则类型
+const (
+	RuleTypeMatch         = "match"          // 按条件匹配，转发到规则关联的目标
+	RuleTypeSubjectFormat = "subject_format" // 内置的 "关键字 - 转发对象名称" 主题格式
+)
+
+// RoutingRule 路由规则表，已设置的条件需全部满足才算匹配
+type RoutingRule struct {
+	ID            uint            `gorm:"primaryKey"`
+	Name          string          `gorm:"size:100;not null;comment:规则名称"`
+	Type          string          `gorm:"size:50;not null;default:match;comment:规则类型(match/subject_format)"`
+	Priority      int             `gorm:"default:0;index;comment:优先级，数值越小越先匹配"`
+	Continue      bool            `gorm:"default:false;comment:匹配后是否继续评估后续规则"`
+	Sender        string          `gorm:"size:255;comment:发件人包含"`
+	Recipient     string          `gorm:"size:255;comment:收件人包含"`
+	SubjectRegex  string          `gorm:"size:500;comment:主题正则表达式"`
+	HeaderName    string          `gorm:"size:100;comment:邮件头名称"`
+	HeaderValue   string          `gorm:"size:500;comment:邮件头值包含"`
+	BodyKeyword   string          `gorm:"size:255;comment:正文关键字"`
+	HasAttachment *bool           `gorm:"comment:是否带附件，为空表示不限"`
+	Folder        string          `gorm:"size:255;comment:来源文件夹，为空表示不限"`
+	IsActive      bool            `gorm:"default:true;comment:是否启用"`
+	Targets       []ForwardTarget `gorm:"many2many:routing_rule_targets"`
+	TargetIDs     []uint          `gorm:"-"`
+	Groups        []TargetGroup   `gorm:"many2many:routing_rule_groups"`
+	GroupIDs      []uint          `gorm:"-"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+// API 角色，权限依次递增
+const (
+	RoleReadOnly = "readonly" // 只能查询
+	RoleOperator = "operator" // 可以管理转发目标、分组、规则和重试
+	RoleAdmin    = "admin"    // 可以管理邮箱账户凭据和API密钥
+)
+
+// APIKey API密钥表，只保存密钥的哈希
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey"`
+	Name       string     `gorm:"size:100;not null;comment:密钥名称"`
+	Prefix     string     `gorm:"size:20;index;comment:密钥前缀，用于识别密钥"`
+	KeyHash    string     `gorm:"uniqueIndex;size:64;not null;comment:密钥的SHA-256哈希" audit:"-"`
+	Role       string     `gorm:"size:20;not null;comment:角色(admin/operator/readonly)"`
+	IsActive   bool       `gorm:"default:true;comment:是否启用"`
+	ExpiresAt  *time.Time `gorm:"comment:过期时间，为空表示不过期"`
+	LastUsedAt *time.Time `gorm:"comment:最近使用时间"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+}
+
+// MarshalJSON 序列化时不输出密钥哈希
+func (k APIKey) MarshalJSON() ([]byte, error) {
+	type apiKey APIKey
+	redacted := apiKey(k)
+	redacted.KeyHash = ""
+	return json.Marshal(redacted)
+}
+
+// DKIM 签名算法
+const (
+	DKIMAlgorithmRSA     = "rsa"
+	DKIMAlgorithmEd25519 = "ed25519"
+)
+
+// DKIMKey DKIM签名密钥表，每个域名一个密钥，发件账户的域名有启用的密钥时对转发邮件签名
+type DKIMKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	Domain     string `gorm:"uniqueIndex;size:255;not null;comment:签名域名"`
+	Selector   string `gorm:"size:63;not null;comment:DKIM选择器"`
+	Algorithm  string `gorm:"size:20;not null;comment:签名算法(rsa/ed25519)"`
+	PrivateKey string `gorm:"type:text;serializer:secret;comment:PEM格式的私钥，加密存储"`
+	IsActive   bool   `gorm:"default:true;comment:是否启用"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// MarshalJSON 序列化时不输出私钥
+func (k DKIMKey) MarshalJSON() ([]byte, error) {
+	type dkimKey DKIMKey
+	redacted := dkimKey(k)
+	if redacted.PrivateKey != "" {
+		redacted.PrivateKey = RedactedValue
+	}
+	return json.Marshal(redacted)
+}
+
+// 审计事件类型
+const (
+	AuditAuthFailed    = "auth.failed"    // 认证失败
+	AuditAuthForbidden = "auth.forbidden" // 角色权限不足
+	AuditCreate        = "create"         // 创建配置
+	AuditUpdate        = "update"         // 修改配置
+	AuditDelete        = "delete"         // 删除配置
+	AuditToggle        = "toggle"         // 启用或停用
+)
+
+// AuditChange 字段的变更前后值，凭据只显示为占位符
+type AuditChange struct {
+	Before interface{}
+	After  interface{}
+}
+
+// AuditEvent 审计日志表
+type AuditEvent struct {
+	ID        uint                   `gorm:"primaryKey"`
+	Actor     string                 `gorm:"size:100;index;comment:操作者，认证失败时为空"`
+	Action    string                 `gorm:"size:50;not null;index;comment:操作类型"`
+	Entity    string                 `gorm:"size:50;index:idx_audit_entity;comment:操作对象类型"`
+	EntityID  uint                   `gorm:"index:idx_audit_entity;comment:操作对象ID"`
+	Method    string                 `gorm:"size:10;comment:请求方法"`
+	Path      string                 `gorm:"size:255;comment:请求路径"`
+	IP        string                 `gorm:"size:45;comment:来源IP"`
+	Detail    string                 `gorm:"type:text;comment:详细信息"`
+	Changes   map[string]AuditChange `gorm:"serializer:json;type:text;comment:字段变更，创建时只有变更后的值，删除时只有变更前的值"`
+	CreatedAt time.Time              `gorm:"index"`
+}
+
 // Email 内部邮件结构
 type Email struct {
-	MessageID  string
-	Subject    string
-	From       string
-	To         string
-	Body       string
-	RawData    []byte
-	ReceivedAt time.Time
+	Folder      string
+	UID         uint32
+	UIDValidity uint32
+	MessageID   string
+	ContentHash string
+	Subject     string
+	RawSubject  string
+	From        string
+	FromName    string
+	RawFrom     string
+	To          string
+	Body        string
+	HTMLBody    string
+	Headers     map[string][]string
+	Attachments []string
+	RawData     []byte
+	ReceivedAt  time.Time
 }
@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"mail-dispatcher/internal/config"
 	"mail-dispatcher/internal/controllers"
+	"mail-dispatcher/internal/middleware"
+	"mail-dispatcher/internal/models"
 	"mail-dispatcher/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -9,14 +12,29 @@ import (
 )
 
 // SetupRoutes 设置路由
-func SetupRoutes(router *gin.Engine, db *gorm.DB, logService *services.LogService) {
+func SetupRoutes(router *gin.Engine, db *gorm.DB, logService *services.LogService, retryService *services.RetryService, oauthService *services.OAuthService, authService *services.AuthService, auditService *services.AuditService, cfg *config.Config) {
 	// 创建控制器
-	targetController := controllers.NewTargetController(db)
-	accountController := controllers.NewAccountController(db)
-	logController := controllers.NewLogController(logService)
+	targetController := controllers.NewTargetController(db, auditService)
+	accountController := controllers.NewAccountController(db, oauthService, auditService, cfg)
+	logController := controllers.NewLogController(logService, retryService)
+	ruleController := controllers.NewRuleController(db, auditService)
+	groupController := controllers.NewGroupController(db, auditService)
+	queueController := controllers.NewQueueController(db)
+	oauthController := controllers.NewOAuthController(oauthService, auditService)
+	keyController := controllers.NewKeyController(db, authService, auditService)
+	auditController := controllers.NewAuditController(auditService)
+	dkimController := controllers.NewDKIMController(db, auditService)
 
-	// API路由组
-	api := router.Group("/api/v1")
+	// OAuth2 授权回调由提供方跳转，不经过API认证，通过 state 参数校验
+	router.GET("/api/v1/oauth/callback", oauthController.Callback)
+
+	// 管理邮箱凭据、API密钥、DKIM密钥和查看审计日志需要管理员权限
+	adminOnly := middleware.RequireRole(models.RoleAdmin, auditService)
+	// 会向外部服务器发起连接的查询接口需要操作员权限
+	operatorOnly := middleware.RequireRole(models.RoleOperator, auditService)
+
+	// API路由组，查询需要只读权限，修改需要操作员权限
+	api := router.Group("/api/v1", middleware.Authenticate(authService, auditService), middleware.Authorize(auditService))
 	{
 		// 转发目标管理
 		targets := api.Group("/targets")
@@ -28,15 +46,42 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, logService *services.LogServic
 			targets.DELETE("/:id", targetController.DeleteTarget)
 		}
 
+		// 转发分组管理
+		groups := api.Group("/groups")
+		{
+			groups.GET("", groupController.GetGroups)
+			groups.GET("/:id", groupController.GetGroup)
+			groups.POST("", groupController.CreateGroup)
+			groups.PUT("/:id", groupController.UpdateGroup)
+			groups.DELETE("/:id", groupController.DeleteGroup)
+		}
+
 		// 邮箱账户管理
 		accounts := api.Group("/accounts")
 		{
 			accounts.GET("", accountController.GetAccounts)
+			accounts.GET("/discover", operatorOnly, accountController.DiscoverSettings)
 			accounts.GET("/:id", accountController.GetAccount)
-			accounts.POST("", accountController.CreateAccount)
-			accounts.PUT("/:id", accountController.UpdateAccount)
-			accounts.DELETE("/:id", accountController.DeleteAccount)
+			accounts.GET("/:id/health", accountController.GetAccountHealth)
+			accounts.GET("/:id/folders", operatorOnly, accountController.ListAccountFolders)
+			accounts.POST("/:id/test", accountController.CheckAccountConnection)
+			accounts.POST("", adminOnly, accountController.CreateAccount)
+			accounts.POST("/test", adminOnly, accountController.CheckConnection)
+			accounts.PUT("/:id", adminOnly, accountController.UpdateAccount)
+			accounts.DELETE("/:id", adminOnly, accountController.DeleteAccount)
 			accounts.PUT("/:id/toggle", accountController.ToggleAccountStatus)
+			accounts.POST("/:id/oauth/authorize", adminOnly, oauthController.Authorize)
+		}
+
+		// 路由规则管理
+		rules := api.Group("/rules")
+		{
+			rules.GET("", ruleController.GetRules)
+			rules.GET("/:id", ruleController.GetRule)
+			rules.POST("", ruleController.CreateRule)
+			rules.PUT("/:id", ruleController.UpdateRule)
+			rules.DELETE("/:id", ruleController.DeleteRule)
+			rules.PUT("/:id/toggle", ruleController.ToggleRuleStatus)
 		}
 
 		// 邮件日志管理
@@ -47,7 +92,37 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, logService *services.LogServic
 			logs.GET("/successful", logController.GetSuccessfulLogs)
 			logs.GET("/range", logController.GetLogsByDateRange)
 			logs.GET("/stats", logController.GetLogsStats)
+			logs.POST("/retry", logController.RetryLogs)
+			logs.GET("/retry/:job", logController.GetRetryJob)
+			logs.POST("/:id/retry", logController.RetryLog)
+		}
+
+		// 投递队列
+		queue := api.Group("/queue")
+		{
+			queue.GET("", queueController.GetQueue)
+			queue.GET("/:id", queueController.GetQueueItem)
+		}
+
+		// API密钥管理
+		keys := api.Group("/keys", adminOnly)
+		{
+			keys.GET("", keyController.GetKeys)
+			keys.POST("", keyController.CreateKey)
+			keys.DELETE("/:id", keyController.DeleteKey)
 		}
+
+		// DKIM密钥管理
+		dkim := api.Group("/dkim")
+		{
+			dkim.GET("", dkimController.GetDKIMKeys)
+			dkim.POST("", adminOnly, dkimController.CreateDKIMKey)
+			dkim.PUT("/:id/toggle", adminOnly, dkimController.ToggleDKIMKey)
+			dkim.DELETE("/:id", adminOnly, dkimController.DeleteDKIMKey)
+		}
+
+		// 审计日志
+		api.GET("/audit", adminOnly, auditController.GetAuditEvents)
 	}
 
 	// 健康检查
@@ -65,8 +140,14 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, logService *services.LogServic
 			"version": "1.0.0",
 			"endpoints": gin.H{
 				"targets":  "/api/v1/targets",
+				"groups":   "/api/v1/groups",
 				"accounts": "/api/v1/accounts",
+				"rules":    "/api/v1/rules",
 				"logs":     "/api/v1/logs",
+				"queue":    "/api/v1/queue",
+				"keys":     "/api/v1/keys",
+				"dkim":     "/api/v1/dkim",
+				"audit":    "/api/v1/audit",
 				"health":   "/ping",
 			},
 		})
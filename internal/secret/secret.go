@@ -0,0 +1,289 @@
+// Package secret 提供账户凭据的信封加密
+//
+// 每个值使用随机生成的数据密钥以 AES-256-GCM 加密，数据密钥再用主密钥加密后与密文一起保存。
+// 轮换主密钥时只需用新主密钥重新加密数据密钥，密文本身保持不变。
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// prefix 加密值的前缀，不带前缀的值视为旧版明文
+const prefix = "enc:v1:"
+
+// keySize 主密钥和数据密钥的长度，对应 AES-256
+const keySize = 32
+
+// defaultKeyID 未指定ID的主密钥使用的ID
+const defaultKeyID = "default"
+
+// ErrNoKey 存在加密的凭据但没有配置对应的主密钥
+var ErrNoKey = errors.New("未配置对应的主密钥，无法解密凭据")
+
+// Keyring 主密钥集合，第一个密钥用于加密，其余密钥只用于解密轮换前的数据
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// ParseKeys 解析主密钥配置
+// 多个密钥以换行或逗号分隔，格式为 "ID=Base64密钥"，省略ID时使用 default，# 开头的行为注释
+func ParseKeys(spec string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string][]byte)}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded := defaultKeyID, entry
+		// Base64 的填充字符也是 "="，第一个等号后面还有其他内容时才是ID分隔符
+		if i := strings.Index(entry, "="); i > 0 && strings.Trim(entry[i:], "=") != "" {
+			id, encoded = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("主密钥ID不能包含冒号: %s", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("主密钥 %s 不是有效的Base64: %v", id, err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("主密钥 %s 长度必须为%d字节", id, keySize)
+		}
+		if _, ok := keyring.keys[id]; ok {
+			return nil, fmt.Errorf("主密钥ID重复: %s", id)
+		}
+
+		keyring.keys[id] = key
+		if keyring.current == "" {
+			keyring.current = id
+		}
+	}
+
+	if keyring.current == "" {
+		return nil, nil
+	}
+	return keyring, nil
+}
+
+// LoadKeys 从密钥文件或配置值加载主密钥，密钥文件优先，都未配置时返回 nil
+func LoadKeys(masterKey, keyFile string) (*Keyring, error) {
+	spec := masterKey
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+		}
+		spec = string(data)
+	}
+	return ParseKeys(spec)
+}
+
+// CurrentKeyID 返回用于加密的主密钥ID
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Encrypt 加密凭据，空值保持为空
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("生成数据密钥失败: %v", err)
+	}
+
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return format(k.current, wrapped, ciphertext), nil
+}
+
+// Decrypt 解密凭据，旧版明文原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	id, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return value, nil
+	}
+
+	dataKey, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("解密凭据失败: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap 用当前主密钥重新加密数据密钥，旧版明文会被加密
+// 已使用当前主密钥的值原样返回，changed 为 false
+func (k *Keyring) Rewrap(value string) (result string, changed bool, err error) {
+	id, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if id == "" {
+		if value == "" {
+			return "", false, nil
+		}
+		encrypted, err := k.Encrypt(value)
+		return encrypted, err == nil, err
+	}
+	if id == k.current {
+		return value, false, nil
+	}
+
+	dataKey, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	return format(k.current, rewrapped, ciphertext), true, nil
+}
+
+// unwrap 用指定的主密钥解密数据密钥
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoKey, id)
+	}
+	dataKey, err := open(key, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("解密数据密钥失败: %v", err)
+	}
+	return dataKey, nil
+}
+
+// IsEncrypted 判断值是否为加密格式
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// format 拼接加密值: enc:v1:<主密钥ID>:<加密的数据密钥>:<密文>
+func format(id string, wrapped, ciphertext []byte) string {
+	return prefix + id + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// parse 拆分加密值，明文返回空的主密钥ID
+func parse(value string) (id string, wrapped, ciphertext []byte, err error) {
+	if !IsEncrypted(value) {
+		return "", nil, nil, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("加密凭据格式错误")
+	}
+	if wrapped, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("加密凭据格式错误: %v", err)
+	}
+	if ciphertext, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("加密凭据格式错误: %v", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// seal 使用 AES-GCM 加密，随机 nonce 放在密文前
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open 解密 seal 生成的数据
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度不足")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// newGCM 创建 AES-GCM
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	mu      sync.RWMutex
+	keyring *Keyring
+)
+
+// SetKeyring 设置全局使用的主密钥，nil 表示不加密
+func SetKeyring(k *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	keyring = k
+}
+
+// Default 返回全局使用的主密钥
+func Default() *Keyring {
+	mu.RLock()
+	defer mu.RUnlock()
+	return keyring
+}
+
+// Encrypt 使用全局主密钥加密，未配置主密钥时原样返回
+func Encrypt(plaintext string) (string, error) {
+	k := Default()
+	if k == nil {
+		return plaintext, nil
+	}
+	return k.Encrypt(plaintext)
+}
+
+// Decrypt 使用全局主密钥解密
+func Decrypt(value string) (string, error) {
+	k := Default()
+	if k == nil {
+		if IsEncrypted(value) {
+			return "", ErrNoKey
+		}
+		return value, nil
+	}
+	return k.Decrypt(value)
+}
@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey 生成指定字节填充的测试主密钥
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), keySize)))
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		wantCurrent string
+		wantErr     bool
+	}{
+		{"未配置", "", "", false},
+		{"省略ID", testKey('a'), defaultKeyID, false},
+		{"带ID", "k2=" + testKey('b'), "k2", false},
+		{"多个密钥取第一个", "# 当前密钥\nk2=" + testKey('b') + "\nk1=" + testKey('a'), "k2", false},
+		{"逗号分隔", "k2=" + testKey('b') + ",k1=" + testKey('a'), "k2", false},
+		{"长度错误", "k1=" + base64.StdEncoding.EncodeToString([]byte("short")), "", true},
+		{"无效Base64", "k1=不是密钥", "", true},
+		{"ID重复", "k1=" + testKey('a') + "\nk1=" + testKey('b'), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := ParseKeys(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantCurrent == "" {
+				if keyring != nil {
+					t.Errorf("未配置时应返回 nil")
+				}
+				return
+			}
+			if keyring.CurrentKeyID() != tt.wantCurrent {
+				t.Errorf("CurrentKeyID() = %s, 期望 %s", keyring.CurrentKeyID(), tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	keyring, err := ParseKeys("k1=" + testKey('a'))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := keyring.Encrypt("授权码-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret") {
+		t.Fatalf("加密结果不应包含明文: %s", encrypted)
+	}
+
+	other, _ := keyring.Encrypt("授权码-secret")
+	if other == encrypted {
+		t.Error("每次加密应使用不同的数据密钥")
+	}
+
+	decrypted, err := keyring.Decrypt(encrypted)
+	if err != nil || decrypted != "授权码-secret" {
+		t.Errorf("Decrypt() = %q, %v", decrypted, err)
+	}
+
+	if plain, err := keyring.Decrypt("legacy-password"); err != nil || plain != "legacy-password" {
+		t.Errorf("旧版明文应原样返回: %q, %v", plain, err)
+	}
+
+	if empty, _ := keyring.Encrypt(""); empty != "" {
+		t.Errorf("空值应保持为空: %q", empty)
+	}
+}
+
+func TestKeyring_Rewrap(t *testing.T) {
+	oldKeyring, _ := ParseKeys("k1=" + testKey('a'))
+	encrypted, _ := oldKeyring.Encrypt("password")
+
+	// 新主密钥在前，旧主密钥保留用于解密
+	keyring, _ := ParseKeys("k2=" + testKey('b') + "\nk1=" + testKey('a'))
+
+	rewrapped, changed, err := keyring.Rewrap(encrypted)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap() changed = %v, err = %v", changed, err)
+	}
+	if !strings.HasPrefix(rewrapped, prefix+"k2:") {
+		t.Errorf("应使用新主密钥: %s", rewrapped)
+	}
+	// 密文部分保持不变
+	if rewrapped[strings.LastIndex(rewrapped, ":"):] != encrypted[strings.LastIndex(encrypted, ":"):] {
+		t.Error("轮换不应重新加密密文")
+	}
+
+	newOnly, _ := ParseKeys("k2=" + testKey('b'))
+	if plain, err := newOnly.Decrypt(rewrapped); err != nil || plain != "password" {
+		t.Errorf("移除旧主密钥后应仍能解密: %q, %v", plain, err)
+	}
+	if _, err := newOnly.Decrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("缺少主密钥时应返回 ErrNoKey: %v", err)
+	}
+
+	if _, changed, _ := keyring.Rewrap(rewrapped); changed {
+		t.Error("已使用当前主密钥的值不应改变")
+	}
+
+	plainRewrapped, changed, err := keyring.Rewrap("legacy-password")
+	if err != nil || !changed || !IsEncrypted(plainRewrapped) {
+		t.Errorf("旧版明文应被加密: %q, %v, %v", plainRewrapped, changed, err)
+	}
+}
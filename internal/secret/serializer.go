@@ -0,0 +1,93 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("secret", Serializer{})
+}
+
+// Serializer GORM 序列化器，字段使用 `gorm:"serializer:secret"` 后读写时自动解密和加密
+type Serializer struct{}
+
+// Scan 从数据库读取时解密
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	case nil:
+	default:
+		return fmt.Errorf("不支持的凭据类型: %T", dbValue)
+	}
+
+	plaintext, err := Decrypt(value)
+	if err != nil {
+		return fmt.Errorf("解密字段 %s 失败: %w", field.Name, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+// Value 写入数据库前加密
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, _ := fieldValue.(string)
+	return Encrypt(plaintext)
+}
+
+// Rotate 将表中指定列的凭据改为使用当前主密钥，旧版明文同时被加密，返回更新的行数
+// 直接读写原始列值，绕过序列化器，数据密钥不变时密文无需重新生成
+func Rotate(db *gorm.DB, table string, columns ...string) (int, error) {
+	k := Default()
+	if k == nil {
+		return 0, nil
+	}
+
+	var rows []map[string]interface{}
+	if err := db.Table(table).Select(append([]string{"id"}, columns...)).Find(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, row := range rows {
+		updates := make(map[string]interface{})
+		for _, column := range columns {
+			value := toString(row[column])
+			rewrapped, changed, err := k.Rewrap(value)
+			if err != nil {
+				return updated, fmt.Errorf("轮换 %s.%s (ID: %v) 失败: %v", table, column, row["id"], err)
+			}
+			if changed {
+				updates[column] = rewrapped
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := db.Table(table).Where("id = ?", row["id"]).UpdateColumns(updates).Error; err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// toString 将数据库返回的列值转换为字符串
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case []byte:
+		return string(value)
+	case string:
+		return value
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+)
+
+// newMailConfig 根据账户创建邮件客户端配置
+func newMailConfig(account models.MailAccount, tokenSource func() (string, error)) mail.Config {
+	return mail.Config{
+		AccountID:    account.ID,
+		Provider:     "mail", // 统一使用 mail 类型
+		Address:      account.Address,
+		Username:     account.Username,
+		Password:     account.Password,
+		Server:       account.Server,
+		IMAPSecurity: account.IMAPSecurity,
+		Settings:     account.Settings,
+		TLS: mail.TLSPolicy{
+			Mode:         account.TLSMode,
+			CACert:       account.TLSCACert,
+			Fingerprints: account.TLSFingerprint,
+		},
+
+		SMTPHost:     account.SMTPHost,
+		SMTPPort:     account.SMTPPort,
+		SMTPSecurity: account.SMTPSecurity,
+		SMTPAuth:     account.SMTPAuth,
+		SMTPUsername: account.SMTPUsername,
+		SMTPPassword: account.SMTPPassword,
+
+		FromMode:     account.FromMode,
+		EnvelopeMode: account.EnvelopeMode,
+
+		TokenSource: tokenSource,
+	}
+}
+
+// CheckAccount 测试账户的IMAP登录、文件夹列表和SMTP认证，账户可以尚未保存
+// OAuth2 账户的令牌保存在数据库中，只能在保存并完成授权后测试
+func CheckAccount(account models.MailAccount, oauthService *OAuthService) (mail.CheckResult, error) {
+	if account.AuthType == models.AuthTypeOAuth2 && (account.ID == 0 || account.OAuthRefreshToken == "") {
+		return mail.CheckResult{}, errors.New("OAuth2账户需要先保存并完成授权才能测试连接")
+	}
+	return mail.CheckConnection(newMailConfig(account, oauthService.TokenSource(account))), nil
+}
+
+// ListAccountFolders 列出账户可以监控的文件夹，包括其他用户和共享命名空间中的文件夹
+func ListAccountFolders(account models.MailAccount, oauthService *OAuthService) ([]mail.Folder, error) {
+	if account.AuthType == models.AuthTypeOAuth2 && account.OAuthRefreshToken == "" {
+		return nil, errors.New("OAuth2账户需要先完成授权才能列出文件夹")
+	}
+
+	mailClient := mail.NewMailClient(nil)
+	if err := mailClient.Init(newMailConfig(account, oauthService.TokenSource(account))); err != nil {
+		return nil, err
+	}
+	defer mailClient.Stop()
+
+	return mailClient.ListFolders()
+}
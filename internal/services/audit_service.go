@@ -0,0 +1,206 @@
+package services
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// auditSkipFields 不记录到审计日志的字段
+var auditSkipFields = map[string]bool{
+	"CreatedAt": true,
+	"UpdatedAt": true,
+	"DeletedAt": true,
+}
+
+// AuditFilter 审计日志查询条件，零值表示不限
+type AuditFilter struct {
+	Entity    string
+	EntityID  uint
+	Action    string
+	Actor     string
+	StartDate *time.Time
+	EndDate   *time.Time
+}
+
+// AuditService 审计日志服务
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService 创建审计日志服务
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record 写入审计事件，失败时只记录日志，不影响请求本身
+func (s *AuditService) Record(event models.AuditEvent) {
+	if err := s.db.Create(&event).Error; err != nil {
+		log.Printf("写入审计日志失败 (%s): %v", event.Action, err)
+	}
+}
+
+// Query 按条件查询审计日志，按时间倒序排列，同时返回符合条件的总数
+func (s *AuditService) Query(filter AuditFilter, limit, offset int) ([]models.AuditEvent, int64, error) {
+	query := s.db.Model(&models.AuditEvent{})
+	if filter.Entity != "" {
+		query = query.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.StartDate != nil {
+		query = query.Where("created_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("created_at < ?", *filter.EndDate)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.AuditEvent
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error
+	return events, total, err
+}
+
+// DiffEntities 比较同一实体变更前后的字段，before 为 nil 表示创建，after 为 nil 表示删除
+// 加密存储的凭据只记录是否变化，不记录值
+func DiffEntities(before, after interface{}) map[string]models.AuditChange {
+	beforeFields, secrets := auditFields(before)
+	afterFields, afterSecrets := auditFields(after)
+	for name := range afterSecrets {
+		secrets[name] = true
+	}
+
+	names := make(map[string]bool)
+	for name := range beforeFields {
+		names[name] = true
+	}
+	for name := range afterFields {
+		names[name] = true
+	}
+
+	changes := make(map[string]models.AuditChange)
+	for name := range names {
+		b, hasBefore := beforeFields[name]
+		a, hasAfter := afterFields[name]
+		if hasBefore && hasAfter && reflect.DeepEqual(b, a) {
+			continue
+		}
+		// 创建和删除时只记录有值的字段
+		if (!hasBefore && isZeroValue(a)) || (!hasAfter && isZeroValue(b)) {
+			continue
+		}
+
+		change := models.AuditChange{Before: b, After: a}
+		if secrets[name] {
+			change = models.AuditChange{Before: redactValue(b), After: redactValue(a)}
+		}
+		changes[name] = change
+	}
+
+	return changes
+}
+
+// auditFields 提取实体中需要审计的字段，关联的实体只记录ID
+func auditFields(entity interface{}) (map[string]interface{}, map[string]bool) {
+	fields := make(map[string]interface{})
+	secrets := make(map[string]bool)
+	if entity == nil {
+		return fields, secrets
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if v.Kind() != reflect.Struct {
+		return fields, secrets
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || auditSkipFields[field.Name] || field.Tag.Get("audit") == "-" || field.Tag.Get("gorm") == "-" {
+			continue
+		}
+		if strings.Contains(field.Tag.Get("gorm"), "serializer:secret") {
+			secrets[field.Name] = true
+		}
+
+		value, ok := auditValue(v.Field(i))
+		if ok {
+			fields[field.Name] = value
+		}
+	}
+
+	return fields, secrets
+}
+
+// auditValue 将字段转换为便于比较和序列化的值，不支持的类型返回 false
+func auditValue(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, true
+		}
+		return auditValue(v.Elem())
+	case reflect.Struct:
+		// 时间以外的嵌套结构体是关联的实体，不记录
+		if t, ok := v.Interface().(time.Time); ok {
+			return t, true
+		}
+		return nil, false
+	case reflect.Slice:
+		elem := v.Type().Elem()
+		if elem.Kind() != reflect.Struct {
+			return v.Interface(), true
+		}
+		// 没有ID的结构体不是关联实体，按原值记录
+		if _, ok := elem.FieldByName("ID"); !ok {
+			return v.Interface(), true
+		}
+		// 关联的实体列表只记录ID
+		ids := make([]uint, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			id := v.Index(i).FieldByName("ID")
+			if !id.IsValid() || id.Kind() != reflect.Uint {
+				return nil, false
+			}
+			ids = append(ids, uint(id.Uint()))
+		}
+		return ids, true
+	}
+	return v.Interface(), true
+}
+
+// isZeroValue 判断字段值是否为空
+func isZeroValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// redactValue 隐藏凭据，空值保持为空以区分设置和清除
+func redactValue(value interface{}) interface{} {
+	if isZeroValue(value) {
+		return nil
+	}
+	return models.RedactedValue
+}
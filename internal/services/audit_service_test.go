@@ -0,0 +1,102 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestDiffEntities(t *testing.T) {
+	account := models.MailAccount{ID: 1, Address: "user@example.com", Username: "user", Password: "secret", IsActive: true}
+	changed := account
+	changed.Password = "new-secret"
+	changed.IsActive = false
+
+	cleared := account
+	cleared.Password = ""
+
+	group := models.TargetGroup{ID: 2, Name: "ops", Targets: []models.ForwardTarget{{ID: 3}}}
+	regrouped := group
+	regrouped.Targets = []models.ForwardTarget{{ID: 3}, {ID: 4}}
+	regrouped.TargetIDs = []uint{3, 4}
+
+	withActions := account
+	withActions.DispatchedActions = []models.MailboxAction{{Type: models.MailboxActionMove, Target: "Dispatched"}}
+
+	key := models.APIKey{ID: 5, Name: "ci", KeyHash: "hash", Role: models.RoleOperator}
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   map[string]models.AuditChange
+	}{
+		{
+			name:  "创建只记录有值的字段",
+			after: models.ForwardTarget{ID: 1, Name: "team", Email: "team@example.com"},
+			want: map[string]models.AuditChange{
+				"ID":    {After: uint(1)},
+				"Name":  {After: "team"},
+				"Email": {After: "team@example.com"},
+			},
+		},
+		{
+			name:   "修改凭据只记录占位符",
+			before: account,
+			after:  changed,
+			want: map[string]models.AuditChange{
+				"Password": {Before: models.RedactedValue, After: models.RedactedValue},
+				"IsActive": {Before: true, After: false},
+			},
+		},
+		{
+			name:   "清除凭据",
+			before: account,
+			after:  cleared,
+			want: map[string]models.AuditChange{
+				"Password": {Before: models.RedactedValue, After: nil},
+			},
+		},
+		{
+			name:   "关联只记录ID",
+			before: group,
+			after:  regrouped,
+			want: map[string]models.AuditChange{
+				"Targets": {Before: []uint{3}, After: []uint{3, 4}},
+			},
+		},
+		{
+			name:   "没有ID的结构体列表记录原值",
+			before: account,
+			after:  withActions,
+			want: map[string]models.AuditChange{
+				"DispatchedActions": {Before: []models.MailboxAction(nil), After: []models.MailboxAction{{Type: models.MailboxActionMove, Target: "Dispatched"}}},
+			},
+		},
+		{
+			name:   "删除时不记录密钥哈希",
+			before: key,
+			want: map[string]models.AuditChange{
+				"ID":   {Before: uint(5)},
+				"Name": {Before: "ci"},
+				"Role": {Before: models.RoleOperator},
+			},
+		},
+		{
+			name:   "没有变化",
+			before: account,
+			after:  account,
+			want:   map[string]models.AuditChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffEntities(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffEntities() = %#v, 期望 %#v", got, tt.want)
+			}
+		})
+	}
+}
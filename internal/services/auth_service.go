@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefix API密钥的固定前缀，便于在日志和代码中识别泄露的密钥
+const apiKeyPrefix = "mdk_"
+
+// lastUsedInterval 最近使用时间的更新间隔，避免每个请求都写数据库
+const lastUsedInterval = time.Minute
+
+// ErrInvalidCredential 密钥或令牌无效、已过期或已停用
+var ErrInvalidCredential = errors.New("认证信息无效或已过期")
+
+// roleLevels 角色的权限等级
+var roleLevels = map[string]int{
+	models.RoleReadOnly: 1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+// Principal 通过认证的调用方
+type Principal struct {
+	Name  string // API密钥为 "key:<名称>"，JWT为 "jwt:<sub>"
+	Role  string
+	KeyID uint
+}
+
+// IsValidRole 校验角色
+func IsValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// RoleAllows 判断角色是否具有 required 角色的权限
+func RoleAllows(role, required string) bool {
+	return IsValidRole(role) && roleLevels[role] >= roleLevels[required]
+}
+
+// AuthService API认证服务
+type AuthService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+// NewAuthService 创建API认证服务
+func NewAuthService(db *gorm.DB, cfg *config.Config) *AuthService {
+	return &AuthService{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// Enabled 是否启用API认证
+func (s *AuthService) Enabled() bool {
+	return s.config.Auth.Enabled
+}
+
+// CreateKey 创建API密钥，返回的明文密钥只在创建时可见
+func (s *AuthService) CreateKey(name, role string, expiresAt *time.Time) (models.APIKey, string, error) {
+	if !IsValidRole(role) {
+		return models.APIKey{}, "", fmt.Errorf("角色只能为 admin、operator 或 readonly")
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return models.APIKey{}, "", fmt.Errorf("生成密钥失败: %v", err)
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(b)
+
+	key := models.APIKey{
+		Name:      name,
+		Prefix:    plaintext[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(plaintext),
+		Role:      role,
+		IsActive:  true,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return models.APIKey{}, "", fmt.Errorf("保存密钥失败: %v", err)
+	}
+
+	return key, plaintext, nil
+}
+
+// EnsureBootstrapKey 没有任何API密钥时创建一个管理员密钥，返回明文；已有密钥时返回空字符串
+func (s *AuthService) EnsureBootstrapKey() (string, error) {
+	var count int64
+	if err := s.db.Model(&models.APIKey{}).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "", nil
+	}
+
+	_, plaintext, err := s.CreateKey("bootstrap", models.RoleAdmin, nil)
+	return plaintext, err
+}
+
+// Authenticate 校验API密钥或JWT
+func (s *AuthService) Authenticate(token string) (Principal, error) {
+	if strings.Count(token, ".") == 2 {
+		if s.config.Auth.JWTSecret == "" {
+			return Principal{}, errors.New("未启用JWT认证")
+		}
+		return parseJWT(token, []byte(s.config.Auth.JWTSecret), time.Now())
+	}
+
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return Principal{}, ErrInvalidCredential
+	}
+
+	var key models.APIKey
+	if err := s.db.Where("key_hash = ? AND is_active = ?", hashAPIKey(token), true).First(&key).Error; err != nil {
+		return Principal{}, ErrInvalidCredential
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+		return Principal{}, ErrInvalidCredential
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval {
+		s.db.Model(&key).UpdateColumn("last_used_at", now)
+	}
+
+	return Principal{Name: "key:" + key.Name, Role: key.Role, KeyID: key.ID}, nil
+}
+
+// hashAPIKey 计算密钥的哈希，密钥本身有足够的随机性，不需要加盐
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// jwtClaims 支持的JWT声明
+type jwtClaims struct {
+	Sub  string `json:"sub"`
+	Role string `json:"role"`
+	Exp  int64  `json:"exp"`
+	Nbf  int64  `json:"nbf"`
+}
+
+// parseJWT 校验 HS256 签名的JWT，令牌必须包含 sub、role 和 exp
+func parseJWT(token string, secret []byte, now time.Time) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, ErrInvalidCredential
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return Principal{}, errors.New("JWT签名算法必须为HS256")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return Principal{}, errors.New("JWT签名无效")
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Principal{}, ErrInvalidCredential
+	}
+	if claims.Exp == 0 || now.Unix() >= claims.Exp || (claims.Nbf != 0 && now.Unix() < claims.Nbf) {
+		return Principal{}, errors.New("JWT已过期或尚未生效")
+	}
+	if claims.Sub == "" || !IsValidRole(claims.Role) {
+		return Principal{}, errors.New("JWT缺少 sub 或 role 无效")
+	}
+
+	return Principal{Name: "jwt:" + claims.Sub, Role: claims.Role}, nil
+}
+
+// decodeJWTPart 解码JWT的头部或载荷
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
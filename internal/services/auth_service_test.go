@@ -0,0 +1,76 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/models"
+)
+
+// signJWT 生成测试用的JWT
+func signJWT(header, payload string, secret []byte) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Unix(1700000000, 0)
+	header := `{"alg":"HS256","typ":"JWT"}`
+
+	tests := []struct {
+		name     string
+		token    string
+		wantErr  bool
+		wantRole string
+	}{
+		{"有效令牌", signJWT(header, `{"sub":"ci","role":"operator","exp":1700000600}`, secret), false, models.RoleOperator},
+		{"已过期", signJWT(header, `{"sub":"ci","role":"operator","exp":1699999999}`, secret), true, ""},
+		{"缺少过期时间", signJWT(header, `{"sub":"ci","role":"operator"}`, secret), true, ""},
+		{"尚未生效", signJWT(header, `{"sub":"ci","role":"admin","exp":1700000600,"nbf":1700000300}`, secret), true, ""},
+		{"未知角色", signJWT(header, `{"sub":"ci","role":"root","exp":1700000600}`, secret), true, ""},
+		{"签名密钥错误", signJWT(header, `{"sub":"ci","role":"admin","exp":1700000600}`, []byte("other")), true, ""},
+		{"不接受none算法", signJWT(`{"alg":"none"}`, `{"sub":"ci","role":"admin","exp":1700000600}`, secret), true, ""},
+		{"格式错误", "abc.def", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := parseJWT(tt.token, secret, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWT() error = %v, 期望失败: %v", err, tt.wantErr)
+			}
+			if err == nil && (principal.Role != tt.wantRole || principal.Name != "jwt:ci") {
+				t.Errorf("parseJWT() = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		required string
+		want     bool
+	}{
+		{"管理员可以执行操作", models.RoleAdmin, models.RoleOperator, true},
+		{"操作员可以查询", models.RoleOperator, models.RoleReadOnly, true},
+		{"只读不能修改", models.RoleReadOnly, models.RoleOperator, false},
+		{"操作员不能管理密钥", models.RoleOperator, models.RoleAdmin, false},
+		{"未知角色", "guest", models.RoleReadOnly, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RoleAllows(tt.role, tt.required); got != tt.want {
+				t.Errorf("RoleAllows(%s, %s) = %v, 期望 %v", tt.role, tt.required, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// smtpCodeOK 邮件被服务器接受时的响应码，net/smtp 只在收到250时才认为发送成功
+const smtpCodeOK = 250
+
+// maxRetryDelay 投递重试的最大间隔
+const maxRetryDelay = 6 * time.Hour
+
+// dispatchInterval 扫描到期队列的间隔
+const dispatchInterval = 5 * time.Second
+
+// DeliveryService 投递服务，从持久化队列中取出邮件发送，失败后按指数退避重试
+type DeliveryService struct {
+	db            *gorm.DB
+	senderService *SenderService
+	config        *config.Config
+	stopChan      chan struct{}
+	wake          chan struct{}
+	jobs          chan uint
+	wg            sync.WaitGroup
+}
+
+// NewDeliveryService 创建投递服务
+func NewDeliveryService(db *gorm.DB, senderService *SenderService, cfg *config.Config) *DeliveryService {
+	return &DeliveryService{
+		db:            db,
+		senderService: senderService,
+		config:        cfg,
+		stopChan:      make(chan struct{}),
+		wake:          make(chan struct{}, 1),
+		jobs:          make(chan uint),
+	}
+}
+
+// Start 启动投递服务
+func (s *DeliveryService) Start() {
+	// 上次退出时正在投递的邮件无法确认结果，重新放回队列
+	if err := s.db.Model(&models.OutboundMessage{}).Where("status = ?", models.OutboundSending).
+		Update("status", models.OutboundPending).Error; err != nil {
+		log.Printf("恢复投递中的邮件失败: %v", err)
+	}
+
+	workers := s.config.Mail.DeliveryWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+
+	s.wg.Add(1)
+	go s.dispatchLoop()
+
+	log.Printf("投递服务已启动 (并发数: %d)", workers)
+}
+
+// Stop 停止投递服务，等待正在进行的投递完成
+func (s *DeliveryService) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("投递服务已停止")
+}
+
+// Enqueue 将邮件加入投递队列，同时创建状态为 queued 的邮件日志
+// retryOfID 为手动重试时的来源日志ID，首次处理传0
+func (s *DeliveryService) Enqueue(email models.Email, accountID uint, rcpt recipient, retryOfID uint) error {
+	maxAttempts := s.config.Mail.MaxRetryCount + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		mailLog := models.MailLog{
+			AccountID:   accountID,
+			MessageID:   email.MessageID,
+			Subject:     email.Subject,
+			RawSubject:  email.RawSubject,
+			From:        email.From,
+			FromName:    email.FromName,
+			RawFrom:     email.RawFrom,
+			To:          email.To,
+			UID:         email.UID,
+			UIDValidity: email.UIDValidity,
+			Folder:      email.Folder,
+			ReceivedAt:  email.ReceivedAt,
+			ForwardTo:   rcpt.Address,
+			Status:      "queued",
+			RetryOfID:   retryOfID,
+		}
+		// 首次处理的日志带有去重键，同一封邮件发给同一收件人只能入队一次
+		if retryOfID == 0 {
+			key := dedupKey(email)
+			mailLog.DedupKey = &key
+		}
+		if err := tx.Create(&mailLog).Error; err != nil {
+			return err
+		}
+
+		outbound := models.OutboundMessage{
+			AccountID:     accountID,
+			MailLogID:     mailLog.ID,
+			MessageID:     email.MessageID,
+			Subject:       email.Subject,
+			ToAddress:     rcpt.Address,
+			MaxSize:       rcpt.MaxSize,
+			ForwardMode:   rcpt.ForwardMode,
+			Email:         email,
+			Status:        models.OutboundPending,
+			MaxAttempts:   maxAttempts,
+			NextAttemptAt: time.Now(),
+		}
+		return tx.Create(&outbound).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// notify 唤醒调度循环立即扫描队列
+func (s *DeliveryService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchLoop 定时扫描到期的队列项并分发给工作协程
+func (s *DeliveryService) dispatchLoop() {
+	defer s.wg.Done()
+	defer close(s.jobs)
+
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		if !s.dispatch() {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// dispatch 认领到期的队列项，服务停止时返回 false
+func (s *DeliveryService) dispatch() bool {
+	var ids []uint
+	if err := s.db.Model(&models.OutboundMessage{}).
+		Where("status = ? AND next_attempt_at <= ?", models.OutboundPending, time.Now()).
+		Order("next_attempt_at ASC").Limit(100).Pluck("id", &ids).Error; err != nil {
+		log.Printf("查询投递队列失败: %v", err)
+		return true
+	}
+
+	for _, id := range ids {
+		// 通过条件更新认领，避免同一邮件被重复投递
+		result := s.db.Model(&models.OutboundMessage{}).
+			Where("id = ? AND status = ?", id, models.OutboundPending).
+			Update("status", models.OutboundSending)
+		if result.Error != nil {
+			log.Printf("认领投递任务失败 (ID: %d): %v", id, result.Error)
+			continue
+		}
+		if result.RowsAffected != 1 {
+			continue
+		}
+
+		select {
+		case s.jobs <- id:
+		case <-s.stopChan:
+			s.db.Model(&models.OutboundMessage{}).Where("id = ?", id).Update("status", models.OutboundPending)
+			return false
+		}
+	}
+
+	return true
+}
+
+// worker 投递工作协程
+func (s *DeliveryService) worker() {
+	defer s.wg.Done()
+	for id := range s.jobs {
+		s.deliver(id)
+	}
+}
+
+// deliver 投递一封邮件并记录本次尝试
+func (s *DeliveryService) deliver(id uint) {
+	var outbound models.OutboundMessage
+	if err := s.db.First(&outbound, id).Error; err != nil {
+		log.Printf("加载投递任务失败 (ID: %d): %v", id, err)
+		return
+	}
+
+	attempt := outbound.Attempts + 1
+	dropped, err := s.senderService.SendEmail(outbound.Email, outbound.ToAddress, outbound.AccountID, outbound.MaxSize, outbound.ForwardMode)
+
+	record := models.DeliveryAttempt{
+		OutboundID: outbound.ID,
+		Attempt:    attempt,
+		Status:     "sent",
+		SMTPCode:   smtpCodeOK,
+	}
+	if err != nil {
+		record.Status = "failed"
+		record.SMTPCode = mail.SMTPCode(err)
+		record.Error = err.Error()
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		log.Printf("记录投递尝试失败 (ID: %d): %v", id, err)
+	}
+
+	if err == nil {
+		s.markSent(outbound, attempt, dropped)
+		return
+	}
+	s.markFailed(outbound, attempt, record.SMTPCode, err)
+}
+
+// markSent 标记投递成功并更新邮件日志
+func (s *DeliveryService) markSent(outbound models.OutboundMessage, attempt int, dropped []mail.Attachment) {
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&outbound).Updates(map[string]interface{}{
+			"status":     models.OutboundSent,
+			"attempts":   attempt,
+			"last_code":  smtpCodeOK,
+			"last_error": "",
+			"sent_at":    &now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.MailLog{}).Where("id = ?", outbound.MailLogID).Updates(map[string]interface{}{
+			"status":       "forwarded",
+			"error":        "",
+			"dropped":      mail.FormatAttachments(dropped),
+			"forwarded_at": &now,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("更新投递状态失败 (ID: %d): %v", outbound.ID, err)
+	}
+}
+
+// markFailed 记录投递失败，被拒收或达到最大尝试次数时转入死信，否则安排重试
+func (s *DeliveryService) markFailed(outbound models.OutboundMessage, attempt, code int, sendErr error) {
+	updates := map[string]interface{}{
+		"attempts":   attempt,
+		"last_code":  code,
+		"last_error": sendErr.Error(),
+	}
+	logUpdates := map[string]interface{}{
+		"error": "转发失败: " + sendErr.Error(),
+	}
+
+	// TLS握手失败单独归类，便于与网络错误和拒收区分
+	category := ""
+	if mail.IsTLSError(sendErr) {
+		category = "[TLS] "
+	}
+
+	// 认证失败是账户的问题，修正凭据后仍能投递，按退避间隔一直重试，并记录到账户健康状态
+	accountFailure := isAccountFailure(code, sendErr)
+	if accountFailure {
+		s.recordAccountFailure(outbound.AccountID, sendErr)
+	}
+
+	if !accountFailure && (isPermanentFailure(code) || attempt >= outbound.MaxAttempts) {
+		updates["status"] = models.OutboundDead
+		logUpdates["status"] = "failed"
+		log.Printf("%s邮件投递失败，不再重试 (%s，第 %d 次): %v", category, outbound.ToAddress, attempt, sendErr)
+	} else {
+		delay := retryDelay(time.Duration(s.config.Mail.RetryInterval)*time.Second, attempt)
+		updates["status"] = models.OutboundPending
+		updates["next_attempt_at"] = time.Now().Add(delay)
+		log.Printf("%s邮件投递失败，%v 后重试 (%s，第 %d 次): %v", category, delay, outbound.ToAddress, attempt, sendErr)
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&outbound).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.MailLog{}).Where("id = ?", outbound.MailLogID).Updates(logUpdates).Error
+	})
+	if err != nil {
+		log.Printf("更新投递状态失败 (ID: %d): %v", outbound.ID, err)
+	}
+}
+
+// retryDelay 计算第 attempt 次失败后的重试间隔，每次翻倍，不超过 maxRetryDelay
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = time.Minute
+	}
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// recordAccountFailure 将SMTP认证失败记录到账户的健康状态，不计入自动停用的认证失败次数
+func (s *DeliveryService) recordAccountFailure(accountID uint, sendErr error) {
+	now := time.Now()
+	// 使用 UpdateColumns 避免刷新 updated_at，已自动停用的账户保持原状态
+	err := s.db.Model(&models.MailAccount{}).Where("id = ?", accountID).UpdateColumns(map[string]interface{}{
+		"last_error":    "SMTP发送失败: " + sendErr.Error(),
+		"last_error_at": now,
+	}).Error
+	if err == nil {
+		err = s.db.Model(&models.MailAccount{}).Where("id = ? AND health_state = ?", accountID, models.HealthHealthy).
+			UpdateColumn("health_state", models.HealthFailing).Error
+	}
+	if err != nil {
+		log.Printf("保存账户健康状态失败 (账户ID: %d): %v", accountID, err)
+	}
+}
+
+// isPermanentFailure 550-553 表示收件人或邮件本身被拒收，重试不会成功；
+// 其他 5xx（如认证失败或 554 策略拒绝）可能在修正配置或稍后重试时成功
+func isPermanentFailure(code int) bool {
+	return code >= 550 && code <= 553
+}
+
+// isAccountFailure 判断是否为账户凭据导致的失败，包括SMTP认证失败响应和访问令牌失效
+func isAccountFailure(code int, err error) bool {
+	switch code {
+	case 530, 534, 535, 538:
+		return true
+	}
+	return mail.IsAuthError(err)
+}
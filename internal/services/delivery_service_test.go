@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/mail"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{"第一次失败", time.Minute, 1, time.Minute},
+		{"第二次失败翻倍", time.Minute, 2, 2 * time.Minute},
+		{"第四次失败", time.Minute, 4, 8 * time.Minute},
+		{"超过上限", time.Minute, 20, maxRetryDelay},
+		{"基础间隔超过上限", 10 * time.Hour, 1, maxRetryDelay},
+		{"未配置间隔", 0, 2, 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDelay(tt.base, tt.attempt); got != tt.want {
+				t.Errorf("retryDelay() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPermanentFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		want bool
+	}{
+		{"收件人不存在", 550, true},
+		{"发件人地址被拒绝", 553, true},
+		{"认证失败", 535, false},
+		{"策略拒绝", 554, false},
+		{"临时错误", 451, false},
+		{"邮箱已满", 452, false},
+		{"未收到响应", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPermanentFailure(tt.code); got != tt.want {
+				t.Errorf("isPermanentFailure(%d) = %v, 期望 %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAccountFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		err  error
+		want bool
+	}{
+		{"SMTP认证失败", 535, errors.New("SMTP认证失败: 535 authentication failed"), true},
+		{"需要认证", 530, errors.New("设置发件人失败: 530 authentication required"), true},
+		{"访问令牌失效", 0, fmt.Errorf("SMTP认证失败: %w", &mail.AuthError{Err: errors.New("invalid_grant")}), true},
+		{"收件人不存在", 550, errors.New("设置收件人失败: 550 no such user"), false},
+		{"连接失败", 0, errors.New("dial tcp: connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAccountFailure(tt.code, tt.err); got != tt.want {
+				t.Errorf("isAccountFailure() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
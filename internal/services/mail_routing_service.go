@@ -1,11 +1,15 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
-	"time"
 
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/mail"
 	"mail-dispatcher/internal/models"
 
 	"gorm.io/gorm"
@@ -13,56 +17,233 @@ import (
 
 // MailRoutingService 邮件路由服务
 type MailRoutingService struct {
-	db            *gorm.DB
-	senderService *SenderService
-	logService    *LogService
+	db              *gorm.DB
+	deliveryService *DeliveryService
+	logService      *LogService
+	config          *config.Config
+	subjects        subjectRegexps
 }
 
 // NewMailRoutingService 创建邮件路由服务
-func NewMailRoutingService(db *gorm.DB, senderService *SenderService, logService *LogService) *MailRoutingService {
+func NewMailRoutingService(db *gorm.DB, deliveryService *DeliveryService, logService *LogService, cfg *config.Config) *MailRoutingService {
 	return &MailRoutingService{
-		db:            db,
-		senderService: senderService,
-		logService:    logService,
+		db:              db,
+		deliveryService: deliveryService,
+		logService:      logService,
+		config:          cfg,
 	}
 }
 
-// UpdateSenderService 更新发送服务引用
-func (s *MailRoutingService) UpdateSenderService(senderService *SenderService) {
-	s.senderService = senderService
+// UpdateDeliveryService 更新投递服务引用
+func (s *MailRoutingService) UpdateDeliveryService(deliveryService *DeliveryService) {
+	s.deliveryService = deliveryService
 }
 
-// ProcessEmail 处理新邮件
-func (s *MailRoutingService) ProcessEmail(email models.Email, accountID uint) error {
-	// 检查是否已处理过
-	var existingLog models.MailLog
-	if err := s.db.Where("message_id = ? AND account_id = ?", email.MessageID, accountID).First(&existingLog).Error; err == nil {
+// 路由结果，决定对原邮件执行转发成功还是路由失败后的操作
+const (
+	RouteDispatched = "dispatched" // 已加入投递队列，或此前已处理过
+	RouteFailed     = "failed"     // 未匹配路由规则，或因转发环路没有任何收件人
+)
+
+// ProcessEmail 处理新邮件，返回路由结果；返回错误时邮件未处理，应在下次收信时重试
+func (s *MailRoutingService) ProcessEmail(email models.Email, accountID uint) (string, error) {
+	// 转发次数超过上限时认为出现了环路
+	if hops := mail.ForwardHops(email); s.config.Mail.MaxHops > 0 && hops >= s.config.Mail.MaxHops {
+		log.Printf("邮件已转发 %d 次，疑似转发环路，停止转发: %s", hops, email.Subject)
+		return RouteFailed, s.logEmail(email, accountID, "", "loop", fmt.Sprintf("已转发 %d 次，超过上限 %d", hops, s.config.Mail.MaxHops))
+	}
+
+	// 按路由规则确定收件人
+	recipients, err := s.resolveRecipients(email)
+	if err != nil {
+		log.Printf("路由邮件失败: %v (主题: '%s')", err, email.Subject)
+		return RouteFailed, s.logEmail(email, accountID, "", "failed", err.Error())
+	}
+
+	// 已经转发或收到过该邮件的地址不再转发，避免两个监控账户之间来回转发
+	recipients, looped := excludeForwarded(recipients, mail.ForwardedAddresses(email))
+	for _, rcpt := range looped {
+		log.Printf("收件人 %s 已转发或收到过该邮件，跳过: %s", rcpt.Address, email.Subject)
+		if err := s.logEmail(email, accountID, rcpt.Address, "loop", "收件人已转发或收到过该邮件"); err != nil {
+			return "", err
+		}
+	}
+	if len(recipients) == 0 {
+		return RouteFailed, nil
+	}
+
+	// 每个收件人单独入队，由投递服务负责发送和重试；中途失败时下次收信只补齐尚未入队的收件人。
+	// 按 Message-ID 和内容去重，同一封邮件在多个账户中或 UIDVALIDITY 重置后再次收到时，
+	// 已入队的收件人由 (dedup_key, forward_to) 唯一索引排除。路由失败和环路的记录不参与去重
+	queued := 0
+	for _, rcpt := range recipients {
+		if err := s.deliveryService.Enqueue(email, accountID, rcpt, 0); err != nil {
+			// 此前已入队，或另一个账户同时收到了同一封邮件，已由对方入队
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				continue
+			}
+			return "", fmt.Errorf("加入投递队列失败 (%s): %v", rcpt.Address, err)
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		log.Printf("邮件已加入投递队列: %s (收件人 %d 个)", email.Subject, queued)
+	} else {
 		log.Printf("邮件已处理过，跳过: %s", email.MessageID)
-		return nil
 	}
+	return RouteDispatched, nil
+}
 
+// dedupKey 根据 Message-ID 和内容摘要计算去重键
+func dedupKey(email models.Email) string {
+	sum := sha256.Sum256([]byte(email.MessageID + "\n" + email.ContentHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// excludeForwarded 拆分出已经出现在转发链中的收件人
+func excludeForwarded(recipients []recipient, forwarded []string) (kept, looped []recipient) {
+	seen := make(map[string]bool, len(forwarded))
+	for _, address := range forwarded {
+		seen[address] = true
+	}
+	for _, rcpt := range recipients {
+		if seen[strings.ToLower(strings.TrimSpace(rcpt.Address))] {
+			looped = append(looped, rcpt)
+		} else {
+			kept = append(kept, rcpt)
+		}
+	}
+	return kept, looped
+}
+
+// recipient 转发收件人
+type recipient struct {
+	Address     string
+	MaxSize     int64
+	ForwardMode string
+}
+
+// recipientSet 按地址去重的收件人列表
+type recipientSet struct {
+	list []recipient
+	seen map[string]bool
+}
+
+// add 添加收件人，重复地址会被忽略
+func (r *recipientSet) add(rcpt recipient) {
+	key := strings.ToLower(strings.TrimSpace(rcpt.Address))
+	if key == "" || r.seen[key] {
+		return
+	}
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	r.seen[key] = true
+	r.list = append(r.list, rcpt)
+}
+
+// addTargets 添加转发目标
+func (r *recipientSet) addTargets(targets []models.ForwardTarget) {
+	for _, target := range targets {
+		r.add(recipient{Address: target.Email, MaxSize: target.MaxSize, ForwardMode: target.ForwardMode})
+	}
+}
+
+// addGroups 展开转发分组中的目标和邮箱地址
+func (r *recipientSet) addGroups(groups []models.TargetGroup) {
+	for _, group := range groups {
+		r.addTargets(group.Targets)
+		for _, address := range group.Addresses {
+			r.add(recipient{Address: address})
+		}
+	}
+}
+
+// resolveRecipients 按优先级依次评估启用的路由规则，汇总去重后的收件人
+// 没有配置任何规则时使用内置的主题格式规则
+func (s *MailRoutingService) resolveRecipients(email models.Email) ([]recipient, error) {
+	var rules []models.RoutingRule
+	if err := s.db.Preload("Targets").Preload("Groups.Targets").Where("is_active = ?", true).
+		Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("加载路由规则失败: %v", err)
+	}
+	s.subjects.retain(rules)
+
+	var recipients recipientSet
+
+	if len(rules) == 0 {
+		if err := s.resolveSubjectFormat(email, &recipients); err != nil {
+			return nil, err
+		}
+		return recipients.list, nil
+	}
+
+	var lastErr error
+
+	for _, rule := range rules {
+		matched, err := matchRule(rule, email, &s.subjects)
+		if err != nil {
+			log.Printf("评估路由规则失败: %v", err)
+			lastErr = err
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Type == models.RuleTypeSubjectFormat {
+			if err := s.resolveSubjectFormat(email, &recipients); err != nil {
+				lastErr = err
+				continue
+			}
+		} else {
+			recipients.addTargets(rule.Targets)
+			recipients.addGroups(rule.Groups)
+		}
+
+		if !rule.Continue {
+			break
+		}
+	}
+
+	if len(recipients.list) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("未匹配任何路由规则")
+	}
+
+	return recipients.list, nil
+}
+
+// resolveSubjectFormat 按 "关键字 - 转发对象名称" 主题格式查找转发目标或转发分组
+func (s *MailRoutingService) resolveSubjectFormat(email models.Email, recipients *recipientSet) error {
 	// 解析邮件主题
 	_, targetName, err := s.parseSubject(email.Subject)
 	if err != nil {
-		log.Printf("解析邮件主题失败: %v (主题: '%s')", err, email.Subject)
-		return s.logFailedEmail(email, accountID, "解析主题失败: "+err.Error())
+		return fmt.Errorf("解析主题失败: %v", err)
 	}
 
 	// 查找转发目标
 	var target models.ForwardTarget
-	if err := s.db.Where("name = ?", targetName).First(&target).Error; err != nil {
-		log.Printf("未找到匹配的转发目标: %s", targetName)
-		return s.logFailedEmail(email, accountID, "未找到匹配的转发目标: "+targetName)
+	if err := s.db.Where("name = ?", targetName).First(&target).Error; err == nil {
+		recipients.addTargets([]models.ForwardTarget{target})
+		return nil
+	}
+
+	// 查找同名的转发分组
+	var group models.TargetGroup
+	if err := s.db.Preload("Targets").Where("name = ?", targetName).First(&group).Error; err != nil {
+		return fmt.Errorf("未找到匹配的转发目标: %s", targetName)
 	}
 
-	// 使用发送服务发送邮件
-	if err := s.senderService.SendEmail(email, target.Email, accountID); err != nil {
-		log.Printf("转发邮件失败: %v", err)
-		return s.logFailedEmail(email, accountID, "转发失败: "+err.Error())
+	if len(group.Targets) == 0 && len(group.Addresses) == 0 {
+		return fmt.Errorf("转发分组没有成员: %s", targetName)
 	}
+	recipients.addGroups([]models.TargetGroup{group})
 
-	// 记录成功日志
-	return s.logSuccessfulEmail(email, accountID, target.Email)
+	return nil
 }
 
 // parseSubject 解析邮件主题
@@ -83,35 +264,24 @@ func (s *MailRoutingService) parseSubject(subject string) (keyword, targetName s
 	return keyword, targetName, nil
 }
 
-// logSuccessfulEmail 记录成功转发的邮件
-func (s *MailRoutingService) logSuccessfulEmail(email models.Email, accountID uint, forwardTo string) error {
-	now := time.Now()
+// logEmail 记录没有进入投递队列的邮件，status 为 failed 或 loop；不设置去重键，不影响之后重新路由
+func (s *MailRoutingService) logEmail(email models.Email, accountID uint, forwardTo, status, errorMsg string) error {
 	log := models.MailLog{
 		AccountID:   accountID,
 		MessageID:   email.MessageID,
 		Subject:     email.Subject,
+		RawSubject:  email.RawSubject,
 		From:        email.From,
+		FromName:    email.FromName,
+		RawFrom:     email.RawFrom,
 		To:          email.To,
+		UID:         email.UID,
+		UIDValidity: email.UIDValidity,
+		Folder:      email.Folder,
 		ReceivedAt:  email.ReceivedAt,
 		ForwardTo:   forwardTo,
-		Status:      "forwarded",
-		ForwardedAt: &now,
-	}
-
-	return s.db.Create(&log).Error
-}
-
-// logFailedEmail 记录失败的邮件
-func (s *MailRoutingService) logFailedEmail(email models.Email, accountID uint, errorMsg string) error {
-	log := models.MailLog{
-		AccountID:  accountID,
-		MessageID:  email.MessageID,
-		Subject:    email.Subject,
-		From:       email.From,
-		To:         email.To,
-		ReceivedAt: email.ReceivedAt,
-		Status:     "failed",
-		Error:      errorMsg,
+		Status:      status,
+		Error:       errorMsg,
 	}
 
 	return s.db.Create(&log).Error
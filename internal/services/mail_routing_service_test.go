@@ -2,6 +2,8 @@ package services
 
 import (
 	"testing"
+
+	"mail-dispatcher/internal/models"
 )
 
 func TestParseSubject(t *testing.T) {
@@ -77,3 +79,62 @@ func TestParseSubject(t *testing.T) {
 		})
 	}
 }
+
+func TestRecipientSet(t *testing.T) {
+	var recipients recipientSet
+	recipients.addTargets([]models.ForwardTarget{{Email: "zhangsan@example.com", MaxSize: 1024, ForwardMode: models.ForwardModeWrap}})
+	recipients.addGroups([]models.TargetGroup{{
+		Targets:   []models.ForwardTarget{{Email: "ZhangSan@example.com"}, {Email: "lisi@example.com"}},
+		Addresses: []string{"oncall@example.com", "lisi@example.com"},
+	}})
+
+	want := []recipient{
+		{Address: "zhangsan@example.com", MaxSize: 1024, ForwardMode: models.ForwardModeWrap},
+		{Address: "lisi@example.com"},
+		{Address: "oncall@example.com"},
+	}
+	if len(recipients.list) != len(want) {
+		t.Fatalf("recipients = %+v, want %+v", recipients.list, want)
+	}
+	for i := range want {
+		if recipients.list[i] != want[i] {
+			t.Errorf("recipients[%d] = %+v, want %+v", i, recipients.list[i], want[i])
+		}
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	base := models.Email{MessageID: "abc@example.com", ContentHash: "hash", UID: 1}
+
+	sameMessage := base
+	sameMessage.UID = 99
+	if dedupKey(base) != dedupKey(sameMessage) {
+		t.Error("UID不同的同一封邮件去重键应相同")
+	}
+
+	reusedID := base
+	reusedID.ContentHash = "other"
+	if dedupKey(base) == dedupKey(reusedID) {
+		t.Error("Message-ID相同但内容不同时去重键应不同")
+	}
+
+	if len(dedupKey(base)) != 64 {
+		t.Errorf("去重键长度 = %d, 期望 64", len(dedupKey(base)))
+	}
+}
+
+func TestExcludeForwarded(t *testing.T) {
+	recipients := []recipient{
+		{Address: "A@example.com"},
+		{Address: "b@example.com"},
+		{Address: "c@example.com"},
+	}
+
+	kept, looped := excludeForwarded(recipients, []string{"a@example.com", "c@example.com"})
+	if len(kept) != 1 || kept[0].Address != "b@example.com" {
+		t.Errorf("kept = %v, 期望只有 b@example.com", kept)
+	}
+	if len(looped) != 2 || looped[0].Address != "A@example.com" || looped[1].Address != "c@example.com" {
+		t.Errorf("looped = %v, 期望 A@example.com 和 c@example.com", looped)
+	}
+}
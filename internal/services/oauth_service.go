@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// oauthStateTTL 授权请求的有效期
+const oauthStateTTL = 10 * time.Minute
+
+// tokenRefreshMargin 访问令牌剩余有效期不足该值时提前刷新，避免在IMAP会话中途过期
+const tokenRefreshMargin = 2 * time.Minute
+
+// oauthProviders 各提供方的授权地址和邮件权限
+var oauthProviders = map[string]struct {
+	authURL  string
+	tokenURL string
+	scopes   []string
+}{
+	models.OAuthProviderGoogle: {
+		authURL:  "https://accounts.google.com/o/oauth2/auth",
+		tokenURL: "https://oauth2.googleapis.com/token",
+		scopes:   []string{"https://mail.google.com/"},
+	},
+	models.OAuthProviderMicrosoft: {
+		authURL:  "https://login.microsoftonline.com/%s/oauth2/v2.0/authorize",
+		tokenURL: "https://login.microsoftonline.com/%s/oauth2/v2.0/token",
+		scopes: []string{
+			"offline_access",
+			"https://outlook.office.com/IMAP.AccessAsUser.All",
+			"https://outlook.office.com/SMTP.Send",
+		},
+	},
+}
+
+// OAuthService OAuth2 授权服务，负责关联账户和刷新访问令牌
+type OAuthService struct {
+	db     *gorm.DB
+	config *config.Config
+	mu     sync.Mutex
+	locks  map[uint]*sync.Mutex // 每个账户的令牌锁，由 mu 保护
+}
+
+// NewOAuthService 创建OAuth2授权服务
+func NewOAuthService(db *gorm.DB, cfg *config.Config) *OAuthService {
+	return &OAuthService{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// InferOAuthProvider 根据IMAP服务器推测OAuth2提供方，无法推测时返回空字符串
+func InferOAuthProvider(server string) string {
+	server = strings.ToLower(server)
+	switch {
+	case strings.Contains(server, "gmail.com"), strings.Contains(server, "googlemail.com"):
+		return models.OAuthProviderGoogle
+	case strings.Contains(server, "office365.com"), strings.Contains(server, "outlook.com"):
+		return models.OAuthProviderMicrosoft
+	}
+	return ""
+}
+
+// oauthConfig 返回提供方的OAuth2应用配置
+func (s *OAuthService) oauthConfig(provider string) (*oauth2.Config, error) {
+	endpoint, ok := oauthProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("不支持的OAuth2提供方: %s", provider)
+	}
+
+	cfg := s.config.OAuth
+	clientID, clientSecret := cfg.GoogleClientID, cfg.GoogleClientSecret
+	authURL, tokenURL := endpoint.authURL, endpoint.tokenURL
+	if provider == models.OAuthProviderMicrosoft {
+		clientID, clientSecret = cfg.MicrosoftClientID, cfg.MicrosoftClientSecret
+		authURL = fmt.Sprintf(authURL, cfg.MicrosoftTenant)
+		tokenURL = fmt.Sprintf(tokenURL, cfg.MicrosoftTenant)
+	}
+	if clientID == "" {
+		return nil, fmt.Errorf("未配置 %s 的OAuth2客户端ID", provider)
+	}
+
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       endpoint.scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  authURL,
+			TokenURL: tokenURL,
+		},
+	}, nil
+}
+
+// AuthCodeURL 为账户生成授权链接，provider 为空时使用账户已关联的提供方或根据服务器推测
+func (s *OAuthService) AuthCodeURL(accountID uint, provider string) (string, error) {
+	var account models.MailAccount
+	if err := s.db.First(&account, accountID).Error; err != nil {
+		return "", fmt.Errorf("未找到账户: %d", accountID)
+	}
+
+	if provider == "" {
+		provider = account.OAuthProvider
+	}
+	if provider == "" {
+		provider = InferOAuthProvider(account.Server)
+	}
+	if provider == "" {
+		return "", errors.New("无法根据服务器推测OAuth2提供方，请指定 provider")
+	}
+
+	cfg, err := s.oauthConfig(provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// 顺便清理过期的授权请求
+	s.db.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+
+	if err := s.db.Create(&models.OAuthState{
+		State:        state,
+		AccountID:    account.ID,
+		Provider:     provider,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	}).Error; err != nil {
+		return "", fmt.Errorf("保存授权请求失败: %v", err)
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("login_hint", account.Username),
+	}
+	// Google 只在用户同意授权时返回刷新令牌，重新关联时也需要
+	if provider == models.OAuthProviderGoogle {
+		opts = append(opts, oauth2.ApprovalForce)
+	}
+
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+// HandleCallback 处理授权回调，用授权码换取令牌并保存到账户，账户随后改用OAuth2认证，
+// 同时返回修改前的账户用于审计
+func (s *OAuthService) HandleCallback(ctx context.Context, state, code string) (before, account models.MailAccount, err error) {
+
+	var authState models.OAuthState
+	if err := s.db.Where("state = ? AND expires_at > ?", state, time.Now()).First(&authState).Error; err != nil {
+		return before, account, errors.New("授权请求不存在或已过期")
+	}
+	// state 只能使用一次，并发回调中只有删除成功的一方可以继续
+	result := s.db.Delete(&authState)
+	if result.Error != nil {
+		return before, account, fmt.Errorf("删除授权请求失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return before, account, errors.New("授权请求不存在或已过期")
+	}
+
+	cfg, err := s.oauthConfig(authState.Provider)
+	if err != nil {
+		return before, account, err
+	}
+
+	token, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(authState.CodeVerifier))
+	if err != nil {
+		return before, account, fmt.Errorf("换取访问令牌失败: %v", err)
+	}
+
+	lock := s.accountLock(authState.AccountID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := s.db.First(&account, authState.AccountID).Error; err != nil {
+		return before, account, fmt.Errorf("未找到账户: %d", authState.AccountID)
+	}
+	before = account
+
+	// 重新授权时提供方可能不返回新的刷新令牌，此时沿用原来的
+	if token.RefreshToken == "" && (account.OAuthRefreshToken == "" || account.OAuthProvider != authState.Provider) {
+		return before, account, errors.New("提供方没有返回刷新令牌，请撤销授权后重试")
+	}
+
+	account.AuthType = models.AuthTypeOAuth2
+	account.OAuthProvider = authState.Provider
+	account.OAuthAccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		account.OAuthRefreshToken = token.RefreshToken
+	}
+	account.OAuthExpiry = tokenExpiry(token)
+
+	// 使用 Save 更新 updated_at，调度器会用新的认证方式重建推送监听
+	if err := s.db.Save(&account).Error; err != nil {
+		return before, account, fmt.Errorf("保存访问令牌失败: %v", err)
+	}
+
+	log.Printf("账户 %s 已关联 %s OAuth2 授权", account.Address, authState.Provider)
+	return before, account, nil
+}
+
+// AccessToken 返回账户可用的访问令牌，即将过期时使用刷新令牌换取新的令牌
+func (s *OAuthService) AccessToken(accountID uint) (string, error) {
+	// 同一账户的IMAP和SMTP可能同时刷新，加锁避免刷新令牌被重复使用；
+	// 按账户加锁，一个提供方响应缓慢不会阻塞其他账户
+	lock := s.accountLock(accountID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var account models.MailAccount
+	if err := s.db.First(&account, accountID).Error; err != nil {
+		return "", fmt.Errorf("未找到账户: %d", accountID)
+	}
+	if account.AuthType != models.AuthTypeOAuth2 {
+		return "", fmt.Errorf("账户 %s 未使用OAuth2认证", account.Address)
+	}
+
+	if account.OAuthAccessToken != "" && account.OAuthExpiry != nil &&
+		time.Until(*account.OAuthExpiry) > tokenRefreshMargin {
+		return account.OAuthAccessToken, nil
+	}
+	if account.OAuthRefreshToken == "" {
+		return "", &mail.AuthError{Err: fmt.Errorf("账户 %s 缺少刷新令牌，请重新授权", account.Address)}
+	}
+
+	cfg, err := s.oauthConfig(account.OAuthProvider)
+	if err != nil {
+		return "", err
+	}
+
+	// 只提供刷新令牌，强制向提供方换取新的访问令牌
+	token, err := cfg.TokenSource(context.Background(), &oauth2.Token{RefreshToken: account.OAuthRefreshToken}).Token()
+	if err != nil {
+		return "", fmt.Errorf("刷新访问令牌失败: %w", err)
+	}
+
+	refreshToken := account.OAuthRefreshToken
+	if token.RefreshToken != "" {
+		refreshToken = token.RefreshToken
+	}
+
+	// 使用 UpdateColumns 不更新 updated_at，避免每次刷新都重建推送监听
+	if err := s.db.Model(&account).UpdateColumns(models.MailAccount{
+		OAuthAccessToken:  token.AccessToken,
+		OAuthRefreshToken: refreshToken,
+		OAuthExpiry:       tokenExpiry(token),
+	}).Error; err != nil {
+		return "", fmt.Errorf("保存访问令牌失败: %v", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// accountLock 返回账户的令牌锁，不存在时创建
+func (s *OAuthService) accountLock(accountID uint) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[uint]*sync.Mutex)
+	}
+	lock, ok := s.locks[accountID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[accountID] = lock
+	}
+	return lock
+}
+
+// TokenSource 返回邮件客户端使用的访问令牌来源，未使用OAuth2的账户返回 nil
+func (s *OAuthService) TokenSource(account models.MailAccount) func() (string, error) {
+	if s == nil || account.AuthType != models.AuthTypeOAuth2 {
+		return nil
+	}
+	return func() (string, error) {
+		return s.AccessToken(account.ID)
+	}
+}
+
+// tokenExpiry 返回令牌的过期时间，提供方未返回有效期时为 nil
+func tokenExpiry(token *oauth2.Token) *time.Time {
+	if token.Expiry.IsZero() {
+		return nil
+	}
+	expiry := token.Expiry
+	return &expiry
+}
+
+// randomState 生成随机的 state 参数
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成state失败: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"mail-dispatcher/internal/config"
+	"mail-dispatcher/internal/models"
+)
+
+func TestInferOAuthProvider(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		want   string
+	}{
+		{"Gmail", "imap.gmail.com:993", models.OAuthProviderGoogle},
+		{"Microsoft 365", "outlook.office365.com:993", models.OAuthProviderMicrosoft},
+		{"大小写不敏感", "IMAP.GMAIL.COM", models.OAuthProviderGoogle},
+		{"其他服务器", "imap.qq.com:993", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InferOAuthProvider(tt.server); got != tt.want {
+				t.Errorf("InferOAuthProvider() = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthService_OAuthConfig(t *testing.T) {
+	s := NewOAuthService(nil, &config.Config{OAuth: config.OAuthConfig{
+		RedirectURL:       "https://dispatcher.example.com/api/v1/oauth/callback",
+		GoogleClientID:    "google-id",
+		MicrosoftClientID: "microsoft-id",
+		MicrosoftTenant:   "contoso.onmicrosoft.com",
+	}})
+
+	cfg, err := s.oauthConfig(models.OAuthProviderMicrosoft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientID != "microsoft-id" || !strings.Contains(cfg.Endpoint.TokenURL, "/contoso.onmicrosoft.com/") {
+		t.Errorf("Microsoft 配置错误: %s %s", cfg.ClientID, cfg.Endpoint.TokenURL)
+	}
+
+	cfg, err = s.oauthConfig(models.OAuthProviderGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientID != "google-id" || cfg.RedirectURL != "https://dispatcher.example.com/api/v1/oauth/callback" {
+		t.Errorf("Google 配置错误: %s %s", cfg.ClientID, cfg.RedirectURL)
+	}
+
+	if _, err := s.oauthConfig("yahoo"); err == nil {
+		t.Error("不支持的提供方应返回错误")
+	}
+
+	s.config.OAuth.GoogleClientID = ""
+	if _, err := s.oauthConfig(models.OAuthProviderGoogle); err == nil {
+		t.Error("未配置客户端ID时应返回错误")
+	}
+}
+
+func TestOAuthService_AccountLock(t *testing.T) {
+	s := &OAuthService{}
+
+	if s.accountLock(1) != s.accountLock(1) {
+		t.Error("同一账户应使用同一把锁")
+	}
+
+	// 一个账户持有锁时，其他账户不应被阻塞
+	s.accountLock(1).Lock()
+	defer s.accountLock(1).Unlock()
+	if !s.accountLock(2).TryLock() {
+		t.Error("其他账户的锁不应被占用")
+	}
+}
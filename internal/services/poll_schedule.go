@@ -0,0 +1,59 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ParsePollSchedule 解析账户的活跃时段，格式为标准的5段cron表达式（分 时 日 月 周），
+// 可以用 CRON_TZ=<时区> 前缀指定时区，为空表示不限时段
+func ParsePollSchedule(expr string) (cron.Schedule, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的活跃时段: %v", err)
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("活跃时段永远不会生效: %s", expr)
+	}
+	return schedule, nil
+}
+
+// inPollWindow 判断 t 所在的分钟是否处于活跃时段内
+func inPollWindow(window cron.Schedule, t time.Time) bool {
+	if window == nil {
+		return true
+	}
+	minute := t.Truncate(time.Minute)
+	return window.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+// nextPollTime 计算 after 之后 delay 的轮询时间，不在活跃时段内时顺延到下一个活跃时段的开始
+func nextPollTime(after time.Time, delay time.Duration, window cron.Schedule) time.Time {
+	next := after.Add(delay)
+	if inPollWindow(window, next) {
+		return next
+	}
+	if start := window.Next(next); !start.IsZero() {
+		return start
+	}
+	return next
+}
+
+// pollDelay 计算连续失败 failures 次后的轮询间隔，每次翻倍，不超过 maxRetryDelay，
+// 但不会短于账户本身的轮询间隔
+func pollDelay(interval time.Duration, failures int) time.Duration {
+	if failures <= 0 {
+		return interval
+	}
+	delay := retryDelay(interval, failures+1)
+	if delay < interval {
+		return interval
+	}
+	return delay
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePollSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"不限时段", "", false},
+		{"工作日工作时间", "* 9-17 * * 1-5", false},
+		{"指定时区", "CRON_TZ=Asia/Shanghai * 8-20 * * *", false},
+		{"字段数量错误", "* 9-17 * *", true},
+		{"取值超出范围", "* 25 * * *", true},
+		{"永远不会生效", "* * 30 2 *", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePollSchedule(tt.expr); (err != nil) != tt.wantErr {
+				t.Errorf("ParsePollSchedule() error = %v, 期望失败: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNextPollTime(t *testing.T) {
+	window, err := ParsePollSchedule("* 9-17 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2026-10-16 是星期五
+	friday := func(hour, minute int) time.Time {
+		return time.Date(2026, 10, 16, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name   string
+		after  time.Time
+		delay  time.Duration
+		window bool
+		want   time.Time
+	}{
+		{"不限时段", friday(20, 0), 5 * time.Minute, false, friday(20, 5)},
+		{"活跃时段内", friday(10, 0), 5 * time.Minute, true, friday(10, 5)},
+		{"活跃时段的最后一分钟", friday(17, 54), 5 * time.Minute, true, friday(17, 59)},
+		{"超出活跃时段顺延到下周一", friday(17, 58), 5 * time.Minute, true, time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)},
+		{"早于活跃时段", friday(7, 0), time.Minute, true, friday(9, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := window
+			if !tt.window {
+				w = nil
+			}
+			if got := nextPollTime(tt.after, tt.delay, w); !got.Equal(tt.want) {
+				t.Errorf("nextPollTime() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{"没有失败", 5 * time.Minute, 0, 5 * time.Minute},
+		{"第一次失败翻倍", 5 * time.Minute, 1, 10 * time.Minute},
+		{"第三次失败", 5 * time.Minute, 3, 40 * time.Minute},
+		{"超过上限", 5 * time.Minute, 20, maxRetryDelay},
+		{"轮询间隔本身超过上限", 24 * time.Hour, 2, 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollDelay(tt.interval, tt.failures); got != tt.want {
+				t.Errorf("pollDelay() = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
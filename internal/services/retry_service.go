@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-dispatcher/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// maxBulkRetry 批量重试单次最多处理的日志数量
+const maxBulkRetry = 500
+
+// retryJobTTL 已完成的批量重试任务保留的时间
+const retryJobTTL = time.Hour
+
+// 批量重试任务状态
+const (
+	RetryJobRunning     = "running"
+	RetryJobDone        = "done"
+	RetryJobInterrupted = "interrupted" // 服务停止时尚未完成，剩余的日志未重试
+)
+
+// ErrRetryJobRunning 已有批量重试任务在运行，同时运行会重复重试同一批日志
+var ErrRetryJobRunning = errors.New("已有批量重试任务在运行")
+
+// RetryTarget 重试时指定的转发目标，都为空时重新执行路由规则
+type RetryTarget struct {
+	TargetID  uint
+	ForwardTo string
+}
+
+// RetryFilter 批量重试的日志筛选条件
+type RetryFilter struct {
+	Status    string
+	AccountID uint
+	StartDate *time.Time
+	EndDate   *time.Time
+	Limit     int
+}
+
+// RetryResult 单条日志的重试结果
+type RetryResult struct {
+	LogID uint
+	Error string
+}
+
+// RetryJob 后台执行的批量重试任务，逐条重新获取原邮件需要连接来源账户，可能耗时较长
+type RetryJob struct {
+	ID         string
+	Status     string
+	Total      int
+	Processed  int
+	Failed     int
+	Results    []RetryResult
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// RetryService 手动重试服务，重新获取原邮件并加入投递队列
+type RetryService struct {
+	db                 *gorm.DB
+	senderService      *SenderService
+	mailRoutingService *MailRoutingService
+	deliveryService    *DeliveryService
+	mu                 sync.Mutex
+	jobs               map[string]*RetryJob
+
+	// 服务生命周期，停止时取消正在运行的批量重试任务
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRetryService 创建手动重试服务
+func NewRetryService(db *gorm.DB, senderService *SenderService, mailRoutingService *MailRoutingService, deliveryService *DeliveryService) *RetryService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RetryService{
+		db:                 db,
+		senderService:      senderService,
+		mailRoutingService: mailRoutingService,
+		deliveryService:    deliveryService,
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+// Stop 停止手动重试服务，正在运行的批量重试任务处理完当前日志后标记为中断
+func (s *RetryService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+	log.Println("手动重试服务已停止")
+}
+
+// RetryLog 重新分发日志对应的邮件，新的日志通过 RetryOfID 关联到原日志
+func (s *RetryService) RetryLog(logID uint, target RetryTarget) ([]models.MailLog, error) {
+	var mailLog models.MailLog
+	if err := s.db.First(&mailLog, logID).Error; err != nil {
+		return nil, fmt.Errorf("日志不存在: %d", logID)
+	}
+
+	if mailLog.Status == "queued" {
+		return nil, fmt.Errorf("邮件正在投递中，无需重试")
+	}
+
+	email, err := s.loadEmail(mailLog)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := s.resolveRecipients(mailLog, email, target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rcpt := range recipients {
+		if err := s.deliveryService.Enqueue(email, mailLog.AccountID, rcpt, mailLog.ID); err != nil {
+			return nil, fmt.Errorf("加入投递队列失败 (%s): %v", rcpt.Address, err)
+		}
+	}
+
+	// 失败记录已重新分发，避免重复出现在失败列表中
+	if mailLog.Status == "failed" {
+		if err := s.db.Model(&mailLog).Update("status", "retried").Error; err != nil {
+			log.Printf("更新日志状态失败 (ID: %d): %v", mailLog.ID, err)
+		}
+	}
+
+	log.Printf("日志 %d 已重新加入投递队列 (收件人 %d 个)", mailLog.ID, len(recipients))
+
+	var retries []models.MailLog
+	if err := s.db.Where("retry_of_id = ?", mailLog.ID).Order("id ASC").Find(&retries).Error; err != nil {
+		return nil, fmt.Errorf("查询重试日志失败: %v", err)
+	}
+	return retries, nil
+}
+
+// RetryLogs 按条件筛选日志并在后台批量重试，单条失败不影响其他日志，返回的任务可通过 GetRetryJob 查询进度
+func (s *RetryService) RetryLogs(filter RetryFilter, target RetryTarget) (RetryJob, error) {
+	if filter.Status == "" {
+		filter.Status = "failed"
+	}
+	if filter.Limit <= 0 || filter.Limit > maxBulkRetry {
+		filter.Limit = maxBulkRetry
+	}
+
+	query := s.db.Model(&models.MailLog{}).Where("status = ?", filter.Status)
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.StartDate != nil {
+		query = query.Where("created_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("created_at < ?", *filter.EndDate)
+	}
+
+	var ids []uint
+	if err := query.Order("id ASC").Limit(filter.Limit).Pluck("id", &ids).Error; err != nil {
+		return RetryJob{}, fmt.Errorf("查询待重试日志失败: %v", err)
+	}
+
+	return s.startJob(ids, func(id uint) error {
+		_, err := s.RetryLog(id, target)
+		return err
+	})
+}
+
+// GetRetryJob 返回批量重试任务的当前进度
+func (s *RetryService) GetRetryJob(id string) (RetryJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return RetryJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// startJob 登记批量重试任务并在后台逐条执行 retry
+func (s *RetryService) startJob(ids []uint, retry func(uint) error) (RetryJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = make(map[string]*RetryJob)
+	}
+	// 顺便清理过期的任务
+	for id, job := range s.jobs {
+		if job.Status == RetryJobRunning {
+			return RetryJob{}, ErrRetryJobRunning
+		}
+		if time.Since(*job.FinishedAt) > retryJobTTL {
+			delete(s.jobs, id)
+		}
+	}
+
+	jobID, err := randomState()
+	if err != nil {
+		return RetryJob{}, err
+	}
+	job := &RetryJob{
+		ID:        jobID,
+		Status:    RetryJobRunning,
+		Total:     len(ids),
+		Results:   make([]RetryResult, 0, len(ids)),
+		CreatedAt: time.Now(),
+	}
+	s.jobs[jobID] = job
+
+	s.wg.Add(1)
+	go s.runJob(job, ids, retry)
+
+	return job.snapshot(), nil
+}
+
+// runJob 逐条重试并更新任务进度，服务停止时不再处理剩余的日志
+func (s *RetryService) runJob(job *RetryJob, ids []uint, retry func(uint) error) {
+	defer s.wg.Done()
+
+	status := RetryJobDone
+	for _, id := range ids {
+		if s.ctx.Err() != nil {
+			status = RetryJobInterrupted
+			break
+		}
+
+		result := RetryResult{LogID: id}
+		if err := retry(id); err != nil {
+			result.Error = err.Error()
+		}
+
+		s.mu.Lock()
+		job.Results = append(job.Results, result)
+		job.Processed++
+		if result.Error != "" {
+			job.Failed++
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	job.Status = status
+	job.FinishedAt = &now
+	s.mu.Unlock()
+
+	if status == RetryJobInterrupted {
+		log.Printf("批量重试任务 %s 已中断 (共 %d 条，已处理 %d 条，失败 %d 条)", job.ID, job.Total, job.Processed, job.Failed)
+		return
+	}
+	log.Printf("批量重试任务 %s 已完成 (共 %d 条，失败 %d 条)", job.ID, job.Total, job.Failed)
+}
+
+// snapshot 复制任务的当前状态，调用方需持有锁
+func (j *RetryJob) snapshot() RetryJob {
+	snapshot := *j
+	snapshot.Results = append([]RetryResult{}, j.Results...)
+	return snapshot
+}
+
+// loadEmail 获取日志对应的原邮件，优先使用投递队列中保存的原文，否则按UID从来源账户重新获取
+func (s *RetryService) loadEmail(mailLog models.MailLog) (models.Email, error) {
+	var outbound models.OutboundMessage
+	err := s.db.Where("mail_log_id = ?", mailLog.ID).Order("id DESC").First(&outbound).Error
+	if err == nil && len(outbound.Email.RawData) > 0 {
+		return outbound.Email, nil
+	}
+
+	if mailLog.UID == 0 {
+		return models.Email{}, fmt.Errorf("日志没有关联的原邮件，无法重试")
+	}
+
+	var account models.MailAccount
+	if err := s.db.First(&account, mailLog.AccountID).Error; err != nil {
+		return models.Email{}, fmt.Errorf("未找到账户: %d", mailLog.AccountID)
+	}
+
+	mailClient, err := s.senderService.createMailClient(account)
+	if err != nil {
+		return models.Email{}, fmt.Errorf("创建邮件客户端失败: %v", err)
+	}
+	defer func() {
+		if err := mailClient.Stop(); err != nil {
+			log.Printf("停止邮件客户端失败 (账户ID: %d): %v", account.ID, err)
+		}
+	}()
+
+	email, err := mailClient.FetchByUID(mailLog.Folder, mailLog.UID, mailLog.UIDValidity)
+	if err == nil {
+		return email, nil
+	}
+
+	// 原邮件可能已被处理后操作移动到其他文件夹，按 Message-ID 查找
+	if mailLog.MessageID != "" {
+		for _, folder := range movedFolders(account) {
+			if moved, moveErr := mailClient.FetchByMessageID(folder, mailLog.MessageID); moveErr == nil {
+				return moved, nil
+			}
+		}
+	}
+	return models.Email{}, fmt.Errorf("重新获取原邮件失败: %v", err)
+}
+
+// movedFolders 返回处理后操作中移动或复制原邮件的目标文件夹
+func movedFolders(account models.MailAccount) []string {
+	var folders []string
+	for _, action := range append(append([]models.MailboxAction{}, account.FailedActions...), account.DispatchedActions...) {
+		if action.Type == models.MailboxActionMove || action.Type == models.MailboxActionCopy {
+			folders = append(folders, action.Target)
+		}
+	}
+	return folders
+}
+
+// resolveRecipients 确定重试的收件人
+// 指定目标时直接使用；否则重新执行路由，原日志有收件人时只重发给该收件人
+func (s *RetryService) resolveRecipients(mailLog models.MailLog, email models.Email, target RetryTarget) ([]recipient, error) {
+	if target.TargetID != 0 {
+		var forwardTarget models.ForwardTarget
+		if err := s.db.First(&forwardTarget, target.TargetID).Error; err != nil {
+			return nil, fmt.Errorf("转发目标不存在: %d", target.TargetID)
+		}
+		return []recipient{{Address: forwardTarget.Email, MaxSize: forwardTarget.MaxSize, ForwardMode: forwardTarget.ForwardMode}}, nil
+	}
+
+	if target.ForwardTo != "" {
+		addr, err := netmail.ParseAddress(target.ForwardTo)
+		if err != nil {
+			return nil, fmt.Errorf("无效的转发地址: %s", target.ForwardTo)
+		}
+		return []recipient{{Address: addr.Address}}, nil
+	}
+
+	recipients, err := s.mailRoutingService.resolveRecipients(email)
+	if err != nil {
+		return nil, err
+	}
+	if mailLog.ForwardTo == "" {
+		return recipients, nil
+	}
+
+	for _, rcpt := range recipients {
+		if strings.EqualFold(rcpt.Address, mailLog.ForwardTo) {
+			return []recipient{rcpt}, nil
+		}
+	}
+	return nil, fmt.Errorf("当前路由结果不包含原收件人 %s，请指定转发目标", mailLog.ForwardTo)
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestRetryService_ResolveRecipients_ForwardTo(t *testing.T) {
+	service := &RetryService{}
+
+	tests := []struct {
+		name      string
+		forwardTo string
+		want      string
+		wantErr   bool
+	}{
+		{"纯地址", "backup@example.com", "backup@example.com", false},
+		{"带显示名称", "备份 <backup@example.com>", "backup@example.com", false},
+		{"无效地址", "backup", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.resolveRecipients(models.MailLog{}, models.Email{}, RetryTarget{ForwardTo: tt.forwardTo})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveRecipients() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != 1 || got[0].Address != tt.want {
+				t.Errorf("resolveRecipients() = %v, 期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovedFolders(t *testing.T) {
+	account := models.MailAccount{
+		DispatchedActions: []models.MailboxAction{{Type: models.MailboxActionSeen}, {Type: models.MailboxActionMove, Target: "Dispatched"}},
+		FailedActions:     []models.MailboxAction{{Type: models.MailboxActionCopy, Target: "Dispatch-Failed"}},
+	}
+
+	got := movedFolders(account)
+	if len(got) != 2 || got[0] != "Dispatch-Failed" || got[1] != "Dispatched" {
+		t.Errorf("movedFolders() = %v", got)
+	}
+}
+
+func TestRetryService_StartJob(t *testing.T) {
+	service := NewRetryService(nil, nil, nil, nil)
+
+	release := make(chan struct{})
+	job, err := service.startJob([]uint{1, 2, 3}, func(id uint) error {
+		<-release
+		if id == 2 {
+			return errors.New("重新获取原邮件失败")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("startJob() error = %v", err)
+	}
+	if job.Status != RetryJobRunning || job.Total != 3 {
+		t.Errorf("新任务 = %+v", job)
+	}
+
+	// 同一时间只允许一个批量重试任务
+	if _, err := service.startJob([]uint{4}, func(uint) error { return nil }); !errors.Is(err, ErrRetryJobRunning) {
+		t.Errorf("任务运行中再次启动应返回 ErrRetryJobRunning: %v", err)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, ok := service.GetRetryJob(job.ID)
+		if !ok {
+			t.Fatal("未找到批量重试任务")
+		}
+		if got.Status == RetryJobDone {
+			if got.Processed != 3 || got.Failed != 1 || len(got.Results) != 3 || got.Results[1].Error == "" {
+				t.Errorf("完成的任务 = %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待批量重试任务完成超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := service.startJob(nil, func(uint) error { return nil }); err != nil {
+		t.Errorf("上一个任务完成后应能启动新任务: %v", err)
+	}
+	if _, ok := service.GetRetryJob("missing"); ok {
+		t.Error("不存在的任务不应被找到")
+	}
+}
+
+func TestRetryService_StopInterruptsJob(t *testing.T) {
+	service := NewRetryService(nil, nil, nil, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	job, err := service.startJob([]uint{1, 2, 3}, func(id uint) error {
+		if id == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("startJob() error = %v", err)
+	}
+
+	// 第一条日志处理中停止服务，处理完后不再继续
+	<-started
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	service.Stop()
+
+	got, _ := service.GetRetryJob(job.ID)
+	if got.Status != RetryJobInterrupted || got.Processed != 1 || got.FinishedAt == nil {
+		t.Errorf("中断的任务 = %+v", got)
+	}
+}
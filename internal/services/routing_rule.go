@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+)
+
+// subjectRegexps 按规则ID缓存编译后的主题正则，规则修改后重新编译
+type subjectRegexps struct {
+	mu      sync.Mutex
+	entries map[uint]subjectRegexp
+}
+
+// subjectRegexp 编译结果，无效的正则同样缓存，避免每封邮件重复编译
+type subjectRegexp struct {
+	pattern   string
+	updatedAt time.Time
+	re        *regexp.Regexp
+	err       error
+}
+
+// get 返回规则的主题正则，缓存的编译结果与规则的 UpdatedAt 或正则不一致时重新编译
+func (c *subjectRegexps) get(rule models.RoutingRule) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[rule.ID]
+	if !ok || entry.pattern != rule.SubjectRegex || !entry.updatedAt.Equal(rule.UpdatedAt) {
+		entry = subjectRegexp{pattern: rule.SubjectRegex, updatedAt: rule.UpdatedAt}
+		entry.re, entry.err = regexp.Compile(rule.SubjectRegex)
+		if c.entries == nil {
+			c.entries = make(map[uint]subjectRegexp)
+		}
+		c.entries[rule.ID] = entry
+	}
+	return entry.re, entry.err
+}
+
+// retain 清除已删除或停用规则的缓存
+func (c *subjectRegexps) retain(rules []models.RoutingRule) {
+	active := make(map[uint]bool, len(rules))
+	for _, rule := range rules {
+		active[rule.ID] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.entries {
+		if !active[id] {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// matchRule 判断邮件是否满足规则的全部条件，未设置的条件视为满足
+func matchRule(rule models.RoutingRule, email models.Email, subjects *subjectRegexps) (bool, error) {
+	if rule.Folder != "" && !mail.SameFolder(rule.Folder, email.Folder) {
+		return false, nil
+	}
+
+	if rule.Sender != "" && !containsFold(email.From, rule.Sender) && !containsFold(email.FromName, rule.Sender) {
+		return false, nil
+	}
+
+	if rule.Recipient != "" && !matchRecipient(email, rule.Recipient) {
+		return false, nil
+	}
+
+	if rule.SubjectRegex != "" {
+		re, err := subjects.get(rule)
+		if err != nil {
+			return false, fmt.Errorf("规则 %s 的主题正则无效: %v", rule.Name, err)
+		}
+		if !re.MatchString(email.Subject) {
+			return false, nil
+		}
+	}
+
+	if rule.HeaderName != "" && !matchHeader(email, rule.HeaderName, rule.HeaderValue) {
+		return false, nil
+	}
+
+	if rule.BodyKeyword != "" && !containsFold(email.Body, rule.BodyKeyword) && !containsFold(email.HTMLBody, rule.BodyKeyword) {
+		return false, nil
+	}
+
+	if rule.HasAttachment != nil && *rule.HasAttachment != (len(email.Attachments) > 0) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// matchRecipient 在 To、Cc 和 Delivered-To 中查找收件人
+func matchRecipient(email models.Email, pattern string) bool {
+	if containsFold(email.To, pattern) {
+		return true
+	}
+	for _, key := range []string{"To", "Cc", "Delivered-To"} {
+		for _, value := range email.Headers[key] {
+			if containsFold(value, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchHeader 邮件头存在且值包含指定内容，未指定值时只要求邮件头存在
+func matchHeader(email models.Email, name, value string) bool {
+	values, ok := email.Headers[textproto.CanonicalMIMEHeaderKey(name)]
+	if !ok {
+		return false
+	}
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if containsFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold 不区分大小写的包含判断
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/models"
+)
+
+func TestMatchRule(t *testing.T) {
+	yes, no := true, false
+	email := models.Email{
+		Subject:     "【告警】CPU 使用率过高",
+		From:        "monitor@ops.example.com",
+		FromName:    "监控系统",
+		To:          "router@example.com",
+		Body:        "host=web-01 severity=critical",
+		Headers:     map[string][]string{"X-Priority": {"1 (Highest)"}, "Cc": {"team@example.com"}},
+		Attachments: []string{"report.pdf"},
+	}
+
+	tests := []struct {
+		name string
+		rule models.RoutingRule
+		want bool
+	}{
+		{"无条件", models.RoutingRule{}, true},
+		{"发件人域名", models.RoutingRule{Sender: "@OPS.example.com"}, true},
+		{"发件人显示名称", models.RoutingRule{Sender: "监控"}, true},
+		{"发件人不匹配", models.RoutingRule{Sender: "@qq.com"}, false},
+		{"抄送收件人", models.RoutingRule{Recipient: "team@"}, true},
+		{"主题正则", models.RoutingRule{SubjectRegex: `^【告警】`}, true},
+		{"主题正则不匹配", models.RoutingRule{SubjectRegex: `^通知`}, false},
+		{"邮件头值", models.RoutingRule{HeaderName: "x-priority", HeaderValue: "highest"}, true},
+		{"邮件头不存在", models.RoutingRule{HeaderName: "X-Mailer"}, false},
+		{"正文关键字", models.RoutingRule{BodyKeyword: "CRITICAL"}, true},
+		{"要求有附件", models.RoutingRule{HasAttachment: &yes}, true},
+		{"要求无附件", models.RoutingRule{HasAttachment: &no}, false},
+		{"多个条件同时满足", models.RoutingRule{Sender: "monitor@", BodyKeyword: "web-01"}, true},
+		{"多个条件部分满足", models.RoutingRule{Sender: "monitor@", BodyKeyword: "db-01"}, false},
+		{"来源文件夹为空时视为收件箱", models.RoutingRule{Folder: "inbox"}, true},
+		{"来源文件夹不匹配", models.RoutingRule{Folder: "Alerts"}, false},
+	}
+
+	var subjects subjectRegexps
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchRule(tt.rule, email, &subjects)
+			if err != nil {
+				t.Fatalf("matchRule() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matchRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRule_InvalidRegex(t *testing.T) {
+	if _, err := matchRule(models.RoutingRule{SubjectRegex: "("}, models.Email{}, &subjectRegexps{}); err == nil {
+		t.Error("无效的正则表达式应返回错误")
+	}
+}
+
+func TestSubjectRegexps(t *testing.T) {
+	var subjects subjectRegexps
+	updatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rule := models.RoutingRule{ID: 1, SubjectRegex: `^告警`, UpdatedAt: updatedAt}
+
+	first, err := subjects.get(rule)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if again, _ := subjects.get(rule); again != first {
+		t.Error("规则未修改时应复用编译结果")
+	}
+
+	// 规则修改后重新编译
+	rule.SubjectRegex, rule.UpdatedAt = `^通知`, updatedAt.Add(time.Minute)
+	changed, err := subjects.get(rule)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if changed == first || !changed.MatchString("通知") {
+		t.Error("规则修改后应使用新的正则")
+	}
+
+	subjects.retain(nil)
+	if len(subjects.entries) != 0 {
+		t.Errorf("已删除规则的缓存应被清除，剩余 %d 条", len(subjects.entries))
+	}
+}
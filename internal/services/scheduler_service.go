@@ -1,8 +1,10 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"mail-dispatcher/internal/config"
@@ -10,50 +12,107 @@ import (
 	"mail-dispatcher/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// scheduleTick 检查到期账户的间隔，账户的轮询间隔精度不高于该值
+const scheduleTick = 15 * time.Second
+
 // SchedulerService 调度器服务
 type SchedulerService struct {
 	db                 *gorm.DB
 	mailRoutingService *MailRoutingService
+	oauthService       *OAuthService
 	config             *config.Config
-	stopChan           chan bool
+	stopChan           chan struct{}
+	jobs               chan models.MailAccount
+	wg                 sync.WaitGroup
+	listenerWG         sync.WaitGroup // 推送监听的后台协程
+
+	mu            sync.Mutex
+	pushListeners map[uint]*pushListener
+	idleFallback  map[uint]time.Time // 不支持IDLE的账户，值为回退时账户的 UpdatedAt
+	polling       map[uint]bool      // 已排队或正在轮询的账户
+}
+
+// pushListener 运行中的IDLE推送监听，连接建立前 client 为空
+type pushListener struct {
+	client    *mail.MailClient
+	updatedAt time.Time
+}
+
+// stopPushClients 停止推送监听的客户端，正在连接的监听在连接建立后自行停止
+func stopPushClients(listeners map[uint]*pushListener) {
+	for accountID, listener := range listeners {
+		if listener.client == nil {
+			continue
+		}
+		if err := listener.client.Stop(); err != nil {
+			log.Printf("停止推送监听失败 (账户ID: %d): %v", accountID, err)
+		}
+	}
 }
 
 // NewSchedulerService 创建调度器服务
-func NewSchedulerService(db *gorm.DB, mailRoutingService *MailRoutingService, cfg *config.Config) *SchedulerService {
+func NewSchedulerService(db *gorm.DB, mailRoutingService *MailRoutingService, oauthService *OAuthService, cfg *config.Config) *SchedulerService {
 	return &SchedulerService{
 		db:                 db,
 		mailRoutingService: mailRoutingService,
+		oauthService:       oauthService,
 		config:             cfg,
-		stopChan:           make(chan bool),
+		stopChan:           make(chan struct{}),
+		jobs:               make(chan models.MailAccount),
+		pushListeners:      make(map[uint]*pushListener),
+		idleFallback:       make(map[uint]time.Time),
+		polling:            make(map[uint]bool),
 	}
 }
 
 // Start 启动调度器
 func (s *SchedulerService) Start() {
-	// 先执行一次轮询
-	s.pollAllAccounts()
+	workers := s.config.Mail.PollWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
 
+	s.wg.Add(1)
 	go s.pollingLoop()
-	log.Println("调度器服务已启动")
+
+	log.Printf("调度器服务已启动 (并发数: %d)", workers)
 }
 
-// Stop 停止调度器
+// Stop 停止调度器，等待正在进行的轮询和推送监听完成
 func (s *SchedulerService) Stop() {
 	close(s.stopChan)
+	// 调度循环退出后不会再启动新的推送监听
+	s.wg.Wait()
+
+	s.mu.Lock()
+	listeners := s.pushListeners
+	s.pushListeners = make(map[uint]*pushListener)
+	s.mu.Unlock()
+
+	stopPushClients(listeners)
+
+	s.listenerWG.Wait()
 	log.Println("调度器服务已停止")
 }
 
-// pollingLoop 轮询循环
+// pollingLoop 轮询循环，定时检查到期的账户
 func (s *SchedulerService) pollingLoop() {
-	// 使用配置中的轮询间隔
-	fmt.Println(s.config.Mail)
-	pollingInterval := time.Duration(s.config.Mail.PollingInterval) * time.Second
-	ticker := time.NewTicker(pollingInterval)
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(scheduleTick)
 	defer ticker.Stop()
 
-	log.Printf("调度器使用轮询间隔: %v", pollingInterval)
+	log.Printf("调度器默认轮询间隔: %v", s.defaultInterval())
+
+	// 先执行一次轮询
+	s.pollAllAccounts()
 
 	for {
 		select {
@@ -65,7 +124,7 @@ func (s *SchedulerService) pollingLoop() {
 	}
 }
 
-// pollAllAccounts 轮询所有账户
+// pollAllAccounts 轮询所有到期的账户
 func (s *SchedulerService) pollAllAccounts() {
 	// 每次轮询时动态获取活跃账户
 	accounts, err := s.getActiveAccounts()
@@ -74,23 +133,283 @@ func (s *SchedulerService) pollAllAccounts() {
 		return
 	}
 
-	log.Printf("开始轮询，共有 %d 个活跃账户", len(accounts))
+	s.syncPushListeners(accounts)
+
+	now := time.Now()
+	for _, account := range accounts {
+		if s.usesPush(account) || !s.isDue(account, now) {
+			continue
+		}
+		if !s.enqueuePoll(account) {
+			return
+		}
+	}
+}
+
+// isDue 判断账户是否到了轮询时间并处于活跃时段内
+func (s *SchedulerService) isDue(account models.MailAccount, now time.Time) bool {
+	if account.NextPollAt != nil && account.NextPollAt.After(now) {
+		return false
+	}
+	window, err := ParsePollSchedule(account.PollSchedule)
+	if err != nil {
+		log.Printf("账户 %s 的%v，忽略活跃时段 (账户ID: %d)", account.Address, err, account.ID)
+		return true
+	}
+	return inPollWindow(window, now)
+}
+
+// defaultInterval 全局配置的轮询间隔
+func (s *SchedulerService) defaultInterval() time.Duration {
+	return time.Duration(s.config.Mail.PollingInterval) * time.Second
+}
+
+// recordPollResult 保存本次连接的结果和下次轮询时间，连续失败时按指数退避，连续认证失败时自动停用账户
+func (s *SchedulerService) recordPollResult(account models.MailAccount, pollErr error) {
+	interval := s.defaultInterval()
+	if account.PollInterval > 0 {
+		interval = time.Duration(account.PollInterval) * time.Second
+	}
+
+	failures := 0
+	if pollErr != nil {
+		failures = account.PollFailures + 1
+	}
+
+	window, err := ParsePollSchedule(account.PollSchedule)
+	if err != nil {
+		window = nil
+	}
+	delay := pollDelay(interval, failures)
+	next := nextPollTime(time.Now(), delay, window)
+	if failures > 0 {
+		log.Printf("账户 %s 连续 %d 次轮询失败，%v 后重试 (账户ID: %d)", account.Address, failures, delay, account.ID)
+	}
+
+	updates := healthUpdates(account, pollErr, s.config.Mail.AuthFailureLimit, time.Now())
+	updates["poll_failures"] = failures
+	updates["next_poll_at"] = next
+	if updates["health_state"] == models.HealthPaused {
+		log.Printf("账户 %s 连续 %d 次认证失败，已自动停用，请检查凭据后重新启用 (账户ID: %d)", account.Address, updates["auth_failures"], account.ID)
+	}
+
+	// 使用 UpdateColumns 避免刷新 updated_at，否则会被视为配置变更
+	err = s.db.Model(&models.MailAccount{}).Where("id = ?", account.ID).UpdateColumns(updates).Error
+	if err != nil {
+		log.Printf("保存账户健康状态失败 (账户ID: %d): %v", account.ID, err)
+	}
+}
+
+// healthUpdates 根据本次连接结果计算账户的健康状态字段
+// 网络错误不能说明凭据是否有效，不改变认证失败的计数
+func healthUpdates(account models.MailAccount, pollErr error, authFailureLimit int, now time.Time) map[string]interface{} {
+	if pollErr == nil {
+		return map[string]interface{}{
+			"health_state":    models.HealthHealthy,
+			"last_success_at": now,
+			"auth_failures":   0,
+		}
+	}
+
+	authFailures := account.AuthFailures
+	if mail.IsAuthError(pollErr) {
+		authFailures++
+	}
+	updates := map[string]interface{}{
+		"health_state":  models.HealthFailing,
+		"last_error":    pollErr.Error(),
+		"last_error_at": now,
+		"auth_failures": authFailures,
+	}
+	if authFailureLimit > 0 && authFailures >= authFailureLimit {
+		updates["health_state"] = models.HealthPaused
+		updates["is_active"] = false
+	}
+	return updates
+}
+
+// enqueuePoll 将账户交给工作协程轮询，账户已在排队或轮询中时跳过，服务停止时返回 false
+func (s *SchedulerService) enqueuePoll(account models.MailAccount) bool {
+	s.mu.Lock()
+	if s.polling[account.ID] {
+		s.mu.Unlock()
+		log.Printf("账户 %s 的上一次轮询尚未完成，跳过本次轮询 (账户ID: %d)", account.Address, account.ID)
+		return true
+	}
+	s.polling[account.ID] = true
+	s.mu.Unlock()
+
+	select {
+	case s.jobs <- account:
+		return true
+	case <-s.stopChan:
+		s.finishPoll(account.ID)
+		return false
+	}
+}
+
+// finishPoll 清除账户的轮询标记，允许下一次轮询
+func (s *SchedulerService) finishPoll(accountID uint) {
+	s.mu.Lock()
+	delete(s.polling, accountID)
+	s.mu.Unlock()
+}
+
+// worker 轮询工作协程，限制同时进行的IMAP会话数量
+func (s *SchedulerService) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case account := <-s.jobs:
+			s.recordPollResult(account, s.pollAccount(account))
+			s.finishPoll(account.ID)
+		}
+	}
+}
+
+// usesPush 判断账户是否由推送监听负责收信
+func (s *SchedulerService) usesPush(account models.MailAccount) bool {
+	if account.FetchMode != models.FetchModePush {
+		return false
+	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fallbackAt, ok := s.idleFallback[account.ID]
+	if !ok {
+		return true
+	}
+	// 账户配置被修改后（例如更换了服务器）重新尝试IDLE
+	if !fallbackAt.Equal(account.UpdatedAt) {
+		delete(s.idleFallback, account.ID)
+		return true
+	}
+	return false
+}
+
+// syncPushListeners 按当前账户配置启动或停止推送监听
+func (s *SchedulerService) syncPushListeners(accounts []models.MailAccount) {
+	wanted := make(map[uint]models.MailAccount)
 	for _, account := range accounts {
-		log.Printf("轮询账户: %s (账户ID: %d)", account.Address, account.ID)
-		go s.pollAccount(account)
+		if s.usesPush(account) {
+			wanted[account.ID] = account
+		}
+	}
+
+	stale := make(map[uint]*pushListener)
+	s.mu.Lock()
+	for accountID, listener := range s.pushListeners {
+		account, ok := wanted[accountID]
+		if ok && account.UpdatedAt.Equal(listener.updatedAt) {
+			delete(wanted, accountID)
+			continue
+		}
+		// 账户已停用、改为轮询或配置被修改，需要停止旧的监听
+		stale[accountID] = &pushListener{client: listener.client}
+		delete(s.pushListeners, accountID)
 	}
+	s.mu.Unlock()
+
+	stopPushClients(stale)
+
+	now := time.Now()
+	for _, account := range wanted {
+		// 连接失败后同样按退避间隔重试
+		if account.NextPollAt != nil && account.NextPollAt.After(now) {
+			continue
+		}
+		s.startPushListener(account)
+	}
+}
+
+// startPushListener 为账户启动IDLE推送监听
+// 连接和登录可能因重试耗时较长，在后台进行，先登记监听避免下一轮调度重复启动
+func (s *SchedulerService) startPushListener(account models.MailAccount) {
+	listener := &pushListener{updatedAt: account.UpdatedAt}
+	s.mu.Lock()
+	s.pushListeners[account.ID] = listener
+	s.mu.Unlock()
+
+	s.listenerWG.Add(1)
+	go func() {
+		defer s.listenerWG.Done()
+
+		mailClient, err := s.createMailClient(account)
+		if err != nil {
+			s.mu.Lock()
+			if s.pushListeners[account.ID] == listener {
+				delete(s.pushListeners, account.ID)
+			}
+			s.mu.Unlock()
+			logClientError("创建推送客户端失败", account, err)
+			s.recordPollResult(account, err)
+			return
+		}
+
+		// 连接期间监听已被停止或替换时不再启动
+		s.mu.Lock()
+		current := s.pushListeners[account.ID] == listener
+		if current {
+			listener.client = mailClient
+		}
+		s.mu.Unlock()
+		if !current {
+			if err := mailClient.Stop(); err != nil {
+				log.Printf("停止推送客户端失败 (账户ID: %d): %v", account.ID, err)
+			}
+			return
+		}
+
+		s.recordPollResult(account, nil)
+		account.PollFailures = 0
+		account.AuthFailures = 0
+
+		// 处理失败时推送客户端退回水位线并重连，之后的邮件不会越过失败的邮件保存水位线
+		err = mailClient.StartPushListener(func(email models.Email) error {
+			result, err := s.mailRoutingService.ProcessEmail(email, account.ID)
+			if err != nil {
+				return err
+			}
+			applyMailboxActions(mailClient, account, email, result)
+			uidValidity, lastUID := mailClient.Progress(email.Folder, email.UID)
+			s.saveSyncState(account.ID, email.Folder, uidValidity, lastUID)
+			return nil
+		})
+
+		s.mu.Lock()
+		if s.pushListeners[account.ID] == listener {
+			delete(s.pushListeners, account.ID)
+		}
+		if errors.Is(err, mail.ErrIdleNotSupported) {
+			s.idleFallback[account.ID] = account.UpdatedAt
+		}
+		s.mu.Unlock()
+
+		if err := mailClient.Stop(); err != nil {
+			log.Printf("停止推送监听失败 (账户ID: %d): %v", account.ID, err)
+		}
+
+		if errors.Is(err, mail.ErrIdleNotSupported) {
+			log.Printf("账户 %s 不支持IDLE，回退到轮询模式", account.Address)
+			s.enqueuePoll(account)
+		} else if err != nil {
+			logClientError("推送监听异常退出", account, err)
+			s.recordPollResult(account, err)
+		}
+	}()
 }
 
-// pollAccount 轮询单个账户
-func (s *SchedulerService) pollAccount(account models.MailAccount) {
+// pollAccount 轮询单个账户，返回连接或收信失败的错误，单封邮件处理失败不计入
+func (s *SchedulerService) pollAccount(account models.MailAccount) error {
 	log.Printf("开始轮询账户: %s (账户ID: %d)", account.Address, account.ID)
 
 	// 动态创建邮件客户端
 	mailClient, err := s.createMailClient(account)
 	if err != nil {
-		log.Printf("创建邮件客户端失败 (账户ID: %d): %v", account.ID, err)
-		return
+		logClientError("创建邮件客户端失败", account, err)
+		return err
 	}
 
 	// 确保客户端被正确关闭
@@ -100,22 +419,123 @@ func (s *SchedulerService) pollAccount(account models.MailAccount) {
 		}
 	}()
 
-	emails, err := mailClient.FetchNewEmails()
+	// 单个文件夹失败时继续处理其他文件夹，返回最后一个错误
+	var lastErr error
+	for _, folder := range mailClient.Folders() {
+		if s.stopping() {
+			break
+		}
+		if err := s.pollFolder(mailClient, account, folder); err != nil {
+			logClientError(fmt.Sprintf("轮询文件夹 %s 失败", folder), account, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// pollFolder 获取并处理单个文件夹的新邮件，保存该文件夹的水位线
+func (s *SchedulerService) pollFolder(mailClient *mail.MailClient, account models.MailAccount, folder string) error {
+	emails, err := mailClient.FetchNewEmails(folder)
 	if err != nil {
-		log.Printf("轮询邮件客户端失败 (账户ID: %d): %v", account.ID, err)
-		return
+		return err
 	}
 
-	log.Printf("账户 %s 获取到 %d 封新邮件", account.Address, len(emails))
+	log.Printf("账户 %s 的文件夹 %s 获取到 %d 封新邮件", account.Address, folder, len(emails))
 
-	// 处理每封邮件
+	// 处理每封邮件，失败或服务停止时水位线退回到该邮件之前，下次轮询重新处理
 	for _, email := range emails {
-		if err := s.mailRoutingService.ProcessEmail(email, account.ID); err != nil {
+		if s.stopping() {
+			mailClient.Rewind(folder, email.UID)
+			break
+		}
+		result, err := s.mailRoutingService.ProcessEmail(email, account.ID)
+		if err != nil {
 			log.Printf("处理邮件失败: %v", err)
+			mailClient.Rewind(folder, email.UID)
+			break
 		}
+		applyMailboxActions(mailClient, account, email, result)
+	}
+
+	uidValidity, lastUID := mailClient.SyncState(folder)
+	s.saveSyncState(account.ID, folder, uidValidity, lastUID)
+	return nil
+}
+
+// applyMailboxActions 按路由结果对来源邮箱中的原邮件执行处理后操作，
+// 操作失败只记录日志，不影响水位线，邮件不会被重复转发
+func applyMailboxActions(mailClient *mail.MailClient, account models.MailAccount, email models.Email, result string) {
+	actions := account.DispatchedActions
+	if result == RouteFailed {
+		actions = account.FailedActions
+	}
+	if err := mailClient.ApplyActions(email.Folder, email.UID, actions); err != nil {
+		log.Printf("处理后操作失败 (账户ID: %d): %v", account.ID, err)
 	}
 }
 
+// stopping 判断调度器是否正在停止
+func (s *SchedulerService) stopping() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// saveSyncState 持久化账户文件夹的UID水位线
+func (s *SchedulerService) saveSyncState(accountID uint, folder string, uidValidity, lastUID uint32) {
+	state := models.FolderState{
+		AccountID:   accountID,
+		Folder:      folder,
+		UIDValidity: uidValidity,
+		LastUID:     lastUID,
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}, {Name: "folder"}},
+		DoUpdates: clause.AssignmentColumns([]string{"uid_validity", "last_uid", "updated_at"}),
+	}).Create(&state).Error
+	if err != nil {
+		log.Printf("保存UID水位线失败 (账户ID: %d, 文件夹: %s): %v", accountID, folder, err)
+	}
+}
+
+// loadFolderSync 读取账户监控文件夹的水位线，未配置文件夹时只监控收件箱
+func (s *SchedulerService) loadFolderSync(account models.MailAccount) ([]mail.FolderSync, error) {
+	folders := account.Folders
+	if len(folders) == 0 {
+		folders = []string{mail.DefaultFolder}
+	}
+
+	var states []models.FolderState
+	if err := s.db.Where("account_id = ?", account.ID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+
+	syncs := make([]mail.FolderSync, 0, len(folders))
+	for _, folder := range folders {
+		sync := mail.FolderSync{Folder: folder}
+		for _, state := range states {
+			if mail.SameFolder(state.Folder, folder) {
+				sync.UIDValidity, sync.LastUID = state.UIDValidity, state.LastUID
+				break
+			}
+		}
+		syncs = append(syncs, sync)
+	}
+	return syncs, nil
+}
+
+// logClientError 记录邮件客户端错误，TLS握手失败单独归类，提示检查证书配置而不是网络或密码
+func logClientError(action string, account models.MailAccount, err error) {
+	if mail.IsTLSError(err) {
+		log.Printf("[TLS] %s (账户ID: %d): %v，请检查账户的证书校验配置", action, account.ID, err)
+		return
+	}
+	log.Printf("%s (账户ID: %d): %v", action, account.ID, err)
+}
+
 // getActiveAccounts 获取所有活跃账户
 func (s *SchedulerService) getActiveAccounts() ([]models.MailAccount, error) {
 	var accounts []models.MailAccount
@@ -130,16 +550,12 @@ func (s *SchedulerService) createMailClient(account models.MailAccount) (*mail.M
 	mailClient := mail.NewMailClient(s.config)
 
 	// 初始化邮件客户端
-	config := mail.Config{
-		AccountID: account.ID,
-		Provider:  "mail", // 统一使用 mail 类型
-		Address:   account.Address,
-		Username:  account.Username,
-		Password:  account.Password,
-		Server:    account.Server,
-		Settings:  account.Settings,
-		LastUID:   account.LastUID,
+	config := newMailConfig(account, s.oauthService.TokenSource(account))
+	folders, err := s.loadFolderSync(account)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件夹水位线失败: %v", err)
 	}
+	config.Folders = folders
 
 	if err := mailClient.Init(config); err != nil {
 		return nil, err
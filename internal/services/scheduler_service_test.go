@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"mail-dispatcher/internal/mail"
+	"mail-dispatcher/internal/models"
+)
+
+func TestSchedulerService_EnqueuePoll(t *testing.T) {
+	s := &SchedulerService{
+		stopChan: make(chan struct{}),
+		jobs:     make(chan models.MailAccount, 2),
+		polling:  make(map[uint]bool),
+	}
+
+	first := models.MailAccount{ID: 1, Address: "a@example.com"}
+	second := models.MailAccount{ID: 2, Address: "b@example.com"}
+
+	if !s.enqueuePoll(first) || !s.enqueuePoll(first) || !s.enqueuePoll(second) {
+		t.Fatal("enqueuePoll() 在调度器运行时应返回 true")
+	}
+	if len(s.jobs) != 2 {
+		t.Fatalf("重复的账户不应再次排队，队列长度 = %d，期望 2", len(s.jobs))
+	}
+
+	// 轮询完成后允许再次排队
+	<-s.jobs
+	s.finishPoll(first.ID)
+	if !s.enqueuePoll(first) || len(s.jobs) != 2 {
+		t.Fatalf("轮询完成后应重新排队，队列长度 = %d，期望 2", len(s.jobs))
+	}
+
+	// 停止后队列已满，不再阻塞等待工作协程
+	close(s.stopChan)
+	third := models.MailAccount{ID: 3, Address: "c@example.com"}
+	if s.enqueuePoll(third) {
+		t.Error("enqueuePoll() 在调度器停止后应返回 false")
+	}
+	if s.polling[third.ID] {
+		t.Error("未排队的账户不应保留轮询标记")
+	}
+}
+
+func TestSchedulerService_UsesPush(t *testing.T) {
+	updatedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &SchedulerService{idleFallback: map[uint]time.Time{1: updatedAt}}
+
+	if s.usesPush(models.MailAccount{ID: 2, FetchMode: models.FetchModePoll}) {
+		t.Error("轮询模式的账户不应使用推送")
+	}
+	if s.usesPush(models.MailAccount{ID: 1, FetchMode: models.FetchModePush, UpdatedAt: updatedAt}) {
+		t.Error("不支持IDLE的账户应回退到轮询")
+	}
+
+	// 修改账户配置后重新尝试IDLE
+	if !s.usesPush(models.MailAccount{ID: 1, FetchMode: models.FetchModePush, UpdatedAt: updatedAt.Add(time.Minute)}) {
+		t.Error("账户配置修改后应重新使用推送")
+	}
+	if _, ok := s.idleFallback[1]; ok {
+		t.Error("账户配置修改后应清除回退记录")
+	}
+}
+
+func TestHealthUpdates(t *testing.T) {
+	now := time.Now()
+	authErr := fmt.Errorf("IMAP登录失败: %w", &mail.AuthError{Err: errors.New("Invalid credentials")})
+	netErr := errors.New("连接IMAP服务器失败: i/o timeout")
+
+	tests := []struct {
+		name         string
+		authFailures int
+		err          error
+		limit        int
+		wantState    string
+		wantFailures int
+		wantPaused   bool
+	}{
+		{"轮询成功重置计数", 2, nil, 3, models.HealthHealthy, 0, false},
+		{"第一次认证失败", 0, authErr, 3, models.HealthFailing, 1, false},
+		{"达到上限自动停用", 2, authErr, 3, models.HealthPaused, 3, true},
+		{"网络错误不计入认证失败", 2, netErr, 3, models.HealthFailing, 2, false},
+		{"上限为0时不停用", 10, authErr, 0, models.HealthFailing, 11, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := models.MailAccount{ID: 1, AuthFailures: tt.authFailures, IsActive: true}
+			updates := healthUpdates(account, tt.err, tt.limit, now)
+			if updates["health_state"] != tt.wantState {
+				t.Errorf("health_state = %v, 期望 %v", updates["health_state"], tt.wantState)
+			}
+			if updates["auth_failures"] != tt.wantFailures {
+				t.Errorf("auth_failures = %v, 期望 %v", updates["auth_failures"], tt.wantFailures)
+			}
+			if _, paused := updates["is_active"]; paused != tt.wantPaused {
+				t.Errorf("是否停用 = %v, 期望 %v", paused, tt.wantPaused)
+			}
+			if tt.err != nil && updates["last_error"] != tt.err.Error() {
+				t.Errorf("last_error = %v", updates["last_error"])
+			}
+		})
+	}
+}
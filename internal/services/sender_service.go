@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -13,46 +14,60 @@ import (
 
 // SenderService 发送服务
 type SenderService struct {
-	db     *gorm.DB
-	config *config.Config
+	db           *gorm.DB
+	oauthService *OAuthService
+	config       *config.Config
 }
 
 // NewSenderService 创建发送服务
-func NewSenderService(db *gorm.DB, cfg *config.Config) *SenderService {
+func NewSenderService(db *gorm.DB, oauthService *OAuthService, cfg *config.Config) *SenderService {
 	return &SenderService{
-		db:     db,
-		config: cfg,
+		db:           db,
+		oauthService: oauthService,
+		config:       cfg,
 	}
 }
 
-// SendEmail 发送邮件
-func (s *SenderService) SendEmail(email models.Email, toEmail string, accountID uint) error {
+// SendEmail 按转发目标的转发方式发送邮件，maxSize 为转发目标的大小上限，返回因超限未转发的附件
+func (s *SenderService) SendEmail(email models.Email, toEmail string, accountID uint, maxSize int64, mode string) ([]mail.Attachment, error) {
 	// 动态获取账户信息
 	var account models.MailAccount
 	if err := s.db.First(&account, accountID).Error; err != nil {
-		return fmt.Errorf("未找到账户: %d", accountID)
+		return nil, fmt.Errorf("未找到账户: %d", accountID)
 	}
 
-	// 动态创建邮件客户端
-	mailClient, err := s.createMailClient(account)
-	if err != nil {
-		return fmt.Errorf("创建邮件客户端失败: %v", err)
+	// 账户和目标都设置了上限时取较小值
+	limit := account.MaxSize
+	if maxSize > 0 && (limit <= 0 || maxSize < limit) {
+		limit = maxSize
 	}
 
-	// 确保客户端被正确关闭
-	defer func() {
-		if err := mailClient.Stop(); err != nil {
-			log.Printf("停止邮件客户端失败 (账户ID: %d): %v", accountID, err)
+	var dropped []mail.Attachment
+	if limit > 0 && len(email.RawData) > 0 {
+		trimmed, removed, err := mail.LimitMessageSize(email.RawData, limit)
+		if err != nil {
+			return nil, fmt.Errorf("裁剪超限邮件失败: %v", err)
 		}
-	}()
+		email.RawData = trimmed
+		dropped = removed
+	}
+
+	// 发送只需要SMTP连接，不建立IMAP会话
+	sender, err := s.createSender(account)
+	if err != nil {
+		return nil, fmt.Errorf("创建邮件客户端失败: %w", err)
+	}
+
+	if err := sender.SendEmail(email, toEmail, mode); err != nil {
+		return nil, fmt.Errorf("发送邮件失败: %w", err)
+	}
 
-	// 使用邮件客户端发送邮件
-	if err := mailClient.SendEmail(email, toEmail); err != nil {
-		return fmt.Errorf("发送邮件失败: %v", err)
+	if len(dropped) > 0 {
+		log.Printf("邮件超出大小上限，已移除 %d 个附件: %s", len(dropped), email.Subject)
 	}
 
 	log.Printf("邮件发送成功: %s -> %s (账户ID: %d)", email.Subject, toEmail, accountID)
-	return nil
+	return dropped, nil
 }
 
 // SendRawEmail 发送原始邮件数据
@@ -63,21 +78,12 @@ func (s *SenderService) SendRawEmail(rawData []byte, toEmail string, accountID u
 		return fmt.Errorf("未找到账户: %d", accountID)
 	}
 
-	// 动态创建邮件客户端
-	mailClient, err := s.createMailClient(account)
+	sender, err := s.createSender(account)
 	if err != nil {
-		return fmt.Errorf("创建邮件客户端失败: %v", err)
+		return fmt.Errorf("创建邮件客户端失败: %w", err)
 	}
 
-	// 确保客户端被正确关闭
-	defer func() {
-		if err := mailClient.Stop(); err != nil {
-			log.Printf("停止邮件客户端失败 (账户ID: %d): %v", accountID, err)
-		}
-	}()
-
-	// 使用邮件客户端发送原始邮件
-	if err := mailClient.SendRawEmail(rawData, toEmail); err != nil {
+	if err := sender.SendRawEmail(rawData, toEmail); err != nil {
 		return fmt.Errorf("发送原始邮件失败: %v", err)
 	}
 
@@ -85,25 +91,54 @@ func (s *SenderService) SendRawEmail(rawData []byte, toEmail string, accountID u
 	return nil
 }
 
-// createMailClient 动态创建邮件客户端
+// createSender 按账户的SMTP配置、DKIM密钥和信封发件人模式创建只用于发送的客户端
+func (s *SenderService) createSender(account models.MailAccount) (*mail.MailClient, error) {
+	config := newMailConfig(account, s.oauthService.TokenSource(account))
+	if err := s.applySigning(account, &config); err != nil {
+		return nil, err
+	}
+	return mail.NewSender(s.config, config), nil
+}
+
+// createMailClient 动态创建连接到IMAP服务器的邮件客户端，用于重新获取原邮件
 func (s *SenderService) createMailClient(account models.MailAccount) (*mail.MailClient, error) {
 	mailClient := mail.NewMailClient(s.config)
 
 	// 初始化邮件客户端
-	config := mail.Config{
-		AccountID: account.ID,
-		Provider:  "mail", // 统一使用 mail 类型
-		Address:   account.Address,
-		Username:  account.Username,
-		Password:  account.Password,
-		Server:    account.Server,
-		Settings:  account.Settings,
-		LastUID:   account.LastUID,
-	}
-
-	if err := mailClient.Init(config); err != nil {
+	if err := mailClient.Init(newMailConfig(account, s.oauthService.TokenSource(account))); err != nil {
 		return nil, err
 	}
 
 	return mailClient, nil
 }
+
+// applySigning 加载账户域名的DKIM密钥，并按账户的信封发件人模式配置SRS
+func (s *SenderService) applySigning(account models.MailAccount, config *mail.Config) error {
+	domain := mail.EmailDomain(account.Address)
+	if domain == "" {
+		return nil
+	}
+
+	var key models.DKIMKey
+	err := s.db.Where("domain = ? AND is_active = ?", domain, true).First(&key).Error
+	switch {
+	case err == nil:
+		signer, _, err := mail.ParseDKIMKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("加载DKIM密钥失败 (%s): %w", domain, err)
+		}
+		config.DKIM = &mail.DKIMSigner{Domain: key.Domain, Selector: key.Selector, Signer: signer}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("查询DKIM密钥失败: %w", err)
+	}
+
+	// 改写到账户域名会被托管邮箱的提交服务器拒绝，只使用运营方配置的SRS域名
+	if account.EnvelopeMode == models.EnvelopeModeSRS {
+		if !s.config.Security.SRSEnabled() {
+			log.Printf("警告: 未配置 SRS_SECRET 和 SRS_DOMAIN，账户 %s 使用账户地址作为信封发件人", account.Address)
+		} else {
+			config.SRS = &mail.SRS{Secret: []byte(s.config.Security.SRSSecret), Domain: s.config.Security.SRSDomain}
+		}
+	}
+	return nil
+}